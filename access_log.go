@@ -0,0 +1,120 @@
+package main
+
+import (
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxRequestIDLength is the longest request ID accepted from a client or load balancer
+const maxRequestIDLength = 128
+
+// isValidRequestID is true when the request ID sent by a client or load balancer is safe to use. Anything else is
+// replaced, so it can't be used to forge log lines or inject markup into the error pages
+func isValidRequestID(requestID string) bool {
+	if len(requestID) <= 0 || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range requestID {
+		isAlphanumeric := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		if !isAlphanumeric && r != '-' && r != '_' && r != '.' && r != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID creates an ID for a request which didn't come with one, in the same format as submission IDs
+func newRequestID() (string, error) {
+	return newSubmissionID()
+}
+
+// requestID is the ID of the request being handled, it is empty before the request ID middleware has run
+func requestID(c echo.Context) string {
+	return services.RequestIDFromContext(c.Request().Context())
+}
+
+// newRequestIDMiddleware creates the middleware identifying every request, with the X-Request-ID sent by the client or
+// load balancer if it is valid or a new one otherwise. The ID is returned in the X-Request-ID response header and
+// carried by the request's context, so it can be added to every log line written while handling the request
+func newRequestIDMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+
+			id := request.Header.Get(echo.HeaderXRequestID)
+			if !isValidRequestID(id) {
+				var err error
+				id, err = newRequestID()
+				if err != nil {
+					return err
+				}
+			}
+
+			c.SetRequest(request.WithContext(services.ContextWithRequestID(request.Context(), id)))
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			return next(c)
+		}
+	}
+}
+
+// clientIP is the address of the client making the request. Anyone can send X-Forwarded-For, so it is only used when the
+// config says every request comes through a proxy which sets or strips it
+func clientIP(appCtx *AppContext, c echo.Context) string {
+	if appCtx.CurrentConfig().TrustForwardedFor {
+		return c.RealIP()
+	}
+
+	remoteAddr := c.Request().RemoteAddr
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// withoutQuery returns the URL without its query string or fragment, which may contain personal details
+func withoutQuery(rawURL string) string {
+	if end := strings.IndexAny(rawURL, "?#"); end >= 0 {
+		return rawURL[:end]
+	}
+	return rawURL
+}
+
+// newAccessLogMiddleware creates the middleware logging every request once it has been handled. The query strings of
+// the request and its referrer aren't logged as they may contain personal details. It comes before the error handling middleware, so the status of the
+// error page is the one logged
+func newAccessLogMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	logger := appCtx.Logger
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			request := c.Request()
+			response := c.Response()
+			fields := services.Fields{
+				"method":     request.Method,
+				"path":       request.URL.Path,
+				"status":     response.Status,
+				"bytes":      response.Size,
+				"durationMs": float64(time.Since(start)) / float64(time.Millisecond),
+				"ip":         clientIP(appCtx, c),
+				"userAgent":  request.UserAgent(),
+				"referrer":   withoutQuery(request.Referer()),
+			}
+
+			requestLogger := logger.WithContext(request.Context())
+			if response.Status >= http.StatusInternalServerError {
+				requestLogger.Error("Handled request", fields)
+			} else {
+				requestLogger.Info("Handled request", fields)
+			}
+			return err
+		}
+	}
+}
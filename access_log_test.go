@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/mocks"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveWithRequestID serves the request with the request ID middleware, returning the response and the request ID the
+// handler saw
+func serveWithRequestID(request *http.Request) (*httptest.ResponseRecorder, string) {
+	e := echo.New()
+	e.Use(newRequestIDMiddleware())
+
+	var handlerRequestID string
+	e.GET("/", func(c echo.Context) error {
+		handlerRequestID = requestID(c)
+		return c.String(http.StatusOK, "ok")
+	})
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder, handlerRequestID
+}
+
+func TestARequestIDIsAcceptedFromTheClient(t *testing.T) {
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(echo.HeaderXRequestID, "lb-1234:abcd")
+
+	recorder, handlerRequestID := serveWithRequestID(request)
+	assert.Equal(t, "lb-1234:abcd", handlerRequestID)
+	assert.Equal(t, "lb-1234:abcd", recorder.Header().Get(echo.HeaderXRequestID))
+}
+
+func TestARequestIDIsGeneratedWhenTheClientDoesntSendAValidOne(t *testing.T) {
+	for _, sent := range []string{"", "<script>alert(1)</script>", strings.Repeat("a", maxRequestIDLength+1)} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set(echo.HeaderXRequestID, sent)
+
+		recorder, handlerRequestID := serveWithRequestID(request)
+		assert.NotEqual(t, sent, handlerRequestID)
+		assert.True(t, isValidRequestID(handlerRequestID), "generated request ID '%s' is not valid", handlerRequestID)
+		assert.Equal(t, handlerRequestID, recorder.Header().Get(echo.HeaderXRequestID))
+	}
+}
+
+func TestEveryRequestIsLogged(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("WithContext", mock.Anything).Return(logger)
+	logger.On("Info", mock.Anything, mock.Anything).Return()
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+
+	e := echo.New()
+	e.Use(newRequestIDMiddleware())
+	e.Use(newAccessLogMiddleware(&AppContext{Config: &domain.AppConfig{}, Logger: logger}))
+	e.Use(newErrorHandlingMiddleware())
+	e.GET("/contact", func(c echo.Context) error {
+		return c.String(http.StatusOK, "contact")
+	})
+
+	request := httptest.NewRequest("GET", "/contact?email=bob@someemail.com", nil)
+	request.Header.Set(echo.HeaderXRequestID, "request-1")
+	request.Header.Set("User-Agent", "test-agent")
+	request.Header.Set("Referer", "https://www.seacitysoftware.com/contact?email=bob@someemail.com#form")
+	request.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+	request.RemoteAddr = "192.0.2.1:1234"
+	e.ServeHTTP(httptest.NewRecorder(), request)
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	if !assert.Len(t, logger.Calls, 4) {
+		return
+	}
+
+	// The lines are written with the request's logger
+	assert.Equal(t, "WithContext", logger.Calls[0].Method)
+	assert.Equal(t, "request-1", services.RequestIDFromContext(logger.Calls[0].Arguments.Get(0).(context.Context)))
+
+	assert.Equal(t, "Info", logger.Calls[1].Method)
+	fields := logger.Calls[1].Arguments.Get(1).(services.Fields)
+	assert.Equal(t, "GET", fields["method"])
+	assert.Equal(t, "/contact", fields["path"], "the query string should not be logged")
+	assert.Equal(t, http.StatusOK, fields["status"])
+	assert.Equal(t, int64(len("contact")), fields["bytes"])
+	assert.Contains(t, fields, "durationMs")
+	assert.Equal(t, "192.0.2.1", fields["ip"], "the X-Forwarded-For header should not be trusted")
+	assert.Equal(t, "test-agent", fields["userAgent"])
+	assert.Equal(t, "https://www.seacitysoftware.com/contact", fields["referrer"], "the referrer's query string should not be logged")
+
+	// The status of the error page is logged
+	fields = logger.Calls[3].Arguments.Get(1).(services.Fields)
+	assert.Equal(t, http.StatusNotFound, fields["status"])
+}
+
+func TestTheClientIPIsTheAddressConnectedFromUnlessForwardedForIsTrusted(t *testing.T) {
+	e := echo.New()
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "198.51.100.1:54321"
+	request.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+	c := e.NewContext(request, httptest.NewRecorder())
+
+	assert.Equal(t, "198.51.100.1", clientIP(&AppContext{Config: &domain.AppConfig{}}, c))
+	assert.Equal(t, "203.0.113.7", clientIP(&AppContext{Config: &domain.AppConfig{TrustForwardedFor: true}}, c))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The Cache-Control sent with the public files. A fingerprinted URL changes whenever the file does, so browsers can keep
+// it forever without asking again. Files requested by their plain path, e.g. the images the stylesheet refers to, are
+// checked with the server before every use
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// assetFingerprintLength is the number of hex characters of the file's hash put in its fingerprinted URL
+const assetFingerprintLength = 12
+
+// UnknownAssetError is returned to a template asking for a file which isn't in the public dir
+const UnknownAssetError = "unknown asset"
+
+// fingerprintedAssetPattern matches a fingerprinted URL, capturing the plain path without the extension, the
+// fingerprint and the extension, e.g. /css/styles.0123456789ab.css
+var fingerprintedAssetPattern = regexp.MustCompile(fmt.Sprintf(`^(.*)\.([0-9a-f]{%d})(\.[^./]+)$`, assetFingerprintLength))
+
+// asset is a file in the public dir
+type asset struct {
+	// name is the file's name in the frontend's files, e.g. public/css/styles.css
+	name string
+
+	// fingerprintedPath is the URL the file is served at forever, e.g. /css/styles.0123456789ab.css
+	fingerprintedPath string
+
+	// etag identifies the file's contents, so a browser revalidating its plain path is told it hasn't changed
+	etag string
+
+	// integrity is the Subresource Integrity hash of the file, e.g. sha384-...
+	integrity string
+
+	// content is the file as it was hashed, it is served rather than reopening the file so an override edited after
+	// start up isn't served under the old fingerprint and integrity
+	content []byte
+
+	// modTime is when the file hashed was last modified, zero for embedded files
+	modTime time.Time
+
+	// size is the size of the file in bytes, before it is compressed
+	size int
+
+	// compressed are the file's contents compressed with each encoding, when they're smaller
+	compressed map[string][]byte
+}
+
+// assetManifest is every file in the public dir, read and hashed on start up
+type assetManifest struct {
+	// byPath are the assets by their plain path, e.g. /css/styles.css
+	byPath map[string]*asset
+
+	// byFingerprintedPath are the assets by their fingerprinted path
+	byFingerprintedPath map[string]*asset
+}
+
+// newAssetManifest reads and hashes every file in the frontend's public dir. Hidden files, such as .gitkeep, aren't
+// served so are skipped. Compressed copies of a file, e.g. styles.css.br, are served in its place to clients accepting
+// them rather than on their own. Text-based files without them are compressed here when asked to precompress
+func newAssetManifest(frontend fs.FS, precompress bool) (*assetManifest, error) {
+	manifest := &assetManifest{
+		byPath:              make(map[string]*asset),
+		byFingerprintedPath: make(map[string]*asset),
+	}
+
+	contents := make(map[string][]byte)
+	modTimes := make(map[string]time.Time)
+	err := fs.WalkDir(frontend, publicDir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		modTimes[name] = info.ModTime()
+
+		contents[name], err = fs.ReadFile(frontend, name)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to hash the public files")
+	}
+
+	for name, fileContents := range contents {
+		if isCompressedCopy(name, contents) {
+			continue
+		}
+
+		plainPath := strings.TrimPrefix(name, publicDir)
+		fingerprint := sha256.Sum256(fileContents)
+		integrity := sha512.Sum384(fileContents)
+		extension := path.Ext(plainPath)
+		shortFingerprint := hex.EncodeToString(fingerprint[:])[:assetFingerprintLength]
+
+		publicAsset := &asset{
+			name:              name,
+			fingerprintedPath: fmt.Sprintf("%s.%s%s", strings.TrimSuffix(plainPath, extension), shortFingerprint, extension),
+			etag:              fmt.Sprintf(`"%s"`, shortFingerprint),
+			integrity:         "sha384-" + base64.StdEncoding.EncodeToString(integrity[:]),
+			content:           fileContents,
+			modTime:           modTimes[name],
+			size:              len(fileContents),
+			compressed:        make(map[string][]byte),
+		}
+
+		for _, encoding := range encodings {
+			compressed, isBuilt := contents[name+encodingExtensions[encoding]]
+			if !isBuilt && precompress && isCompressible(mime.TypeByExtension(extension)) {
+				compressed, err = compress(encoding, fileContents)
+				if err != nil {
+					return nil, errors.Wrapf(err, "unable to compress '%s'", name)
+				}
+			}
+			if len(compressed) > 0 && len(compressed) < len(fileContents) {
+				publicAsset.compressed[encoding] = compressed
+			}
+		}
+
+		manifest.byPath[plainPath] = publicAsset
+		manifest.byFingerprintedPath[publicAsset.fingerprintedPath] = publicAsset
+	}
+	return manifest, nil
+}
+
+// isCompressedCopy is true when the file is a compressed copy of another of the files, e.g. styles.css.br
+func isCompressedCopy(name string, contents map[string][]byte) bool {
+	for _, extension := range encodingExtensions {
+		if _, isCopied := contents[strings.TrimSuffix(name, extension)]; strings.HasSuffix(name, extension) && isCopied {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the asset with the plain path, which may be given without its leading slash
+func (manifest *assetManifest) lookup(name string) (*asset, error) {
+	publicAsset, isFound := manifest.byPath[path.Join("/", name)]
+	if !isFound {
+		return nil, fmt.Errorf("%s: '%s'", UnknownAssetError, name)
+	}
+	return publicAsset, nil
+}
+
+// templateFuncs are the functions the templates use to refer to the public files:
+//
+//	{{ asset "/css/styles.css" }} is the fingerprinted URL of the file
+//	{{ assetIntegrity "/css/styles.css" }} is its Subresource Integrity hash, for the integrity attribute
+//
+// Asking for a file which isn't in the public dir fails the render, so a typo isn't served as a broken page
+func (manifest *assetManifest) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"asset": func(name string) (string, error) {
+			publicAsset, err := manifest.lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return publicAsset.fingerprintedPath, nil
+		},
+		"assetIntegrity": func(name string) (string, error) {
+			publicAsset, err := manifest.lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return publicAsset.integrity, nil
+		},
+	}
+}
+
+// serve writes the asset as it was hashed, compressed when the client accepts one of its compressed copies and it is
+// big enough to be compressed, answering a conditional request for it with a 304 when it hasn't changed
+func (manifest *assetManifest) serve(c echo.Context, publicAsset *asset, compressionConfig *domain.CompressionConfig) error {
+	content := bytes.NewReader(publicAsset.content)
+
+	header := c.Response().Header()
+	header.Set("ETag", publicAsset.etag)
+	if compressionConfig.Enabled && len(publicAsset.compressed) > 0 && int64(publicAsset.size) >= compressionConfig.MinSize {
+		addVary(header, echo.HeaderAcceptEncoding)
+
+		available := make([]string, 0, len(encodings))
+		for _, encoding := range encodings {
+			if _, isCompressed := publicAsset.compressed[encoding]; isCompressed {
+				available = append(available, encoding)
+			}
+		}
+
+		// Each encoding is a different representation of the file so has its own ETag
+		encoding := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding), available...)
+		if len(encoding) > 0 {
+			header.Set(echo.HeaderContentEncoding, encoding)
+			header.Set("ETag", encodedETag(publicAsset.etag, encoding))
+			content = bytes.NewReader(publicAsset.compressed[encoding])
+		}
+	}
+
+	http.ServeContent(c.Response(), c.Request(), path.Base(publicAsset.name), publicAsset.modTime, content)
+	return nil
+}
+
+// parseTemplates parses the frontend's views with the asset functions available to them
+func parseTemplates(frontend fs.FS, manifest *assetManifest) (*template.Template, error) {
+	return template.New(viewsDir).Funcs(manifest.templateFuncs()).ParseFS(frontend, path.Join(viewsDir, "*.html"))
+}
+
+// newPublicFileHandler serves the files in the public dir. Fingerprinted URLs are cached forever, anything else is
+// served from its plain path and revalidated. A fingerprint for an older version of a file, e.g. from a page rendered
+// before a deploy, is served the current file rather than a 404, but isn't cached as it may change again
+func newPublicFileHandler(appCtx *AppContext, manifest *assetManifest) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name, err := url.PathUnescape(c.Param("*"))
+		if err != nil {
+			return echo.ErrNotFound
+		}
+		requestPath := path.Clean("/" + name)
+
+		header := c.Response().Header()
+		if publicAsset, isFingerprinted := manifest.byFingerprintedPath[requestPath]; isFingerprinted {
+			header.Set("Cache-Control", immutableCacheControl)
+			return manifest.serve(c, publicAsset, currentCompressionConfig(appCtx))
+		}
+
+		publicAsset, isFound := manifest.byPath[requestPath]
+		if match := fingerprintedAssetPattern.FindStringSubmatch(requestPath); !isFound && match != nil {
+			publicAsset, isFound = manifest.byPath[match[1]+match[3]]
+		}
+		if !isFound {
+			return echo.ErrNotFound
+		}
+		header.Set("Cache-Control", revalidateCacheControl)
+		return manifest.serve(c, publicAsset, currentCompressionConfig(appCtx))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// testFrontend has a stylesheet and a hidden file in its public dir
+var testFrontend = fstest.MapFS{
+	"public/css/styles.css": {Data: []byte("body { color: #333; }")},
+	"public/.gitkeep":       {Data: []byte{}},
+}
+
+// servePublicFile requests a path from the public file handler, with the ETag of the copy the browser has if it has one
+func servePublicFile(manifest *assetManifest, path string, etag string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.GET("/*", newPublicFileHandler(&AppContext{Config: &domain.AppConfig{}}, manifest))
+
+	request := httptest.NewRequest("GET", path, nil)
+	if len(etag) > 0 {
+		request.Header.Set("If-None-Match", etag)
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestTheAssetFunctionsGiveTheFingerprintedURLAndIntegrity(t *testing.T) {
+	manifest, err := newAssetManifest(testFrontend, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	page := template.Must(template.New("page").Funcs(manifest.templateFuncs()).Parse(
+		`<link rel="stylesheet" href="{{ asset "/css/styles.css" }}" integrity="{{ assetIntegrity "css/styles.css" }}">`))
+	var rendered bytes.Buffer
+	assert.NoError(t, page.Execute(&rendered, nil))
+
+	// The + of the hash is escaped in the attribute, browsers read it as a +
+	assert.Equal(t, `<link rel="stylesheet" href="/css/styles.76456d98f075.css" `+
+		`integrity="sha384-&#43;BJAQswJxAKw/XKfmT6Vf&#43;Hd2N9iDdgZ6dZp3t8QWaXvIH5i03h39cLZKghQa6Es">`, rendered.String())
+}
+
+func TestAnUnknownAssetFailsTheRender(t *testing.T) {
+	manifest, err := newAssetManifest(testFrontend, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	page := template.Must(template.New("page").Funcs(manifest.templateFuncs()).Parse(`{{ asset "/css/missing.css" }}`))
+	err = page.Execute(ioutil.Discard, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), UnknownAssetError)
+	}
+}
+
+func TestFingerprintedURLsAreCachedForever(t *testing.T) {
+	manifest, err := newAssetManifest(testFrontend, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	fingerprinted := servePublicFile(manifest, manifest.byPath["/css/styles.css"].fingerprintedPath, "")
+	assert.Equal(t, http.StatusOK, fingerprinted.Code)
+	assert.Equal(t, immutableCacheControl, fingerprinted.Header().Get("Cache-Control"))
+	assert.Equal(t, "body { color: #333; }", fingerprinted.Body.String())
+
+	plain := servePublicFile(manifest, "/css/styles.css", "")
+	assert.Equal(t, http.StatusOK, plain.Code)
+	assert.Equal(t, revalidateCacheControl, plain.Header().Get("Cache-Control"))
+
+	// Revalidating an unchanged file doesn't send it again
+	revalidated := servePublicFile(manifest, "/css/styles.css", plain.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, revalidated.Code)
+}
+
+func TestAnOutdatedFingerprintIsServedTheCurrentFileWithoutCaching(t *testing.T) {
+	manifest, err := newAssetManifest(testFrontend, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	outdated := servePublicFile(manifest, "/css/styles.0123456789ab.css", "")
+	assert.Equal(t, http.StatusOK, outdated.Code)
+	assert.Equal(t, revalidateCacheControl, outdated.Header().Get("Cache-Control"))
+	assert.Equal(t, "body { color: #333; }", outdated.Body.String())
+}
+
+func TestHiddenAndMissingFilesAreNotServed(t *testing.T) {
+	manifest, err := newAssetManifest(testFrontend, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NotContains(t, manifest.byPath, "/.gitkeep")
+	assert.Equal(t, http.StatusNotFound, servePublicFile(manifest, "/.gitkeep", "").Code)
+	assert.Equal(t, http.StatusNotFound, servePublicFile(manifest, "/css/missing.css", "").Code)
+	assert.Equal(t, http.StatusNotFound, servePublicFile(manifest, "/../assets.go", "").Code)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// reportsPath is where browsers send CSP violation and network error reports
+	reportsPath = "/reports"
+
+	// adminReportsPath shows the reports browsers have sent
+	adminReportsPath = "/admin/reports"
+
+	// adminRealm is the realm browsers are asked to log in to the admin area for
+	adminRealm = "Admin"
+
+	// nelReportingGroup is the Reporting API group network errors are sent to
+	nelReportingGroup = "network-errors"
+
+	// adminMaxFailedLogins is how many times a client can give the wrong admin credentials before it is locked out
+	adminMaxFailedLogins = 5
+
+	// adminLoginWindow is how long a client's failed admin logins are counted for, and so the longest it is locked
+	// out for
+	adminLoginWindow = 15 * time.Minute
+)
+
+// defaultReportingConfig is used when the config has no reporting settings
+var defaultReportingConfig = &domain.ReportingConfig{
+	RateLimit:    30,
+	DedupeWindow: 10 * time.Minute,
+	MaxReports:   1000,
+}
+
+// Network Error Logging headers
+const (
+	headerReportTo = "Report-To"
+	headerNEL      = "NEL"
+)
+
+// reportingGroup is a group of endpoints in the Report-To header
+type reportingGroup struct {
+	Group     string              `json:"group"`
+	MaxAge    int64               `json:"max_age"`
+	Endpoints []map[string]string `json:"endpoints"`
+}
+
+// nelPolicy is the NEL header
+type nelPolicy struct {
+	ReportTo string `json:"report_to"`
+	MaxAge   int64  `json:"max_age"`
+}
+
+// newNELMiddleware creates the middleware asking browsers to report network errors to the reports endpoint. The
+// endpoint must be absolute, so nothing is sent without the site's base URL. The policy is read from the current
+// config, so it can be changed by reloading the config
+func newNELMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			appConfig := appCtx.CurrentConfig()
+			if appConfig.Reporting == nil || appConfig.Reporting.NelMaxAge <= 0 || appConfig.Site == nil || len(appConfig.Site.BaseURL) <= 0 {
+				return next(c)
+			}
+
+			maxAge := int64(appConfig.Reporting.NelMaxAge.Seconds())
+			reportTo, err := json.Marshal(&reportingGroup{
+				Group:     nelReportingGroup,
+				MaxAge:    maxAge,
+				Endpoints: []map[string]string{{"url": appConfig.Site.BaseURL + reportsPath}},
+			})
+			if err != nil {
+				return err
+			}
+
+			nel, err := json.Marshal(&nelPolicy{ReportTo: nelReportingGroup, MaxAge: maxAge})
+			if err != nil {
+				return err
+			}
+
+			header := c.Response().Header()
+			header.Set(headerReportTo, string(reportTo))
+			header.Set(headerNEL, string(nel))
+			return next(c)
+		}
+	}
+}
+
+// newBrowserReportsHandler creates the handler counting the CSP violation and network error reports browsers send.
+// Reports which can't be parsed are rejected with a 400 and a 429 is returned when the client is over its rate limit,
+// though browsers don't retry either
+func newBrowserReportsHandler(appCtx *AppContext, store *services.BrowserReportStore) echo.HandlerFunc {
+	logger := appCtx.Logger
+	return func(c echo.Context) error {
+		request := c.Request()
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			return err
+		}
+
+		reports, err := services.ParseBrowserReports(request.Header.Get(echo.HeaderContentType), body)
+		if err != nil {
+			logger.WithContext(request.Context()).Debug("Unable to parse browser reports", services.Fields{"error": err.Error()})
+			return c.NoContent(http.StatusBadRequest)
+		}
+
+		client := clientIP(appCtx, c)
+		rateLimited := false
+		for _, report := range reports {
+			if store.Record(request.Context(), client, report) == services.BrowserReportRateLimited {
+				rateLimited = true
+			}
+		}
+
+		if rateLimited {
+			return c.NoContent(http.StatusTooManyRequests)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// newAdminAuthMiddleware creates the middleware protecting the admin area with HTTP basic auth. The admin area is
+// hidden behind a 404 when it has no password. A client giving the wrong credentials too many times is answered with a
+// 429 until its window ends, even if it then gets them right, so the password can't be guessed. The credentials are
+// read from the current config, so they can be changed by reloading the config
+func newAdminAuthMiddleware(appCtx *AppContext, limiter *services.FailedLoginLimiter) echo.MiddlewareFunc {
+	logger := appCtx.Logger
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			adminConfig := appCtx.CurrentConfig().Admin
+			if adminConfig == nil || !adminConfig.Enabled() {
+				return echo.ErrNotFound
+			}
+
+			// Failed logins are counted for each username a client tries, so clients sharing an address, e.g. when
+			// forwarded headers aren't trusted behind a load balancer, can't lock each other out without the username
+			client := clientIP(appCtx, c)
+			username, password, isFound := c.Request().BasicAuth()
+			loginKey := client + " " + username
+			if lockedOut := limiter.LockedOut(loginKey); lockedOut > 0 {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedOut.Seconds()))))
+				return c.String(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+			}
+
+			usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(adminConfig.Username)) == 1
+			passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(adminConfig.Password.Reveal())) == 1
+			if !isFound || !usernameMatches || !passwordMatches {
+				// Browsers ask without credentials before prompting for them, that isn't a failed login
+				if isFound && limiter.Failed(loginKey) {
+					logger.WithContext(c.Request().Context()).Warn("Locked a client out of the admin area after too many failed logins", services.Fields{"ip": client})
+				}
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, fmt.Sprintf("Basic realm=%q", adminRealm))
+				return c.String(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			}
+			limiter.Succeeded(loginKey)
+
+			// Nothing in the admin area should be kept by caches or indexed
+			c.Response().Header().Set("Cache-Control", "no-store")
+			c.Response().Header().Set("X-Robots-Tag", "noindex")
+			return next(c)
+		}
+	}
+}
+
+// newAdminReportsPageHandler creates the handler showing how many times each report has been counted
+func newAdminReportsPageHandler(appCtx *AppContext, store *services.BrowserReportStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, "admin-reports.html", newPageView(appCtx, c, "Browser reports", "", map[string]interface{}{
+			"Reports": store.Counts(),
+		}))
+	}
+}
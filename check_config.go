@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"io"
+)
+
+// checkConfigCommand is the subcommand which checks the config and exits, so deployments can fail fast
+const checkConfigCommand = "check-config"
+
+// runCheckConfig loads and validates the config from the args, prints every resolved value and every problem found
+// and returns the exit code, which is non-zero if the config is invalid
+func runCheckConfig(args []string, logger services.Logger, out io.Writer) int {
+	configService := services.NewLayeredConfigService(logger, args)
+
+	_, err := configService.TryLoadConfig()
+
+	dump := configService.Dump()
+	if len(dump) > 0 {
+		fmt.Fprintln(out, "Resolved config:")
+		fmt.Fprintln(out, dump)
+		fmt.Fprintln(out)
+	}
+
+	if err != nil {
+		fmt.Fprintln(out, err.Error())
+		return 1
+	}
+
+	fmt.Fprintln(out, "config is valid")
+	return 0
+}
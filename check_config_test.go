@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckConfigFailsWithEveryProblem(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	exitCode := runCheckConfig([]string{"-http-port", "0", "-email-sender", "nobody"}, newLogger(), out)
+
+	assert.Equal(t, 1, exitCode)
+	assert.Contains(t, out.String(), "http.port:")
+	assert.Contains(t, out.String(), "email.sender:")
+	assert.Contains(t, out.String(), "recaptcha.secret:")
+	assert.Contains(t, out.String(), "site.base_url:")
+}
+
+// validConfigFlags set every required setting, leaving the rest to their defaults
+var validConfigFlags = []string{
+	"-frontend-dir", ".",
+	"-email-sender", "sender@seacitysoftware.com",
+	"-email-recipient", "recipient@seacitysoftware.com",
+	"-email-aws-ses-region", "eu-west-1",
+	"-email-aws-ses-access-key", "access-key",
+	"-email-aws-ses-secret-key", "ses-hunter2",
+	"-recaptcha-secret", "recaptcha-hunter2",
+	"-recaptcha-site-key", "recaptcha-site-key",
+	"-site-name", "Sea City Software",
+	"-site-base-url", "https://www.seacitysoftware.com",
+	"-site-company-number", "11117271",
+	"-site-copyright-holder", "Sea City Software Ltd",
+	"-site-contact-email", "info@seacitysoftware.com",
+}
+
+func TestCheckConfigPassesWithAValidConfig(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	exitCode := runCheckConfig(validConfigFlags, newLogger(), out)
+
+	assert.Equal(t, 0, exitCode, out.String())
+	assert.Contains(t, out.String(), "config is valid")
+	assert.NotContains(t, out.String(), "hunter2")
+}
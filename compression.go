@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The content codings responses are compressed with, in the order they're preferred when a client accepts both equally
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// encodings are the supported content codings, most preferred first
+var encodings = []string{encodingBrotli, encodingGzip}
+
+// encodingExtensions are the extensions of the compressed copies of the public files
+var encodingExtensions = map[string]string{
+	encodingBrotli: ".br",
+	encodingGzip:   ".gz",
+}
+
+// Responses are compressed as they're written at a level which is quick enough for every request, the public files are
+// compressed once on start up so at the best level
+const (
+	dynamicBrotliLevel = 5
+	dynamicGzipLevel   = gzip.DefaultCompression
+	staticBrotliLevel  = brotli.BestCompression
+	staticGzipLevel    = gzip.BestCompression
+)
+
+// compressibleContentTypes are the media types, other than text/*, JSON and XML, worth compressing. Images other than
+// SVG, fonts and archives are already compressed
+var compressibleContentTypes = map[string]bool{
+	"application/javascript":   true,
+	"application/wasm":         true,
+	"image/svg+xml":            true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// isCompressible is true when responses with the content type are worth compressing
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") || compressibleContentTypes[mediaType]
+}
+
+// negotiateEncoding returns the encoding, of those available, the Accept-Encoding prefers, or an empty string when none
+// are acceptable. Encodings the client weights equally are chosen in the order they're available
+func negotiateEncoding(acceptEncoding string, available ...string) string {
+	weights := make(map[string]float64)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(entry, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(coding) <= 0 {
+			continue
+		}
+
+		weight := 1.0
+		for _, parameter := range parts[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(parameter, "q="), 64)
+				if err != nil {
+					q = 0
+				}
+				weight = q
+			}
+		}
+		weights[coding] = weight
+	}
+
+	chosen, chosenWeight := "", 0.0
+	for _, encoding := range available {
+		weight, isListed := weights[encoding]
+		if !isListed {
+			weight = weights["*"]
+		}
+		if weight > chosenWeight {
+			chosen, chosenWeight = encoding, weight
+		}
+	}
+	return chosen
+}
+
+// addVary adds the request header to the Vary header, unless it is already there
+func addVary(header http.Header, requestHeader string) {
+	for _, vary := range header[echo.HeaderVary] {
+		for _, name := range strings.Split(vary, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" || strings.EqualFold(name, requestHeader) {
+				return
+			}
+		}
+	}
+	header.Add(echo.HeaderVary, requestHeader)
+}
+
+// newEncoder creates the writer compressing what is written to it with the encoding
+func newEncoder(encoding string, w io.Writer, brotliLevel int, gzipLevel int) io.WriteCloser {
+	if encoding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotliLevel)
+	}
+	encoder, _ := gzip.NewWriterLevel(w, gzipLevel)
+	return encoder
+}
+
+// compress returns the contents compressed with the encoding at the best level, for the public files
+func compress(encoding string, contents []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	encoder := newEncoder(encoding, &compressed, staticBrotliLevel, staticGzipLevel)
+	if _, err := encoder.Write(contents); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// newCompressionMiddleware creates the middleware compressing responses for clients which accept brotli or gzip.
+// Only text-based responses of at least the configured size are compressed, so the response is held back until that
+// much has been written. Responses which are already encoded, e.g. the precompressed public files, are left alone. The
+// config is read for every request, so reloading it changes the compression
+func newCompressionMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			compressionConfig := currentCompressionConfig(appCtx)
+			if !compressionConfig.Enabled {
+				return next(c)
+			}
+
+			request := c.Request()
+			encoding := negotiateEncoding(request.Header.Get(echo.HeaderAcceptEncoding), encodings...)
+			isRevalidatingEncoded := false
+			if ifNoneMatch := request.Header.Get("If-None-Match"); len(encoding) > 0 && len(ifNoneMatch) > 0 {
+				decoded := withDecodedETags(ifNoneMatch, encoding)
+				isRevalidatingEncoded = decoded != ifNoneMatch
+				request.Header.Set("If-None-Match", decoded)
+			}
+
+			response := c.Response()
+			writer := &compressingResponseWriter{
+				ResponseWriter:        response.Writer,
+				encoding:              encoding,
+				isRevalidatingEncoded: isRevalidatingEncoded,
+				minSize:               int(compressionConfig.MinSize),
+			}
+			response.Writer = writer
+			defer func() {
+				writer.Close()
+				response.Writer = writer.ResponseWriter
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+// encodedETag is the ETag of a representation compressed with the encoding. It is a different representation, so a
+// strong ETag can't be shared with the uncompressed one, e.g. "abc" becomes "abc-br" and W/"abc" becomes W/"abc-br"
+func encodedETag(etag string, encoding string) string {
+	weak := ""
+	if strings.HasPrefix(etag, "W/") {
+		weak, etag = "W/", etag[2:]
+	}
+	return weak + `"` + strings.Trim(etag, `"`) + "-" + encoding + `"`
+}
+
+// withDecodedETags adds the ETag a handler knows the uncompressed representation by for each ETag of one compressed
+// with the encoding, so a client revalidating a response compressed on the fly can still be told it is unchanged
+func withDecodedETags(ifNoneMatch string, encoding string) string {
+	suffix := "-" + encoding + `"`
+	decoded := ifNoneMatch
+	for _, etag := range strings.Split(ifNoneMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if strings.HasSuffix(etag, suffix) {
+			decoded += ", " + strings.TrimSuffix(etag, suffix) + `"`
+		}
+	}
+	return decoded
+}
+
+// compressingResponseWriter holds the response back until it is big enough to be worth compressing or is finished,
+// then decides whether to compress it
+type compressingResponseWriter struct {
+	http.ResponseWriter
+
+	// encoding is what the client prefers, it is empty when it doesn't accept either
+	encoding string
+
+	// isRevalidatingEncoded is true when the client is revalidating a copy it was sent compressed with the encoding
+	isRevalidatingEncoded bool
+
+	minSize int
+
+	// statusCode is the status written by the handler, zero until it is written
+	statusCode int
+
+	// buffered is what has been written before the decision was made
+	buffered []byte
+
+	// isDecided is true once the headers have been written
+	isDecided bool
+
+	// encoder compresses what is written, it is nil when the response isn't compressed
+	encoder io.WriteCloser
+}
+
+// WriteHeader holds the status back until it is decided whether the response is compressed
+func (w *compressingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *compressingResponseWriter) Write(b []byte) (int, error) {
+	if !w.isDecided {
+		w.buffered = append(w.buffered, b...)
+		if len(w.buffered) < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the headers, compressing the response when it is text-based, big enough and isn't already encoded,
+// then writes what has been held back
+func (w *compressingResponseWriter) decide() error {
+	w.isDecided = true
+	header := w.Header()
+	if len(header.Get(echo.HeaderContentType)) <= 0 && len(w.buffered) > 0 {
+		header.Set(echo.HeaderContentType, http.DetectContentType(w.buffered))
+	}
+
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	hasBody := statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+	isEncoded := len(header.Get(echo.HeaderContentEncoding)) > 0 || len(header.Get("Content-Range")) > 0 ||
+		strings.Contains(header.Get("Cache-Control"), "no-transform")
+
+	if hasBody && !isEncoded && isCompressible(header.Get(echo.HeaderContentType)) {
+		addVary(header, echo.HeaderAcceptEncoding)
+		if len(w.encoding) > 0 && len(w.buffered) >= w.minSize && len(w.buffered) > 0 {
+			header.Set(echo.HeaderContentEncoding, w.encoding)
+			header.Del(echo.HeaderContentLength)
+			if etag := header.Get("ETag"); len(etag) > 0 {
+				header.Set("ETag", encodedETag(etag, w.encoding))
+			}
+			w.encoder = newEncoder(w.encoding, w.ResponseWriter, dynamicBrotliLevel, dynamicGzipLevel)
+		}
+	}
+
+	// A 304 must be sent the headers the compressed copy being revalidated was, or it replaces the copy's ETag
+	if statusCode == http.StatusNotModified && w.isRevalidatingEncoded {
+		addVary(header, echo.HeaderAcceptEncoding)
+		if etag := header.Get("ETag"); len(etag) > 0 && !strings.HasSuffix(etag, "-"+w.encoding+`"`) {
+			header.Set("ETag", encodedETag(etag, w.encoding))
+		}
+	}
+
+	if w.statusCode != 0 {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+
+	buffered := w.buffered
+	w.buffered = nil
+	if len(buffered) <= 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buffered)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buffered)
+	return err
+}
+
+// Flush sends what has been written so far, deciding whether to compress the response if it hasn't been yet
+func (w *compressingResponseWriter) Flush() {
+	if !w.isDecided {
+		w.decide()
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over, e.g. for a websocket, which is never compressed
+func (w *compressingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.isDecided = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// Close finishes the response, deciding whether to compress it if it is smaller than the minimum size
+func (w *compressingResponseWriter) Close() error {
+	if !w.isDecided {
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+	return nil
+}
+
+// currentCompressionConfig returns the current compression config, or one which doesn't compress when there isn't one
+func currentCompressionConfig(appCtx *AppContext) *domain.CompressionConfig {
+	compressionConfig := appCtx.CurrentConfig().Compression
+	if compressionConfig == nil {
+		return &domain.CompressionConfig{}
+	}
+	return compressionConfig
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testCompressionConfig compresses responses of at least 1KB
+var testCompressionConfig = &domain.CompressionConfig{Enabled: true, MinSize: 1024, Precompress: true}
+
+// testPage is a page big enough to be compressed
+var testPage = "<!DOCTYPE html><html><body>" + strings.Repeat("<p>We build great software</p>", 100) + "</body></html>"
+
+// serveCompressed serves a request, accepting the encodings, from a handler with the compression middleware
+func serveCompressed(acceptEncoding string, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.Use(newCompressionMiddleware(&AppContext{Config: &domain.AppConfig{Compression: testCompressionConfig}}))
+	e.GET("/", handler)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	if len(acceptEncoding) > 0 {
+		request.Header.Set(echo.HeaderAcceptEncoding, acceptEncoding)
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// decompress reads the body of a response with the encoding
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var reader io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case encodingBrotli:
+		reader = brotli.NewReader(reader)
+	case encodingGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		reader = gzipReader
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(decompressed)
+}
+
+func TestTheEncodingIsNegotiatedFromTheAcceptEncoding(t *testing.T) {
+	cases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip, deflate, br", encodingBrotli},
+		{"gzip, deflate", encodingGzip},
+		{"br;q=0.5, gzip", encodingGzip},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", encodingBrotli},
+		{"*;q=0.1, br;q=0", encodingGzip},
+		{"identity", ""},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, negotiateEncoding(c.acceptEncoding, encodings...), "Accept-Encoding: %s", c.acceptEncoding)
+	}
+}
+
+func TestPagesAreCompressedWithTheEncodingTheClientPrefers(t *testing.T) {
+	renderPage := func(c echo.Context) error {
+		return c.HTML(http.StatusOK, testPage)
+	}
+
+	for _, encoding := range encodings {
+		recorder := serveCompressed("gzip, "+encoding, renderPage)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, encoding, recorder.Header().Get(echo.HeaderContentEncoding))
+		assert.Equal(t, echo.HeaderAcceptEncoding, recorder.Header().Get(echo.HeaderVary))
+		assert.Empty(t, recorder.Header().Get(echo.HeaderContentLength))
+		assert.True(t, recorder.Body.Len() < len(testPage), "the page wasn't made smaller")
+		assert.Equal(t, testPage, decompress(t, encoding, recorder.Body.Bytes()))
+	}
+
+	// A client which doesn't accept either is sent the page as it is, but caches are told it varies
+	recorder := serveCompressed("", renderPage)
+	assert.Empty(t, recorder.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, echo.HeaderAcceptEncoding, recorder.Header().Get(echo.HeaderVary))
+	assert.Equal(t, testPage, recorder.Body.String())
+}
+
+func TestResponsesSmallerThanTheMinimumSizeAreNotCompressed(t *testing.T) {
+	recorder := serveCompressed("br, gzip", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	assert.Empty(t, recorder.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "ok", recorder.Body.String())
+}
+
+func TestResponsesWhichAreAlreadyCompressedAreNotCompressedAgain(t *testing.T) {
+	image := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 1024)
+	recorder := serveCompressed("br, gzip", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "image/png", image)
+	})
+	assert.Empty(t, recorder.Header().Get(echo.HeaderContentEncoding))
+	assert.Empty(t, recorder.Header().Get(echo.HeaderVary))
+	assert.Equal(t, image, recorder.Body.Bytes())
+
+	recorder = serveCompressed("br, gzip", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentEncoding, encodingGzip)
+		return c.Blob(http.StatusOK, "text/css", []byte(testPage))
+	})
+	assert.Equal(t, encodingGzip, recorder.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, testPage, recorder.Body.String())
+}
+
+func TestResponsesCompressedOnTheFlyHaveTheirOwnETag(t *testing.T) {
+	e := echo.New()
+	e.Use(newCompressionMiddleware(&AppContext{Config: &domain.AppConfig{Compression: testCompressionConfig}}))
+	e.GET("/", func(c echo.Context) error {
+		http.ServeContent(c.Response(), c.Request(), "index.html", time.Time{}, strings.NewReader(testPage))
+		return nil
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set("ETag", `"v1"`)
+			return next(c)
+		}
+	})
+	serve := func(acceptEncoding string, ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set(echo.HeaderAcceptEncoding, acceptEncoding)
+		if len(ifNoneMatch) > 0 {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, `"v1-br"`, serve("br", "").Header().Get("ETag"))
+	assert.Equal(t, `"v1-gzip"`, serve("gzip", "").Header().Get("ETag"))
+	assert.Equal(t, `"v1"`, serve("", "").Header().Get("ETag"))
+	assert.Equal(t, `W/"v1-br"`, encodedETag(`W/"v1"`, encodingBrotli))
+
+	// A client revalidating the compressed response is told it hasn't changed, with the headers the response had
+	notModified := serve("br", `"v1-br"`)
+	assert.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Equal(t, `"v1-br"`, notModified.Header().Get("ETag"))
+	assert.Equal(t, echo.HeaderAcceptEncoding, notModified.Header().Get(echo.HeaderVary))
+	assert.Equal(t, http.StatusOK, serve("br", `"v0-br"`).Code)
+}
+
+func TestThePublicFilesAreServedCompressedOnceOnStartUp(t *testing.T) {
+	stylesheet := []byte("body { color: #333; }\n" + strings.Repeat("p { margin: 0 0 1em 0; }\n", 100))
+	frontend := fstest.MapFS{
+		"public/css/styles.css": {Data: stylesheet},
+		"public/js/app.js":      {Data: []byte(strings.Repeat("console.log('hello');\n", 100))},
+		"public/js/app.js.gz":   {Data: []byte("built with the site")},
+	}
+	manifest, err := newAssetManifest(frontend, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	appCtx := &AppContext{Config: &domain.AppConfig{Compression: testCompressionConfig}}
+	e := echo.New()
+	e.Use(newCompressionMiddleware(appCtx))
+	e.GET("/*", newPublicFileHandler(appCtx, manifest))
+	serve := func(path string, acceptEncoding string, ifNoneMatch ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", path, nil)
+		request.Header.Set(echo.HeaderAcceptEncoding, acceptEncoding)
+		for _, etag := range ifNoneMatch {
+			request.Header.Add("If-None-Match", etag)
+		}
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	compressed := serve(manifest.byPath["/css/styles.css"].fingerprintedPath, "gzip, br")
+	assert.Equal(t, http.StatusOK, compressed.Code)
+	assert.Equal(t, encodingBrotli, compressed.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, echo.HeaderAcceptEncoding, compressed.Header().Get(echo.HeaderVary))
+	assert.Equal(t, string(stylesheet), decompress(t, encodingBrotli, compressed.Body.Bytes()))
+
+	// Each encoding has its own ETag, so a cache can't mix them up
+	uncompressed := serve("/css/styles.css", "")
+	assert.Empty(t, uncompressed.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, echo.HeaderAcceptEncoding, uncompressed.Header().Get(echo.HeaderVary))
+	assert.Equal(t, string(stylesheet), uncompressed.Body.String())
+	assert.NotEqual(t, compressed.Header().Get("ETag"), uncompressed.Header().Get("ETag"))
+
+	// Revalidating the compressed copy keeps its ETag and Vary
+	notModified := serve("/css/styles.css", "br", compressed.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Equal(t, compressed.Header().Get("ETag"), notModified.Header().Get("ETag"))
+	assert.Equal(t, echo.HeaderAcceptEncoding, notModified.Header().Get(echo.HeaderVary))
+
+	// A compressed copy built with the site is used rather than compressing the file, and isn't served on its own
+	built := serve("/js/app.js", "gzip")
+	assert.Equal(t, encodingGzip, built.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "built with the site", built.Body.String())
+	assert.Equal(t, http.StatusNotFound, serve("/js/app.js.gz", "").Code)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configFileWatchInterval is how often the config file is checked for changes
+const configFileWatchInterval = 5 * time.Second
+
+// ConfigDependentServicesFactory builds the services which depend on the config
+type ConfigDependentServicesFactory func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error)
+
+// ErrorReporterFactory builds the error reporter from the config, with the func sending the reports it hasn't sent yet
+type ErrorReporterFactory func(appConfig *domain.AppConfig) (services.ErrorReporter, closeErrorReporterFunc, error)
+
+// ConfigReloader reloads the application's config and rebuilds the services which depend on it. The current config is
+// kept if the new config can't be loaded, is invalid or the services can't be built from it
+type ConfigReloader struct {
+	ctx *AppContext
+
+	configService services.ConfigService
+
+	servicesFactory ConfigDependentServicesFactory
+
+	// errorReporterFactory rebuilds the error reporter when its settings change
+	errorReporterFactory ErrorReporterFactory
+
+	// mutex ensures only one reload happens at a time
+	mutex sync.Mutex
+}
+
+// NewConfigReloader creates a new ConfigReloader
+func NewConfigReloader(ctx *AppContext, configService services.ConfigService, servicesFactory ConfigDependentServicesFactory, errorReporterFactory ErrorReporterFactory) *ConfigReloader {
+	return &ConfigReloader{
+		ctx:                  ctx,
+		configService:        configService,
+		servicesFactory:      servicesFactory,
+		errorReporterFactory: errorReporterFactory,
+	}
+}
+
+// Reload loads the config and, if it is valid, swaps it and newly built services in
+func (reloader *ConfigReloader) Reload() error {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	logger := reloader.ctx.Logger
+	logger.Info("Reloading config", services.Fields{})
+
+	appConfig, err := reloader.configService.TryLoadConfig()
+	if err != nil {
+		logger.Error("Unable to reload config, keeping the current config", services.Fields{"error": err.Error()})
+		reloader.reportError(err)
+		return err
+	}
+
+	contactFormService, recaptchaService, err := reloader.servicesFactory(appConfig)
+	if err != nil {
+		logger.Error("Unable to build services from the reloaded config, keeping the current config", services.Fields{"error": err.Error()})
+		reloader.reportError(err)
+		return err
+	}
+
+	currentConfig := reloader.ctx.CurrentConfig()
+
+	// The error reporter is only rebuilt when its settings change, so reports it hasn't sent yet aren't held up
+	var errorReporter services.ErrorReporter
+	var closeErrorReporter closeErrorReporterFunc
+	isErrorReportingChanged := !reflect.DeepEqual(currentConfig.ErrorReporting, appConfig.ErrorReporting)
+	if isErrorReportingChanged {
+		errorReporter, closeErrorReporter, err = reloader.errorReporterFactory(appConfig)
+		if err != nil {
+			logger.Error("Unable to build the error reporter from the reloaded config, keeping the current config", services.Fields{"error": err.Error()})
+			reloader.reportError(err)
+			return err
+		}
+	}
+
+	// The log level can be changed while the logger is in use
+	if levelSetter, ok := logger.(services.LevelSetter); ok && currentConfig.Log != nil && appConfig.Log != nil && currentConfig.Log.Level != appConfig.Log.Level {
+		levelSetter.SetLevel(appConfig.Log.Level)
+	}
+
+	if changed := changedRestartOnlySettings(currentConfig, appConfig); len(changed) > 0 {
+		logger.Warn("Some of the changed settings can't be changed without a restart", services.Fields{"settings": changed})
+	}
+
+	reloader.ctx.swapConfig(appConfig, contactFormService, recaptchaService)
+	if isErrorReportingChanged {
+		closeReplaced := reloader.ctx.swapErrorReporter(errorReporter, closeErrorReporter)
+		reloader.ctx.Go(func() {
+			closeCtx, cancel := context.WithTimeout(context.Background(), errorReporterShutdownTimeout)
+			defer cancel()
+			closeReplaced(closeCtx)
+		})
+	}
+	logger.Info("Config reloaded", services.Fields{})
+	return nil
+}
+
+// restartOnlySetting is a setting, or group of settings, which is only read on start up
+type restartOnlySetting struct {
+	// name is the setting's key, or the prefix of the group's keys
+	name string
+
+	// isChanged is true when the setting is different in the reloaded config
+	isChanged func(current *domain.AppConfig, reloaded *domain.AppConfig) bool
+}
+
+// restartOnlySettings are the settings which are only read on start up, changing them needs a restart. Every other
+// setting is read for each request, or when the services and error reporter are rebuilt
+var restartOnlySettings = []restartOnlySetting{
+	{domain.FieldHttpPort, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.HttpPort != reloaded.HttpPort
+	}},
+	{domain.FieldReadTimeout, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.ReadTimeout != reloaded.ReadTimeout
+	}},
+	{domain.FieldReadHeaderTimeout, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.ReadHeaderTimeout != reloaded.ReadHeaderTimeout
+	}},
+	{domain.FieldWriteTimeout, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.WriteTimeout != reloaded.WriteTimeout
+	}},
+	{domain.FieldIdleTimeout, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.IdleTimeout != reloaded.IdleTimeout
+	}},
+	{domain.FieldFrontendDir, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.FrontendDir != reloaded.FrontendDir
+	}},
+	{"tls", func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return !reflect.DeepEqual(current.TLS, reloaded.TLS)
+	}},
+	{domain.FieldMetricsEnabled, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.MetricsEnabled != reloaded.MetricsEnabled
+	}},
+	{domain.FieldMetricsPort, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.MetricsPort != reloaded.MetricsPort
+	}},
+	{domain.FieldTracingEnabled, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.TracingEnabled != reloaded.TracingEnabled
+	}},
+	{domain.FieldTracingEndpoint, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.TracingEndpoint != reloaded.TracingEndpoint
+	}},
+	{domain.FieldTracingNoPropagateHosts, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return !reflect.DeepEqual(current.TracingNoPropagateHosts, reloaded.TracingNoPropagateHosts)
+	}},
+	// The log level is changed on reload, the format, outputs and redaction aren't
+	{"log", func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		if current.Log == nil || reloaded.Log == nil {
+			return current.Log != reloaded.Log
+		}
+		currentLog, reloadedLog := *current.Log, *reloaded.Log
+		currentLog.Level, reloadedLog.Level = "", ""
+		return !reflect.DeepEqual(currentLog, reloadedLog)
+	}},
+	// The browser report limits are fixed when the store is created, the NEL max age is read for every request
+	{"reporting", func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		if current.Reporting == nil || reloaded.Reporting == nil {
+			return current.Reporting != reloaded.Reporting
+		}
+		currentReporting, reloadedReporting := *current.Reporting, *reloaded.Reporting
+		currentReporting.NelMaxAge, reloadedReporting.NelMaxAge = 0, 0
+		return currentReporting != reloadedReporting
+	}},
+	// The public files are compressed on start up, whether to compress responses and how small is read for every request
+	{domain.FieldCompressionPrecompress, func(current *domain.AppConfig, reloaded *domain.AppConfig) bool {
+		return current.Compression != nil && reloaded.Compression != nil && current.Compression.Precompress != reloaded.Compression.Precompress
+	}},
+}
+
+// changedRestartOnlySettings returns the names of the restart only settings which are different in the reloaded config
+func changedRestartOnlySettings(current *domain.AppConfig, reloaded *domain.AppConfig) []string {
+	changed := make([]string, 0)
+	for _, setting := range restartOnlySettings {
+		if setting.isChanged(current, reloaded) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// reportError reports a failed reload to the error tracker
+func (reloader *ConfigReloader) reportError(err error) {
+	reloader.ctx.CurrentErrorReporter().Report(context.Background(), &services.ErrorEvent{
+		Err:  err,
+		Tags: map[string]string{"source": errorSourceConfigReload},
+	})
+}
+
+// reloadRecovering reloads the config, logging and reporting a panic rather than letting it end the watcher, so one bad
+// reload doesn't stop the config being reloaded for the rest of the process
+func (reloader *ConfigReloader) reloadRecovering() {
+	defer reloader.ctx.recoverWorker()
+	reloader.Reload()
+}
+
+// WatchSignals reloads the config in a background worker whenever the process receives SIGHUP, until done is closed.
+// SIGHUP is trapped before it returns, as one arriving before the worker is scheduled would otherwise kill the process
+func (reloader *ConfigReloader) WatchSignals(done <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	reloader.ctx.Go(func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				reloader.reloadRecovering()
+			}
+		}
+	})
+}
+
+// WatchFile reloads the config whenever the file changes, until done is closed. The file is polled rather than
+// watched so it works with the symlink swaps used to update mounted config
+func (reloader *ConfigReloader) WatchFile(path string, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastModified, lastSize := configFileVersion(path)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			modified, size := configFileVersion(path)
+			if modified.Equal(lastModified) && size == lastSize {
+				continue
+			}
+
+			lastModified, lastSize = modified, size
+			reloader.ctx.Logger.Info("Config file changed", services.Fields{"path": path})
+			reloader.reloadRecovering()
+		}
+	}
+}
+
+// configFileVersion identifies the version of the file by its modification time and size
+func configFileVersion(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/mocks"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestReloaderContext() *AppContext {
+	return &AppContext{
+		Config:             &domain.AppConfig{HttpPort: 8080, EmailConfig: &domain.EmailConfig{Subject: "Old"}},
+		Logger:             newLogger(),
+		ContactFormService: new(mocks.ContactFormService),
+		RecaptchaService:   new(mocks.RecaptchaService),
+	}
+}
+
+func TestAValidConfigIsSwappedInOnReload(t *testing.T) {
+	ctx := newTestReloaderContext()
+	newConfig := &domain.AppConfig{HttpPort: 8080, EmailConfig: &domain.EmailConfig{Subject: "New"}}
+	newContactFormService := new(mocks.ContactFormService)
+	newRecaptchaService := new(mocks.RecaptchaService)
+
+	configService := new(mocks.ConfigService)
+	configService.On("TryLoadConfig").Return(newConfig, nil)
+
+	reloader := NewConfigReloader(ctx, configService, func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		assert.Equal(t, newConfig, appConfig)
+		return newContactFormService, newRecaptchaService, nil
+	}, nil)
+
+	err := reloader.Reload()
+
+	assert.NoError(t, err)
+	assert.Equal(t, newConfig, ctx.CurrentConfig())
+	contactFormService, recaptchaService := ctx.CurrentServices()
+	assert.True(t, contactFormService == newContactFormService)
+	assert.True(t, recaptchaService == newRecaptchaService)
+}
+
+func TestTheCurrentConfigIsKeptWhenTheNewConfigIsInvalid(t *testing.T) {
+	ctx := newTestReloaderContext()
+	oldConfig := ctx.Config
+	oldContactFormService, oldRecaptchaService := ctx.CurrentServices()
+
+	configService := new(mocks.ConfigService)
+	configService.On("TryLoadConfig").Return(nil, errors.New(domain.EmailInvalidError))
+
+	reloader := NewConfigReloader(ctx, configService, func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		assert.Fail(t, "services should not be built from an invalid config")
+		return nil, nil, nil
+	}, nil)
+
+	err := reloader.Reload()
+
+	assert.Error(t, err)
+	assert.Equal(t, oldConfig, ctx.CurrentConfig())
+	contactFormService, recaptchaService := ctx.CurrentServices()
+	assert.True(t, contactFormService == oldContactFormService)
+	assert.True(t, recaptchaService == oldRecaptchaService)
+}
+
+func TestTheCurrentConfigIsKeptWhenServicesCannotBeBuilt(t *testing.T) {
+	ctx := newTestReloaderContext()
+	oldConfig := ctx.Config
+
+	configService := new(mocks.ConfigService)
+	configService.On("TryLoadConfig").Return(&domain.AppConfig{HttpPort: 8080}, nil)
+
+	reloader := NewConfigReloader(ctx, configService, func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		return nil, nil, errors.New("unable to create session")
+	}, nil)
+
+	err := reloader.Reload()
+
+	assert.Error(t, err)
+	assert.Equal(t, oldConfig, ctx.CurrentConfig())
+}
+
+func TestTheErrorReporterIsRebuiltWhenItsSettingsChange(t *testing.T) {
+	ctx := newTestReloaderContext()
+	oldErrorReporter := new(mocks.ErrorReporter)
+	isOldErrorReporterClosed := make(chan bool, 1)
+	ctx.swapErrorReporter(oldErrorReporter, func(_ context.Context) error {
+		isOldErrorReporterClosed <- true
+		return nil
+	})
+	newConfig := &domain.AppConfig{HttpPort: 8080, ErrorReporting: &domain.ErrorReportingConfig{Environment: "staging"}}
+	newErrorReporter := new(mocks.ErrorReporter)
+
+	configService := new(mocks.ConfigService)
+	configService.On("TryLoadConfig").Return(newConfig, nil)
+
+	reloader := NewConfigReloader(ctx, configService, func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		return new(mocks.ContactFormService), new(mocks.RecaptchaService), nil
+	}, func(appConfig *domain.AppConfig) (services.ErrorReporter, closeErrorReporterFunc, error) {
+		assert.Equal(t, newConfig, appConfig)
+		return newErrorReporter, nil, nil
+	})
+
+	err := reloader.Reload()
+
+	assert.NoError(t, err)
+	assert.True(t, ctx.CurrentErrorReporter() == newErrorReporter)
+	assert.True(t, <-isOldErrorReporterClosed, "the replaced error reporter wasn't closed")
+}
+
+func TestTheSettingsWhichNeedARestartAreListed(t *testing.T) {
+	current := &domain.AppConfig{
+		HttpPort:    8080,
+		ReadTimeout: 10 * time.Second,
+		BodyLimit:   1024,
+		Log:         &domain.LogConfig{Level: "info", Format: "text"},
+		Reporting:   &domain.ReportingConfig{RateLimit: 10, NelMaxAge: time.Hour},
+		MetricsPort: 9090,
+		FrontendDir: "/srv/frontend",
+		EmailConfig: &domain.EmailConfig{Subject: "Old"},
+		Compression: &domain.CompressionConfig{Enabled: true, Precompress: true},
+	}
+	reloaded := &domain.AppConfig{
+		HttpPort:    8080,
+		ReadTimeout: 20 * time.Second,
+		BodyLimit:   2048,
+		Log:         &domain.LogConfig{Level: "debug", Format: "json"},
+		Reporting:   &domain.ReportingConfig{RateLimit: 10, NelMaxAge: 2 * time.Hour},
+		MetricsPort: 9091,
+		FrontendDir: "/srv/frontend",
+		EmailConfig: &domain.EmailConfig{Subject: "New"},
+		Compression: &domain.CompressionConfig{Enabled: false, Precompress: true},
+	}
+
+	// The body limit, log level, NEL max age, email and compression are read for each request or rebuilt
+	assert.Equal(t, []string{domain.FieldReadTimeout, domain.FieldMetricsPort, "log"}, changedRestartOnlySettings(current, reloaded))
+	assert.Empty(t, changedRestartOnlySettings(current, current))
+}
+
+func TestASIGHUPStraightAfterWatchingSignalsReloadsTheConfig(t *testing.T) {
+	ctx := newTestReloaderContext()
+	newConfig := &domain.AppConfig{HttpPort: 8080, EmailConfig: &domain.EmailConfig{Subject: "New"}}
+
+	configService := new(mocks.ConfigService)
+	configService.On("TryLoadConfig").Return(newConfig, nil)
+
+	reloader := NewConfigReloader(ctx, configService, func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		return new(mocks.ContactFormService), new(mocks.RecaptchaService), nil
+	}, nil)
+
+	done := make(chan struct{})
+	reloader.WatchSignals(done)
+
+	// Sent before the worker has necessarily been scheduled, it must be trapped rather than kill the process
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	err := Eventually(func() error {
+		if ctx.CurrentConfig() != newConfig {
+			return errors.New("config not reloaded")
+		}
+		return nil
+	}, 20, 100*time.Millisecond)
+	assert.NoError(t, err)
+
+	close(done)
+	ctx.workers.Wait()
+}
+
+func TestSignalsAreStillWatchedAfterAReloadPanics(t *testing.T) {
+	ctx := newTestReloaderContext()
+	newConfig := &domain.AppConfig{HttpPort: 8080, EmailConfig: &domain.EmailConfig{Subject: "New"}}
+
+	configService := new(mocks.ConfigService)
+	configService.On("TryLoadConfig").Return(newConfig, nil)
+
+	builds := 0
+	reloader := NewConfigReloader(ctx, configService, func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		builds++
+		if builds == 1 {
+			panic("unable to build the services")
+		}
+		return new(mocks.ContactFormService), new(mocks.RecaptchaService), nil
+	}, nil)
+
+	done := make(chan struct{})
+	reloader.WatchSignals(done)
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	err := Eventually(func() error {
+		reloader.mutex.Lock()
+		defer reloader.mutex.Unlock()
+		if builds != 1 {
+			return errors.New("the first reload hasn't happened")
+		}
+		return nil
+	}, 20, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotEqual(t, newConfig, ctx.CurrentConfig(), "the config of the reload which panicked was swapped in")
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	err = Eventually(func() error {
+		if ctx.CurrentConfig() != newConfig {
+			return errors.New("config not reloaded")
+		}
+		return nil
+	}, 20, 100*time.Millisecond)
+	assert.NoError(t, err)
+
+	close(done)
+	ctx.workers.Wait()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"gopkg.in/go-playground/validator.v9"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// contactThanksPath is where browsers are redirected to after a successful form post
+	contactThanksPath = "/contact/thanks"
+
+	// submissionAcceptedStatus is the status reported to API clients for accepted submissions
+	submissionAcceptedStatus = "accepted"
+
+	// maxSubmissionReceipts is the most receipts kept for API clients to read back
+	maxSubmissionReceipts = 1000
+
+	// submissionReceiptMaxAge is how long a receipt can be read back for
+	submissionReceiptMaxAge = 24 * time.Hour
+)
+
+// contactFieldErrorMessages are the messages shown against each contact form field when it is not valid
+var contactFieldErrorMessages = map[string]string{
+	"name":    "Please tell us your name",
+	"email":   "Please enter a valid email address",
+	"company": "Please tell us the company you're contacting us on behalf of",
+	"number":  "Please enter a contact number",
+	"message": "Please enter a message",
+}
+
+// submissionIDPattern matches the references created by newSubmissionID
+var submissionIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// apiError is the body returned by the JSON API when a request cannot be fulfilled
+type apiError struct {
+	Message string `json:"message"`
+
+	// Fields maps the name of each invalid field to the reason it is invalid
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// newSubmissionID creates a random (version 4) UUID used to reference a contact form submission
+func newSubmissionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// newContactFormValidator creates a validator which reports field errors using the form field names
+func newContactFormValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("form"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return validate
+}
+
+// contactFieldErrors converts a validation error into a map of field name to user facing message
+func contactFieldErrors(err error) map[string]string {
+	fields := make(map[string]string)
+
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return fields
+	}
+
+	for _, fieldError := range validationErrors {
+		message, isFound := contactFieldErrorMessages[fieldError.Field()]
+		if !isFound {
+			message = "This field is not valid"
+		}
+		fields[fieldError.Field()] = message
+	}
+
+	return fields
+}
+
+// acceptQuality returns the quality value the Accept header gives the media type, only explicit entries are considered
+func acceptQuality(accept string, mediaType string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != mediaType {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		return quality
+	}
+
+	return 0
+}
+
+// prefersHTML is true when the client has explicitly asked for HTML over JSON, as browsers do for classic form posts.
+// API clients and AJAX requests get JSON
+func prefersHTML(c echo.Context) bool {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return false
+	}
+
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	htmlQuality := acceptQuality(accept, echo.MIMETextHTML)
+	return htmlQuality > 0 && htmlQuality > acceptQuality(accept, echo.MIMEApplicationJSON)
+}
+
+// contactPageView is the view model for the contact page
+func contactPageView(ctx *AppContext, c echo.Context, form *domain.ContactForm, errorMessage string, fieldErrors map[string]string) *pageView {
+	if form == nil {
+		form = &domain.ContactForm{}
+	}
+
+	if fieldErrors == nil {
+		fieldErrors = make(map[string]string)
+	}
+
+	return newPageView(ctx, c,
+		"Contact",
+		"Have a question? Want to chat about a project you're working on? Fill in the form below or drop us an email. We'll be right with you.",
+		map[string]interface{}{
+			"Form":        form,
+			"Error":       errorMessage,
+			"FieldErrors": fieldErrors,
+		})
+}
+
+// newContactPageHandler creates the handler rendering the contact page
+func newContactPageHandler(ctx *AppContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, "contact.html", contactPageView(ctx, c, nil, "", nil))
+	}
+}
+
+// newContactThanksPageHandler creates the handler rendering the page browsers are redirected to after submitting the
+// contact form. It is also the Location given to API clients, which are sent the submission's receipt as JSON while it
+// is kept, and a 404 for references which aren't ours or have been forgotten. Browsers are only shown a reference we
+// issued, otherwise anyone could link to the page showing text of their choosing as a reference; they get the thanks
+// page without one instead
+func newContactThanksPageHandler(ctx *AppContext, receipts *services.SubmissionReceiptStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reference := c.QueryParam("ref")
+		receipt, isFound := receipts.Get(reference)
+		isIssued := submissionIDPattern.MatchString(reference) && isFound
+		if !prefersHTML(c) {
+			if !isIssued {
+				return c.JSON(http.StatusNotFound, &apiError{Message: "No submission has that reference."})
+			}
+			return c.JSON(http.StatusOK, &receipt)
+		}
+
+		if !isIssued {
+			reference = ""
+		}
+		view := newPageView(ctx, c, "Thanks!", "We'll be in touch shortly.", map[string]interface{}{
+			"Reference": reference,
+		})
+
+		return c.Render(http.StatusOK, "thanks.html", view)
+	}
+}
+
+// respondContactFormError tells the submitter their contact form could not be accepted. Browsers get the contact page
+// back with their submitted values and the errors, everything else gets JSON
+func respondContactFormError(ctx *AppContext, c echo.Context, code int, message string, fieldErrors map[string]string, form *domain.ContactForm) error {
+	if prefersHTML(c) {
+		return c.Render(code, "contact.html", contactPageView(ctx, c, form, message, fieldErrors))
+	}
+
+	return c.JSON(code, &apiError{
+		Message: message,
+		Fields:  fieldErrors,
+	})
+}
+
+// newContactFormSubmissionHandler creates the handler accepting contact form submissions. Submissions may be sent as
+// application/x-www-form-urlencoded, multipart/form-data or application/json. The receipt of each accepted submission is
+// kept so API clients can read it back from its Location
+func newContactFormSubmissionHandler(ctx *AppContext, receipts *services.SubmissionReceiptStore) echo.HandlerFunc {
+	metrics := ctx.Metrics
+	validate := newContactFormValidator()
+
+	return func(c echo.Context) error {
+		contactFormService, recaptchaService := ctx.CurrentServices()
+		requestCtx := c.Request().Context()
+		logger := ctx.Logger.WithContext(requestCtx)
+
+		contactFormSubmission := &domain.ContactForm{}
+		err := c.Bind(contactFormSubmission)
+		if err != nil {
+			logger.Error("Unable to bind contact form", services.Fields{"error": err.Error()})
+			code := http.StatusBadRequest
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+			metrics.ObserveSubmission(services.SubmissionInvalid)
+			return respondContactFormError(ctx, c, code, "Unable to read the contact form, please try again.", nil, contactFormSubmission)
+		}
+
+		err = validate.Struct(contactFormSubmission)
+		if err != nil {
+			logger.Error("Received an invalid contact form", services.Fields{"error": err.Error()})
+			metrics.ObserveSubmission(services.SubmissionInvalid)
+			return respondContactFormError(ctx, c, http.StatusBadRequest, "Please ensure you've filled in all the fields.", contactFieldErrors(err), contactFormSubmission)
+		}
+
+		err = recaptchaService.Verify(requestCtx, contactFormSubmission.RecaptchaResponse)
+		if err != nil {
+			errorMessage := err.Error()
+			if errorMessage == services.CannotCommunicateRecaptchaError {
+				logger.Error("Unable to communicate with Recaptcha Service", services.Fields{"error": err.Error()})
+				metrics.ObserveSubmission(services.SubmissionCaptchaFailed)
+				return respondContactFormError(ctx, c, http.StatusInternalServerError, "We couldn't check you're not a robot, please try again.", nil, contactFormSubmission)
+			} else if errorMessage == services.NotVerifiedError {
+				logger.Warn("Received a submit contact form request for a user not verified by Recaptcha", services.Fields{"error": err.Error()})
+				metrics.ObserveSubmission(services.SubmissionSpam)
+				return respondContactFormError(ctx, c, http.StatusForbidden, "Please confirm you're not a robot.", nil, contactFormSubmission)
+			} else {
+				logger.Warn("Unexpected error verifying contact form submission with Recaptcha", services.Fields{"error": err.Error()})
+				metrics.ObserveSubmission(services.SubmissionCaptchaFailed)
+				return respondContactFormError(ctx, c, http.StatusInternalServerError, "We couldn't check you're not a robot, please try again.", nil, contactFormSubmission)
+			}
+		}
+
+		contactFormSubmission.ID, err = newSubmissionID()
+		if err != nil {
+			logger.Error("Unable to create a submission ID", services.Fields{"error": err.Error()})
+			metrics.ObserveSubmission(services.SubmissionDeliveryFailed)
+			return respondContactFormError(ctx, c, http.StatusInternalServerError, "We couldn't send your message, please try again.", nil, contactFormSubmission)
+		}
+
+		err = contactFormService.Process(requestCtx, contactFormSubmission)
+		if err != nil {
+			logger.Error("Unable to process contact form", services.Fields{"error": err.Error(), "submissionId": contactFormSubmission.ID})
+			ctx.CurrentErrorReporter().Report(requestCtx, &services.ErrorEvent{
+				Err:     err,
+				Request: c.Request(),
+				Tags:    map[string]string{"submission_id": contactFormSubmission.ID},
+			})
+			metrics.ObserveSubmission(services.SubmissionDeliveryFailed)
+			return respondContactFormError(ctx, c, http.StatusInternalServerError, "We couldn't send your message, please try again.", nil, contactFormSubmission)
+		}
+
+		metrics.ObserveSubmission(services.SubmissionAccepted)
+
+		receipt := domain.ContactFormReceipt{
+			ID:     contactFormSubmission.ID,
+			Status: submissionAcceptedStatus,
+		}
+		receipts.Add(receipt)
+
+		// Post/Redirect/Get for browsers so a refresh doesn't resubmit the form. Only the receipt of a submission is
+		// kept, so the thanks page is the only resource there is for one; it renders the reference for browsers and
+		// the receipt for API clients
+		thanksURL := fmt.Sprintf("%s?ref=%s", contactThanksPath, url.QueryEscape(contactFormSubmission.ID))
+		if prefersHTML(c) {
+			return c.Redirect(http.StatusSeeOther, thanksURL)
+		}
+
+		c.Response().Header().Set(echo.HeaderLocation, thanksURL)
+		return c.JSON(http.StatusCreated, &receipt)
+	}
+}
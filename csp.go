@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/labstack/echo"
+)
+
+// cspNonceKey is the key of the request's CSP nonce in the echo context
+const cspNonceKey = "cspNonce"
+
+// newCSPNonce creates a nonce for the inline scripts of a response, it must never be reused. It is URL safe base64 so
+// the templates write it into the page unescaped
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cspNonce is the nonce the inline scripts of the response must be given, it is empty when no policy is sent
+func cspNonce(c echo.Context) string {
+	nonce, _ := c.Get(cspNonceKey).(string)
+	return nonce
+}
+
+// newCSPMiddleware creates the middleware sending the Content-Security-Policy with every response. Every response gets
+// a new nonce, which the templates give their inline scripts. The policy is read from the current config, so it can be
+// changed by reloading the config
+func newCSPMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cspConfig := appCtx.CurrentConfig().CSP
+			if cspConfig == nil || !cspConfig.Enabled {
+				return next(c)
+			}
+
+			nonce, err := newCSPNonce()
+			if err != nil {
+				return err
+			}
+
+			c.Set(cspNonceKey, nonce)
+			header := c.Response().Header()
+			header.Set(cspConfig.HeaderName(), cspConfig.Build(nonce))
+			if reportingEndpoints := cspConfig.ReportingEndpoints(); len(reportingEndpoints) > 0 {
+				header.Set(domain.ReportingEndpointsHeader, reportingEndpoints)
+			}
+			return next(c)
+		}
+	}
+}
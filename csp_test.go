@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testCSPConfig allows scripts from the site and Google Analytics
+var testCSPConfig = &domain.CSPConfig{
+	Enabled:   true,
+	ReportURI: "/csp-reports",
+	Sources: map[string][]string{
+		domain.CspDefaultSrc: {"'self'"},
+		domain.CspScriptSrc:  {"'self'", "https://www.googletagmanager.com"},
+	},
+}
+
+// serveWithCSP serves a request with the CSP middleware, returning the response and the nonce the handler saw
+func serveWithCSP(cspConfig *domain.CSPConfig) (*httptest.ResponseRecorder, string) {
+	e := echo.New()
+	e.Use(newCSPMiddleware(&AppContext{Config: &domain.AppConfig{CSP: cspConfig}}))
+
+	var handlerNonce string
+	e.GET("/", func(c echo.Context) error {
+		handlerNonce = cspNonce(c)
+		return c.String(http.StatusOK, "ok")
+	})
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	return recorder, handlerNonce
+}
+
+func TestThePolicyAllowsTheRequestsNonce(t *testing.T) {
+	recorder, nonce := serveWithCSP(testCSPConfig)
+
+	assert.Len(t, nonce, 22)
+	assert.Equal(t, "default-src 'self'; script-src 'self' https://www.googletagmanager.com 'nonce-"+nonce+"'; "+
+		"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'; report-uri /csp-reports; report-to csp-endpoint",
+		recorder.Header().Get(domain.CspHeader))
+	assert.Equal(t, `csp-endpoint="/csp-reports"`, recorder.Header().Get(domain.ReportingEndpointsHeader))
+	assert.Empty(t, recorder.Header().Get(domain.CspReportOnlyHeader))
+
+	// Every response gets a new nonce
+	_, anotherNonce := serveWithCSP(testCSPConfig)
+	assert.NotEqual(t, nonce, anotherNonce)
+}
+
+func TestThePolicyCanBeReportOnly(t *testing.T) {
+	cspConfig := *testCSPConfig
+	cspConfig.ReportOnly = true
+
+	recorder, nonce := serveWithCSP(&cspConfig)
+	assert.Contains(t, recorder.Header().Get(domain.CspReportOnlyHeader), "'nonce-"+nonce+"'")
+	assert.Empty(t, recorder.Header().Get(domain.CspHeader))
+}
+
+func TestNoPolicyIsSentWhenItIsDisabled(t *testing.T) {
+	cspConfig := *testCSPConfig
+	cspConfig.Enabled = false
+
+	recorder, nonce := serveWithCSP(&cspConfig)
+	assert.Empty(t, nonce)
+	assert.Empty(t, recorder.Header().Get(domain.CspHeader))
+	assert.Empty(t, recorder.Header().Get(domain.CspReportOnlyHeader))
+}
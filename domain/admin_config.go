@@ -0,0 +1,31 @@
+package domain
+
+const (
+	AdminUsernameMissingError = "admin username was not provided"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldAdminUsername = "admin.username"
+	FieldAdminPassword = "admin.password"
+)
+
+// AdminConfig configures the admin area, which is protected by HTTP basic auth
+type AdminConfig struct {
+	Username string
+
+	// Password is the password of the admin area, the admin area isn't served when it is empty
+	Password Secret
+}
+
+// Enabled is true when the admin area is served
+func (adminConfig *AdminConfig) Enabled() bool {
+	return adminConfig.Password.IsSet()
+}
+
+// ValidateInto adds every problem with the admin config to the report
+func (adminConfig *AdminConfig) ValidateInto(report *ValidationReport) {
+	if adminConfig.Enabled() && len(adminConfig.Username) <= 0 {
+		report.Add(FieldAdminUsername, AdminUsernameMissingError)
+	}
+}
@@ -1,42 +1,376 @@
 package domain
 
 import (
+	"fmt"
 	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"os"
 	"regexp"
+	"time"
 )
 
 const (
-	EmailInvalidError        = "provided email address was not valid"
-	HttpPortInvalidError     = "provided HTTP port was not valid"
-	EmailSubjectInvalidError = "provided email subject was invalid"
-	AwsSesInvalidRegionError = "provided AWS SES region is invalid"
+	EmailInvalidError               = "provided email address was not valid"
+	HttpPortInvalidError            = "provided HTTP port was not valid"
+	EmailSubjectInvalidError        = "provided email subject was invalid"
+	AwsSesInvalidRegionError        = "provided AWS SES region is invalid"
+	AwsSesUnknownRegionError        = "provided AWS SES region is not one SES is available in"
+	AwsSesAccessKeyMissingError     = "AWS SES access key was not provided"
+	AwsSesSecretKeyMissingError     = "AWS SES secret key was not provided"
+	AwsSesEndpointInvalidError      = "AWS SES endpoint must be an absolute http or https URL"
+	RecaptchaSecretMissingError     = "recaptcha secret was not provided"
+	FrontendDirUnreadableError      = "frontend directory is not a readable directory"
+	SiteNameMissingError            = "site name was not provided"
+	SiteBaseURLMissingError         = "site base URL was not provided"
+	SiteBaseURLInvalidError         = "site base URL must be an absolute http or https URL"
+	SiteCompanyNumberMissingError   = "site company number was not provided"
+	SiteCopyrightHolderMissingError = "site copyright holder was not provided"
+	SiteContactEmailMissingError    = "site contact email was not provided"
+	RecaptchaSiteKeyMissingError    = "recaptcha site key was not provided"
+	ShutdownTimeoutInvalidError     = "shutdown timeout must be greater than zero"
+	ShutdownDrainDelayError         = "shutdown drain delay can't be negative"
+	MetricsPortInvalidError         = "provided metrics port was not valid"
+	MetricsPortConflictError        = "metrics port must differ from the HTTP and HTTPS ports"
+	TracingEndpointInvalidError     = "OTLP endpoint must be an absolute http or https URL"
+	HttpTimeoutInvalidError         = "HTTP timeout must be greater than zero"
+	BodyLimitInvalidError           = "body limit must be greater than zero"
 )
 
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldHttpPort                = "http.port"
+	FieldShutdownTimeout         = "http.shutdown_timeout"
+	FieldShutdownDrainDelay      = "http.shutdown_drain_delay"
+	FieldReadTimeout             = "http.read_timeout"
+	FieldReadHeaderTimeout       = "http.read_header_timeout"
+	FieldWriteTimeout            = "http.write_timeout"
+	FieldIdleTimeout             = "http.idle_timeout"
+	FieldBodyLimit               = "http.body_limit"
+	FieldUploadBodyLimit         = "http.upload_body_limit"
+	FieldTrustForwardedFor       = "http.trust_forwarded_for"
+	FieldMetricsEnabled          = "metrics.enabled"
+	FieldMetricsPort             = "metrics.port"
+	FieldTracingEnabled          = "tracing.enabled"
+	FieldTracingEndpoint         = "tracing.otlp_endpoint"
+	FieldTracingTrustIncoming    = "tracing.trust_incoming"
+	FieldTracingNoPropagateHosts = "tracing.no_propagate_hosts"
+	FieldFrontendDir             = "frontend_dir"
+	FieldEmailSender             = "email.sender"
+	FieldEmailRecipient          = "email.recipient"
+	FieldEmailSubject            = "email.subject"
+	FieldAwsSesRegion            = "email.aws_ses_region"
+	FieldAwsSesAccessKey         = "email.aws_ses_access_key"
+	FieldAwsSesSecretKey         = "email.aws_ses_secret_key"
+	FieldAwsSesEndpoint          = "email.aws_ses_endpoint"
+	FieldRecaptchaSecret         = "recaptcha.secret"
+	FieldSiteName                = "site.name"
+	FieldSiteBaseURL             = "site.base_url"
+	FieldSiteCompanyNumber       = "site.company_number"
+	FieldSiteCopyrightHolder     = "site.copyright_holder"
+	FieldSiteContactEmail        = "site.contact_email"
+	FieldRecaptchaSiteKey        = "recaptcha.site_key"
+)
+
+const (
+	minHttpPort = 1
+	maxHttpPort = 65535
+)
+
+// awsSesRegions are the AWS regions SES is available in
+var awsSesRegions = map[string]bool{
+	"us-east-1":      true,
+	"us-east-2":      true,
+	"us-west-1":      true,
+	"us-west-2":      true,
+	"us-gov-east-1":  true,
+	"us-gov-west-1":  true,
+	"ca-central-1":   true,
+	"sa-east-1":      true,
+	"eu-west-1":      true,
+	"eu-west-2":      true,
+	"eu-west-3":      true,
+	"eu-central-1":   true,
+	"eu-north-1":     true,
+	"eu-south-1":     true,
+	"il-central-1":   true,
+	"me-south-1":     true,
+	"af-south-1":     true,
+	"ap-south-1":     true,
+	"ap-northeast-1": true,
+	"ap-northeast-2": true,
+	"ap-northeast-3": true,
+	"ap-southeast-1": true,
+	"ap-southeast-2": true,
+	"ap-southeast-3": true,
+}
+
 // AppConfig is the application's configuration
 type AppConfig struct {
 	// HttpPort is the port to run on
 	HttpPort int
 
+	// ShutdownTimeout is how long in flight requests and background workers are given to finish when shutting down
+	ShutdownTimeout time.Duration
+
+	// ShutdownDrainDelay is how long the instance keeps serving after reporting it isn't ready when shutting down, so
+	// load balancers stop sending it requests first
+	ShutdownDrainDelay time.Duration
+
+	// ReadTimeout is how long a client has to send a whole request, including the body
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout is how long a client has to send the request headers, it stops slowloris style attacks
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout is how long a request has to be handled and its response written, it must allow for calls to
+	// recaptcha and SES
+	WriteTimeout time.Duration
+
+	// IdleTimeout is how long an idle keep-alive connection is kept open
+	IdleTimeout time.Duration
+
+	// BodyLimit is the largest request body in bytes accepted by most routes
+	BodyLimit int64
+
+	// UploadBodyLimit is the largest request body in bytes accepted by routes taking form submissions and uploads
+	UploadBodyLimit int64
+
+	// TrustForwardedFor identifies clients by their X-Forwarded-For or X-Real-IP header, rather than the address they
+	// connected from. Only set it when every request comes through a proxy which sets or strips the headers
+	TrustForwardedFor bool
+
+	// FrontendDir overrides the views and public files built into the binary, file by file, with those in its views/
+	// and public/ directories. Empty uses only the built in files
 	FrontendDir string
 
 	// EmailConfig is the email configuration
 	EmailConfig *EmailConfig
 
-	RecaptchaSecret string
+	RecaptchaSecret Secret
+
+	// Site is the site settings shown on every page
+	Site *SiteConfig
+
+	// MetricsEnabled serves metrics for Prometheus
+	MetricsEnabled bool
+
+	// MetricsPort serves the metrics on their own port rather than alongside the site, zero serves them alongside
+	MetricsPort int
+
+	// TracingEnabled exports traces to TracingEndpoint
+	TracingEnabled bool
+
+	// TracingEndpoint is the URL of the OTLP/HTTP collector traces are exported to
+	TracingEndpoint string
+
+	// TracingTrustIncoming continues the trace of a request's traceparent header, rather than starting a new trace
+	// linked to it. Only set it when every request comes through something which sets or strips the header
+	TracingTrustIncoming bool
+
+	// TracingNoPropagateHosts are the hosts outbound requests don't send the trace context to, it is sent to any others
+	TracingNoPropagateHosts []string
+
+	// TLS configures serving HTTPS natively
+	TLS *TLSConfig
+
+	// Log configures the application's logging
+	Log *LogConfig
+
+	// CSP configures the Content-Security-Policy sent with every response
+	CSP *CSPConfig
+
+	// SecurityHeaders configures the other security headers sent with every response
+	SecurityHeaders *SecurityHeadersConfig
+
+	// ErrorReporting configures the reporting of errors to an error tracker
+	ErrorReporting *ErrorReportingConfig
+
+	// Reporting configures the collection of the reports browsers send
+	Reporting *ReportingConfig
+
+	// Admin configures the admin area
+	Admin *AdminConfig
+
+	// Compression configures the compression of responses
+	Compression *CompressionConfig
+
+	// ConfigFile is the config file the config was loaded from, if any
+	ConfigFile string
+
+	// WatchConfigFile reloads the config whenever the config file changes
+	WatchConfigFile bool
+}
+
+// Validate validates the config, returning a *ValidationReport listing every problem if it isn't valid
+func (appConfig *AppConfig) Validate() error {
+	report := &ValidationReport{}
+	appConfig.ValidateInto(report)
+	return report.Err()
 }
 
-func (appConfig *AppConfig) Validate() (err error) {
-	if appConfig.HttpPort == 0 {
-		err = errors.New(HttpPortInvalidError)
-		return
+// ValidateInto adds every problem with the config to the report
+func (appConfig *AppConfig) ValidateInto(report *ValidationReport) {
+	if appConfig.HttpPort < minHttpPort || appConfig.HttpPort > maxHttpPort {
+		report.Add(FieldHttpPort, fmt.Sprintf("%s, must be between %d and %d", HttpPortInvalidError, minHttpPort, maxHttpPort))
 	}
 
-	err = appConfig.EmailConfig.Validate()
-	if err != nil {
-		return
+	if appConfig.ShutdownTimeout <= 0 {
+		report.Add(FieldShutdownTimeout, ShutdownTimeoutInvalidError)
 	}
 
-	return
+	if appConfig.ShutdownDrainDelay < 0 {
+		report.Add(FieldShutdownDrainDelay, ShutdownDrainDelayError)
+	}
+
+	httpTimeouts := []struct {
+		field   string
+		timeout time.Duration
+	}{
+		{FieldReadTimeout, appConfig.ReadTimeout},
+		{FieldReadHeaderTimeout, appConfig.ReadHeaderTimeout},
+		{FieldWriteTimeout, appConfig.WriteTimeout},
+		{FieldIdleTimeout, appConfig.IdleTimeout},
+	}
+	for _, httpTimeout := range httpTimeouts {
+		if httpTimeout.timeout <= 0 {
+			report.Add(httpTimeout.field, HttpTimeoutInvalidError)
+		}
+	}
+
+	if appConfig.BodyLimit <= 0 {
+		report.Add(FieldBodyLimit, BodyLimitInvalidError)
+	}
+
+	if appConfig.UploadBodyLimit <= 0 {
+		report.Add(FieldUploadBodyLimit, BodyLimitInvalidError)
+	}
+
+	if len(appConfig.FrontendDir) > 0 && !isReadableDir(appConfig.FrontendDir) {
+		report.Add(FieldFrontendDir, fmt.Sprintf("%s: '%s'", FrontendDirUnreadableError, appConfig.FrontendDir))
+	}
+
+	if !appConfig.RecaptchaSecret.IsSet() {
+		report.Add(FieldRecaptchaSecret, RecaptchaSecretMissingError)
+	}
+
+	// These are required, so a missing one is validated as if it were empty, leaving the config as it is
+	emailConfig := appConfig.EmailConfig
+	if emailConfig == nil {
+		emailConfig = &EmailConfig{}
+	}
+	emailConfig.ValidateInto(report)
+
+	site := appConfig.Site
+	if site == nil {
+		site = &SiteConfig{}
+	}
+	site.ValidateInto(report)
+
+	tlsConfig := appConfig.TLS
+	if tlsConfig == nil {
+		tlsConfig = &TLSConfig{}
+	}
+	tlsConfig.ValidateInto(report, appConfig.HttpPort)
+
+	if appConfig.Log != nil {
+		appConfig.Log.ValidateInto(report)
+	}
+
+	if appConfig.CSP != nil {
+		appConfig.CSP.ValidateInto(report)
+	}
+
+	if appConfig.SecurityHeaders != nil {
+		appConfig.SecurityHeaders.ValidateInto(report)
+	}
+
+	if appConfig.ErrorReporting != nil {
+		appConfig.ErrorReporting.ValidateInto(report)
+	}
+
+	if appConfig.Reporting != nil {
+		appConfig.Reporting.ValidateInto(report)
+	}
+
+	if appConfig.Admin != nil {
+		appConfig.Admin.ValidateInto(report)
+	}
+
+	if appConfig.Compression != nil {
+		appConfig.Compression.ValidateInto(report)
+	}
+
+	if appConfig.MetricsEnabled && appConfig.MetricsPort != 0 {
+		if appConfig.MetricsPort < minHttpPort || appConfig.MetricsPort > maxHttpPort {
+			report.Add(FieldMetricsPort, fmt.Sprintf("%s, must be 0 or between %d and %d", MetricsPortInvalidError, minHttpPort, maxHttpPort))
+		} else if appConfig.MetricsPort == appConfig.HttpPort || (tlsConfig.Enabled() && appConfig.MetricsPort == tlsConfig.HttpsPort) {
+			report.Add(FieldMetricsPort, MetricsPortConflictError)
+		}
+	}
+
+	if appConfig.TracingEnabled {
+		endpoint, err := url.Parse(appConfig.TracingEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) <= 0 {
+			report.Add(FieldTracingEndpoint, fmt.Sprintf("%s: '%s'", TracingEndpointInvalidError, appConfig.TracingEndpoint))
+		}
+	}
+}
+
+// SiteConfig is the site settings shown on every page, so they can differ between environments. The site's identity
+// has no defaults, so a deploy which forgets to set it fails validation rather than passing itself off as another site
+type SiteConfig struct {
+	// Name is the name of the site, used in page titles
+	Name string
+
+	// BaseURL is the absolute URL the site is served from, without a trailing slash
+	BaseURL string
+
+	// CompanyNumber is the registered company number shown in the footer
+	CompanyNumber string
+
+	// CopyrightHolder is the legal entity named in the copyright notice
+	CopyrightHolder string
+
+	// ContactEmail is the email address visitors are told to get in touch with
+	ContactEmail string
+
+	// AnalyticsIDs are the Google Analytics IDs to report page views to, analytics is disabled if empty
+	AnalyticsIDs []string
+
+	// RecaptchaSiteKey is the public recaptcha key used by the contact form
+	RecaptchaSiteKey string
+}
+
+// ValidateInto adds every problem with the site config to the report
+func (siteConfig *SiteConfig) ValidateInto(report *ValidationReport) {
+	if len(siteConfig.Name) <= 0 {
+		report.Add(FieldSiteName, SiteNameMissingError)
+	}
+
+	if len(siteConfig.BaseURL) <= 0 {
+		report.Add(FieldSiteBaseURL, SiteBaseURLMissingError)
+	} else if baseURL, err := url.Parse(siteConfig.BaseURL); err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || len(baseURL.Host) <= 0 {
+		report.Add(FieldSiteBaseURL, fmt.Sprintf("%s: '%s'", SiteBaseURLInvalidError, siteConfig.BaseURL))
+	}
+
+	if len(siteConfig.CompanyNumber) <= 0 {
+		report.Add(FieldSiteCompanyNumber, SiteCompanyNumberMissingError)
+	}
+
+	if len(siteConfig.CopyrightHolder) <= 0 {
+		report.Add(FieldSiteCopyrightHolder, SiteCopyrightHolderMissingError)
+	}
+
+	if len(siteConfig.ContactEmail) <= 0 {
+		report.Add(FieldSiteContactEmail, SiteContactEmailMissingError)
+	} else if err := validateEmailFormat(siteConfig.ContactEmail); err != nil {
+		report.Add(FieldSiteContactEmail, fmt.Sprintf("%s: '%s'", err.Error(), siteConfig.ContactEmail))
+	}
+
+	if len(siteConfig.RecaptchaSiteKey) <= 0 {
+		report.Add(FieldRecaptchaSiteKey, RecaptchaSiteKeyMissingError)
+	}
 }
 
 type EmailConfig struct {
@@ -52,39 +386,91 @@ type EmailConfig struct {
 	// AwsSesRegion is the AWS SES region to use when sending emails
 	AwsSesRegion string
 
-	// AwsSesAccessKey is the AWS SES IAM user access key
+	// AwsSesAccessKey is the AWS SES IAM user access key, when it and the secret key are omitted the default AWS
+	// credential chain is used instead
 	AwsSesAccessKey string
 
 	// AwsSesSecretKey is the AWS SES IAM user secret key
-	AwsSesSecretKey string
+	AwsSesSecretKey Secret
+
+	// AwsSesEndpoint overrides the AWS SES endpoint, it is empty to use the regional AWS endpoint
+	AwsSesEndpoint string
+}
+
+// HasStaticCredentials is true when AWS SES credentials are configured, rather than taken from the default AWS
+// credential chain (environment, shared profile, container or instance role, web identity)
+func (emailConfig *EmailConfig) HasStaticCredentials() bool {
+	return len(emailConfig.AwsSesAccessKey) > 0 || emailConfig.AwsSesSecretKey.IsSet()
 }
 
-func (emailConfig *EmailConfig) Validate() (err error) {
+// Validate validates the email config, returning a *ValidationReport listing every problem if it isn't valid
+func (emailConfig *EmailConfig) Validate() error {
+	report := &ValidationReport{}
+	emailConfig.ValidateInto(report)
+	return report.Err()
+}
+
+// ValidateInto adds every problem with the email config to the report
+func (emailConfig *EmailConfig) ValidateInto(report *ValidationReport) {
 	// Validate the sender
-	err = validateEmailFormat(emailConfig.Sender)
+	err := validateEmailFormat(emailConfig.Sender)
 	if err != nil {
-		return
+		report.Add(FieldEmailSender, fmt.Sprintf("%s: '%s'", err.Error(), emailConfig.Sender))
 	}
 
 	// Validate the recipient
 	err = validateEmailFormat(emailConfig.Recipient)
 	if err != nil {
-		return
+		report.Add(FieldEmailRecipient, fmt.Sprintf("%s: '%s'", err.Error(), emailConfig.Recipient))
 	}
 
 	// Validate the email subject
 	if len(emailConfig.Subject) <= 0 {
-		err = errors.New(EmailSubjectInvalidError)
-		return
+		report.Add(FieldEmailSubject, EmailSubjectInvalidError)
 	}
 
 	// Validate the AWS SES region
 	if len(emailConfig.AwsSesRegion) <= 0 {
-		err = errors.New(AwsSesInvalidRegionError)
-		return
+		report.Add(FieldAwsSesRegion, AwsSesInvalidRegionError)
+	} else if !awsSesRegions[emailConfig.AwsSesRegion] {
+		report.Add(FieldAwsSesRegion, fmt.Sprintf("%s: '%s'", AwsSesUnknownRegionError, emailConfig.AwsSesRegion))
 	}
 
-	return
+	// Validate the AWS SES credentials, they're optional but one is no use without the other
+	if emailConfig.HasStaticCredentials() {
+		if len(emailConfig.AwsSesAccessKey) <= 0 {
+			report.Add(FieldAwsSesAccessKey, AwsSesAccessKeyMissingError)
+		}
+
+		if !emailConfig.AwsSesSecretKey.IsSet() {
+			report.Add(FieldAwsSesSecretKey, AwsSesSecretKeyMissingError)
+		}
+	}
+
+	// Validate the AWS SES endpoint override
+	if len(emailConfig.AwsSesEndpoint) > 0 {
+		endpoint, err := url.Parse(emailConfig.AwsSesEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) <= 0 {
+			report.Add(FieldAwsSesEndpoint, fmt.Sprintf("%s: '%s'", AwsSesEndpointInvalidError, emailConfig.AwsSesEndpoint))
+		}
+	}
+}
+
+// isReadableDir is true when the path is a directory that can be listed
+func isReadableDir(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+
+	info, err := dir.Stat()
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	_, err = dir.Readdirnames(1)
+	return err == nil || err == io.EOF
 }
 
 // validateEmailFormat validates that the provided email address is in the correct format
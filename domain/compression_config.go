@@ -0,0 +1,35 @@
+package domain
+
+import "fmt"
+
+const (
+	CompressionMinSizeInvalidError = "the smallest response compressed can't be negative"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldCompressionEnabled     = "compression.enabled"
+	FieldCompressionMinSize     = "compression.min_size"
+	FieldCompressionPrecompress = "compression.precompress"
+)
+
+// CompressionConfig configures the compression of responses with brotli or gzip
+type CompressionConfig struct {
+	// Enabled compresses the HTML, text, CSS, scripts and other text-based responses for clients which accept it
+	Enabled bool
+
+	// MinSize is the smallest response in bytes compressed, smaller responses are sent as they are as compressing them
+	// saves little or makes them larger
+	MinSize int64
+
+	// Precompress compresses the public files on start up so they aren't compressed for every request. Compressed
+	// copies built alongside the files, e.g. styles.css.br, are served without it
+	Precompress bool
+}
+
+// ValidateInto adds every problem with the compression config to the report
+func (compressionConfig *CompressionConfig) ValidateInto(report *ValidationReport) {
+	if compressionConfig.MinSize < 0 {
+		report.Add(FieldCompressionMinSize, fmt.Sprintf("%s: '%d'", CompressionMinSizeInvalidError, compressionConfig.MinSize))
+	}
+}
@@ -1,10 +1,22 @@
 package domain
 
 type ContactForm struct {
-	Name              string `json:"name" validate:"required"`
-	Email             string `json:"email" validate:"required"`
-	Company           string `json:"company" validate:"required"`
-	Number            string `json:"number" validate:"required"`
-	Message           string `json:"message" validate:"required"`
-	RecaptchaResponse string `json:"recaptchaResponse" validate:"required"`
+	// ID is the server assigned reference for the submission, it is never bound from the request
+	ID string `json:"-" form:"-"`
+
+	Name              string `json:"name" form:"name" validate:"required"`
+	Email             string `json:"email" form:"email" validate:"required,email"`
+	Company           string `json:"company" form:"company" validate:"required"`
+	Number            string `json:"number" form:"number" validate:"required"`
+	Message           string `json:"message" form:"message" validate:"required"`
+	RecaptchaResponse string `json:"recaptchaResponse" form:"g-recaptcha-response"`
+}
+
+// ContactFormReceipt is returned to the submitter once a contact form has been accepted
+type ContactFormReceipt struct {
+	// ID is the reference for the accepted submission
+	ID string `json:"id"`
+
+	// Status is the state of the submission
+	Status string `json:"status"`
 }
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	CspSourceInvalidError    = "CSP sources can't contain whitespace, semicolons or commas"
+	CspReportURIInvalidError = "CSP report URI must be a path or an absolute http or https URL"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldCspEnabled    = "csp.enabled"
+	FieldCspReportOnly = "csp.report_only"
+	FieldCspReportURI  = "csp.report_uri"
+	FieldCspDefaultSrc = "csp.default_src"
+	FieldCspScriptSrc  = "csp.script_src"
+	FieldCspStyleSrc   = "csp.style_src"
+	FieldCspImgSrc     = "csp.img_src"
+	FieldCspFontSrc    = "csp.font_src"
+	FieldCspConnectSrc = "csp.connect_src"
+	FieldCspFrameSrc   = "csp.frame_src"
+)
+
+// Content-Security-Policy headers
+const (
+	CspHeader           = "Content-Security-Policy"
+	CspReportOnlyHeader = "Content-Security-Policy-Report-Only"
+
+	// ReportingEndpointsHeader names the endpoints the Reporting API sends reports to
+	ReportingEndpointsHeader = "Reporting-Endpoints"
+)
+
+// CspReportingEndpoint is the Reporting API endpoint violations are reported to by browsers which no longer support
+// report-uri
+const CspReportingEndpoint = "csp-endpoint"
+
+// Fetch directives whose sources are configured
+const (
+	CspDefaultSrc = "default-src"
+	CspScriptSrc  = "script-src"
+	CspStyleSrc   = "style-src"
+	CspImgSrc     = "img-src"
+	CspFontSrc    = "font-src"
+	CspConnectSrc = "connect-src"
+	CspFrameSrc   = "frame-src"
+)
+
+// cspDirectives are the configurable directives in the order they're written, with the config field of each
+var cspDirectives = []struct {
+	Directive string
+	Field     string
+}{
+	{CspDefaultSrc, FieldCspDefaultSrc},
+	{CspScriptSrc, FieldCspScriptSrc},
+	{CspStyleSrc, FieldCspStyleSrc},
+	{CspImgSrc, FieldCspImgSrc},
+	{CspFontSrc, FieldCspFontSrc},
+	{CspConnectSrc, FieldCspConnectSrc},
+	{CspFrameSrc, FieldCspFrameSrc},
+}
+
+// cspFixedDirectives are always part of the policy. Plugins, <base> and posting forms elsewhere are never needed and
+// the site is never framed
+const cspFixedDirectives = "object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// CSPConfig configures the Content-Security-Policy sent with every response
+type CSPConfig struct {
+	// Enabled sends the policy
+	Enabled bool
+
+	// ReportOnly sends the policy in the report only header, so violations are reported but nothing is blocked
+	ReportOnly bool
+
+	// ReportURI is where browsers report violations, it is empty to not have them reported
+	ReportURI string
+
+	// Sources are the sources allowed by each fetch directive, keyed by directive, e.g. script-src
+	Sources map[string][]string
+}
+
+// HeaderName is the header the policy is sent in
+func (cspConfig *CSPConfig) HeaderName() string {
+	if cspConfig.ReportOnly {
+		return CspReportOnlyHeader
+	}
+	return CspHeader
+}
+
+// Build builds the policy for a response. The nonce is allowed by script-src, so the inline scripts given it run
+// while any others, such as injected ones, don't
+func (cspConfig *CSPConfig) Build(nonce string) string {
+	directives := []string{}
+	for _, cspDirective := range cspDirectives {
+		sources := cspConfig.Sources[cspDirective.Directive]
+		if cspDirective.Directive == CspScriptSrc && len(nonce) > 0 {
+			sources = append(append([]string{}, sources...), fmt.Sprintf("'nonce-%s'", nonce))
+		}
+		if len(sources) <= 0 {
+			continue
+		}
+		directives = append(directives, cspDirective.Directive+" "+strings.Join(sources, " "))
+	}
+
+	directives = append(directives, cspFixedDirectives)
+	if len(cspConfig.ReportURI) > 0 {
+		directives = append(directives, "report-uri "+cspConfig.ReportURI, "report-to "+CspReportingEndpoint)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// ReportingEndpoints is the Reporting-Endpoints header naming where violations are reported to, it is empty when they
+// aren't reported
+func (cspConfig *CSPConfig) ReportingEndpoints() string {
+	if len(cspConfig.ReportURI) <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s=\"%s\"", CspReportingEndpoint, cspConfig.ReportURI)
+}
+
+// ValidateInto adds every problem with the CSP config to the report
+func (cspConfig *CSPConfig) ValidateInto(report *ValidationReport) {
+	if !cspConfig.Enabled {
+		return
+	}
+
+	for _, cspDirective := range cspDirectives {
+		for _, source := range cspConfig.Sources[cspDirective.Directive] {
+			if len(source) <= 0 || strings.ContainsAny(source, " \t\r\n;,") {
+				report.Add(cspDirective.Field, fmt.Sprintf("%s: '%s'", CspSourceInvalidError, source))
+			}
+		}
+	}
+
+	if len(cspConfig.ReportURI) > 0 {
+		reportURI, err := url.Parse(cspConfig.ReportURI)
+		isPath := err == nil && !reportURI.IsAbs() && strings.HasPrefix(reportURI.Path, "/")
+		isURL := err == nil && (reportURI.Scheme == "http" || reportURI.Scheme == "https") && len(reportURI.Host) > 0
+		if !isPath && !isURL || strings.ContainsAny(cspConfig.ReportURI, " ;,") {
+			report.Add(FieldCspReportURI, fmt.Sprintf("%s: '%s'", CspReportURIInvalidError, cspConfig.ReportURI))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	ErrorReportingDsnInvalidError        = "error reporting DSN must be a URL like https://<key>@<host>/<project>"
+	ErrorReportingSampleRateInvalidError = "error reporting sample rate must be between 0 and 1"
+	ErrorReportingRateLimitInvalidError  = "error reporting rate limit must be greater than zero"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldErrorReportingDsn         = "error_reporting.dsn"
+	FieldErrorReportingEnvironment = "error_reporting.environment"
+	FieldErrorReportingRelease     = "error_reporting.release"
+	FieldErrorReportingSampleRate  = "error_reporting.sample_rate"
+	FieldErrorReportingRateLimit   = "error_reporting.rate_limit"
+)
+
+// ErrorReportingConfig configures the reporting of errors to a Sentry compatible error tracker
+type ErrorReportingConfig struct {
+	// Dsn is the Sentry DSN errors are reported to, e.g. https://<key>@sentry.example.com/<project>. Errors aren't
+	// reported when it is empty
+	Dsn Secret
+
+	// Environment is the environment errors are reported from, e.g. production
+	Environment string
+
+	// Release is the version errors are reported from, the version the application was built as when it is empty
+	Release string
+
+	// SampleRate is the fraction of errors reported, from 0 to 1
+	SampleRate float64
+
+	// RateLimit is the most errors reported a minute, the rest are dropped
+	RateLimit int
+}
+
+// Enabled is true when errors are reported
+func (errorReportingConfig *ErrorReportingConfig) Enabled() bool {
+	return errorReportingConfig.Dsn.IsSet()
+}
+
+// ParseDsn parses the DSN into the public key, the URL of the server and the project ID. The project ID is the last
+// segment of the DSN's path, anything before it is the path the server is hosted under
+func (errorReportingConfig *ErrorReportingConfig) ParseDsn() (string, *url.URL, string, error) {
+	dsn, err := url.Parse(errorReportingConfig.Dsn.Reveal())
+	if err != nil {
+		return "", nil, "", errors.New(ErrorReportingDsnInvalidError)
+	}
+
+	path := strings.Trim(dsn.Path, "/")
+	serverPath, projectID := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		serverPath, projectID = "/"+path[:i], path[i+1:]
+	}
+
+	if (dsn.Scheme != "http" && dsn.Scheme != "https") || len(dsn.Host) <= 0 || dsn.User == nil ||
+		len(dsn.User.Username()) <= 0 || len(projectID) <= 0 {
+		return "", nil, "", errors.New(ErrorReportingDsnInvalidError)
+	}
+
+	server := &url.URL{
+		Scheme: dsn.Scheme,
+		Host:   dsn.Host,
+		Path:   serverPath,
+	}
+	return dsn.User.Username(), server, projectID, nil
+}
+
+// ValidateInto adds every problem with the error reporting config to the report
+func (errorReportingConfig *ErrorReportingConfig) ValidateInto(report *ValidationReport) {
+	if !errorReportingConfig.Enabled() {
+		return
+	}
+
+	// The DSN isn't included in the problem as it is a secret
+	_, _, _, err := errorReportingConfig.ParseDsn()
+	if err != nil {
+		report.Add(FieldErrorReportingDsn, err.Error())
+	}
+
+	if errorReportingConfig.SampleRate < 0 || errorReportingConfig.SampleRate > 1 {
+		report.Add(FieldErrorReportingSampleRate, fmt.Sprintf("%s: '%g'", ErrorReportingSampleRateInvalidError, errorReportingConfig.SampleRate))
+	}
+
+	if errorReportingConfig.RateLimit <= 0 {
+		report.Add(FieldErrorReportingRateLimit, ErrorReportingRateLimitInvalidError)
+	}
+}
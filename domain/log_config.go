@@ -0,0 +1,196 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	LogLevelInvalidError          = "log level must be one of debug, info, warn or error"
+	LogFormatInvalidError         = "log format must be one of json, text or logfmt"
+	LogOutputInvalidError         = "log output must be one of stdout, file or syslog"
+	LogFileMissingError           = "log file was not provided for the file output"
+	LogFileMaxSizeInvalidError    = "log file max size must be greater than zero"
+	LogFileMaxBackupsInvalidError = "log file max backups can't be negative"
+	LogSyslogAddressInvalidError  = "syslog address must be a udp, tcp or unix URL"
+	LogFieldLevelInvalidError     = "log field levels must be field:level pairs with a level of debug, info, warn or error"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldLogLevel          = "log.level"
+	FieldLogFormat         = "log.format"
+	FieldLogOutputs        = "log.outputs"
+	FieldLogFile           = "log.file"
+	FieldLogFileMaxSize    = "log.file_max_megabytes"
+	FieldLogFileMaxBackups = "log.file_max_backups"
+	FieldLogSyslogAddress  = "log.syslog_address"
+
+	FieldLogRedactFields       = "log.redact_fields"
+	FieldLogFieldLevels        = "log.field_levels"
+	FieldLogDetectPersonalData = "log.detect_personal_data"
+)
+
+// Log levels, from the most to the least verbose
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// Log formats
+const (
+	// LogFormatJSON writes a JSON object per line
+	LogFormatJSON = "json"
+
+	// LogFormatText writes coloured, human readable lines to terminals and logfmt to everything else
+	LogFormatText = "text"
+
+	// LogFormatLogfmt writes key=value pairs
+	LogFormatLogfmt = "logfmt"
+)
+
+// Log outputs
+const (
+	LogOutputStdout = "stdout"
+
+	// LogOutputFile writes to a file which is rotated when it gets too large
+	LogOutputFile = "file"
+
+	LogOutputSyslog = "syslog"
+)
+
+// LogConfig configures what is logged, how it is formatted and where it is written to
+type LogConfig struct {
+	// Level is the least severe level logged
+	Level string
+
+	Format string
+
+	// Outputs are every output written to
+	Outputs []string
+
+	// File is the file written to by the file output
+	File string
+
+	// FileMaxMegabytes is how large the file can get before it is rotated
+	FileMaxMegabytes int
+
+	// FileMaxBackups is how many rotated files are kept, zero keeps them all
+	FileMaxBackups int
+
+	// SyslogAddress is the syslog server written to by the syslog output, e.g. udp://logs.example.com:514. It is
+	// empty to write to the local syslog
+	SyslogAddress string
+
+	// RedactFields are the fields whose values are masked on every line, matched case insensitively
+	RedactFields []string
+
+	// FieldLevels are the most severe level each field is logged at, it is masked on more severe lines. Fields which
+	// aren't listed are logged at every level
+	FieldLevels map[string]string
+
+	// DetectPersonalData masks email addresses and phone numbers found in messages and the values of any field
+	DetectPersonalData bool
+}
+
+// HasOutput is true when the output is written to
+func (logConfig *LogConfig) HasOutput(output string) bool {
+	for _, configuredOutput := range logConfig.Outputs {
+		if configuredOutput == output {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLogLevel is true when the level is one of the log levels
+func IsLogLevel(level string) bool {
+	switch level {
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseLogFieldLevels parses field:level pairs, e.g. ip:info, into the level each field is logged at
+func ParseLogFieldLevels(pairs []string) (map[string]string, error) {
+	fieldLevels := make(map[string]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) <= 0 {
+			return nil, fmt.Errorf("%s: '%s'", LogFieldLevelInvalidError, pair)
+		}
+		fieldLevels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return fieldLevels, nil
+}
+
+// ValidateInto adds every problem with the log config to the report
+func (logConfig *LogConfig) ValidateInto(report *ValidationReport) {
+	if !IsLogLevel(logConfig.Level) {
+		report.Add(FieldLogLevel, fmt.Sprintf("%s: '%s'", LogLevelInvalidError, logConfig.Level))
+	}
+
+	for field, level := range logConfig.FieldLevels {
+		if !IsLogLevel(level) {
+			report.Add(FieldLogFieldLevels, fmt.Sprintf("%s: '%s:%s'", LogFieldLevelInvalidError, field, level))
+		}
+	}
+
+	switch logConfig.Format {
+	case LogFormatJSON, LogFormatText, LogFormatLogfmt:
+	default:
+		report.Add(FieldLogFormat, fmt.Sprintf("%s: '%s'", LogFormatInvalidError, logConfig.Format))
+	}
+
+	for _, output := range logConfig.Outputs {
+		switch output {
+		case LogOutputStdout, LogOutputFile, LogOutputSyslog:
+		default:
+			report.Add(FieldLogOutputs, fmt.Sprintf("%s: '%s'", LogOutputInvalidError, output))
+		}
+	}
+
+	if logConfig.HasOutput(LogOutputFile) {
+		if len(strings.TrimSpace(logConfig.File)) <= 0 {
+			report.Add(FieldLogFile, LogFileMissingError)
+		}
+
+		if logConfig.FileMaxMegabytes <= 0 {
+			report.Add(FieldLogFileMaxSize, LogFileMaxSizeInvalidError)
+		}
+
+		if logConfig.FileMaxBackups < 0 {
+			report.Add(FieldLogFileMaxBackups, LogFileMaxBackupsInvalidError)
+		}
+	}
+
+	if logConfig.HasOutput(LogOutputSyslog) && len(logConfig.SyslogAddress) > 0 {
+		network, _, err := logConfig.SyslogNetworkAddress()
+		if err != nil || (network != "udp" && network != "tcp" && network != "unix") {
+			report.Add(FieldLogSyslogAddress, fmt.Sprintf("%s: '%s'", LogSyslogAddressInvalidError, logConfig.SyslogAddress))
+		}
+	}
+}
+
+// SyslogNetworkAddress splits the syslog address into the network and address to dial, they're both empty for the
+// local syslog
+func (logConfig *LogConfig) SyslogNetworkAddress() (string, string, error) {
+	if len(logConfig.SyslogAddress) <= 0 {
+		return "", "", nil
+	}
+
+	address, err := url.Parse(logConfig.SyslogAddress)
+	if err != nil {
+		return "", "", err
+	}
+
+	if address.Scheme == "unix" {
+		return address.Scheme, address.Path, nil
+	}
+	return address.Scheme, address.Host, nil
+}
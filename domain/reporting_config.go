@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ReportingRateLimitInvalidError    = "browser report rate limit must be greater than zero"
+	ReportingDedupeWindowInvalidError = "browser report de-duplication window can't be negative"
+	ReportingMaxReportsInvalidError   = "the most distinct browser reports kept must be greater than zero"
+	ReportingNelMaxAgeInvalidError    = "NEL max age can't be negative"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldReportingRateLimit    = "reporting.rate_limit"
+	FieldReportingDedupeWindow = "reporting.dedupe_window"
+	FieldReportingMaxReports   = "reporting.max_reports"
+	FieldReportingNelMaxAge    = "reporting.nel_max_age"
+)
+
+// ReportingConfig configures the collection of the CSP violation and Network Error Logging reports browsers send
+type ReportingConfig struct {
+	// RateLimit is the most reports accepted from a client a minute, the rest are rejected
+	RateLimit int
+
+	// DedupeWindow is how long the same report from the same client is ignored for after it is counted, browsers
+	// send a report for every violation so a single page view can otherwise be counted many times
+	DedupeWindow time.Duration
+
+	// MaxReports is the most distinct reports counted, the report seen least recently is forgotten to make room for a
+	// new one once it is reached, so a flood of junk can't use up the memory
+	MaxReports int
+
+	// NelMaxAge is how long browsers are asked to report network errors for, zero to not ask them
+	NelMaxAge time.Duration
+}
+
+// ValidateInto adds every problem with the reporting config to the report
+func (reportingConfig *ReportingConfig) ValidateInto(report *ValidationReport) {
+	if reportingConfig.RateLimit <= 0 {
+		report.Add(FieldReportingRateLimit, ReportingRateLimitInvalidError)
+	}
+
+	if reportingConfig.DedupeWindow < 0 {
+		report.Add(FieldReportingDedupeWindow, fmt.Sprintf("%s: '%s'", ReportingDedupeWindowInvalidError, reportingConfig.DedupeWindow))
+	}
+
+	if reportingConfig.MaxReports <= 0 {
+		report.Add(FieldReportingMaxReports, ReportingMaxReportsInvalidError)
+	}
+
+	if reportingConfig.NelMaxAge < 0 {
+		report.Add(FieldReportingNelMaxAge, fmt.Sprintf("%s: '%s'", ReportingNelMaxAgeInvalidError, reportingConfig.NelMaxAge))
+	}
+}
@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// redactedSecret is printed in place of a secret's value
+const redactedSecret = "********"
+
+// Secret is a sensitive configuration value. It redacts itself when printed, logged or marshalled, use Reveal to get
+// the actual value
+type Secret string
+
+// Reveal returns the actual value of the secret
+func (secret Secret) Reveal() string {
+	return string(secret)
+}
+
+// IsSet is true when the secret has a value
+func (secret Secret) IsSet() bool {
+	return len(secret) > 0
+}
+
+// redacted is what's shown in place of the secret, an unset secret is shown as empty so it's clear it is missing
+func (secret Secret) redacted() string {
+	if !secret.IsSet() {
+		return ""
+	}
+	return redactedSecret
+}
+
+// String implements fmt.Stringer
+func (secret Secret) String() string {
+	return secret.redacted()
+}
+
+// GoString implements fmt.GoStringer
+func (secret Secret) GoString() string {
+	return fmt.Sprintf("domain.Secret(%q)", secret.redacted())
+}
+
+// Format implements fmt.Formatter so every verb, including %x and %q, is redacted
+func (secret Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		fmt.Fprint(f, secret.GoString())
+		return
+	}
+
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", secret.redacted())
+		return
+	}
+
+	fmt.Fprint(f, secret.redacted())
+}
+
+// MarshalJSON implements json.Marshaler
+func (secret Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(secret.redacted())
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (secret Secret) MarshalText() ([]byte, error) {
+	return []byte(secret.redacted()), nil
+}
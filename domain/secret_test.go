@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecretIsRedactedWhenPrinted(t *testing.T) {
+	secret := Secret("hunter2")
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x", "%X", "%10s"} {
+		assert.NotContains(t, fmt.Sprintf(format, secret), "hunter2", format)
+		assert.NotContains(t, fmt.Sprintf(format, secret), "68756e74657232", format)
+	}
+	assert.Equal(t, redactedSecret, fmt.Sprint(secret))
+}
+
+func TestSecretIsRedactedInStructs(t *testing.T) {
+	emailConfig := &EmailConfig{AwsSesSecretKey: "hunter2"}
+
+	assert.NotContains(t, fmt.Sprintf("%+v", emailConfig), "hunter2")
+	assert.NotContains(t, fmt.Sprintf("%#v", emailConfig), "hunter2")
+
+	marshalled, err := json.Marshal(emailConfig)
+	assert.NoError(t, err, "unable to marshal email config")
+	assert.NotContains(t, string(marshalled), "hunter2")
+}
+
+func TestSecretCanBeRevealed(t *testing.T) {
+	assert.Equal(t, "hunter2", Secret("hunter2").Reveal())
+	assert.Equal(t, "", fmt.Sprint(Secret("")))
+}
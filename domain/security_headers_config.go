@@ -0,0 +1,274 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SecurityHeaderValueInvalidError       = "security header values can't contain line breaks"
+	SecurityHeaderOverrideInvalidError    = "security header overrides must be like '<path> <header>=<value>'"
+	SecurityHeaderUnknownError            = "only the security headers can be overridden"
+	ReferrerPolicyInvalidError            = "unknown Referrer-Policy"
+	CrossOriginOpenerPolicyInvalidError   = "Cross-Origin-Opener-Policy must be same-origin, same-origin-allow-popups or unsafe-none"
+	CrossOriginResourcePolicyInvalidError = "Cross-Origin-Resource-Policy must be same-origin, same-site or cross-origin"
+	StrictTransportSecurityInvalidError   = "Strict-Transport-Security must start with max-age="
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldSecurityHeadersFrameOptions              = "security_headers.frame_options"
+	FieldSecurityHeadersContentTypeOptions        = "security_headers.content_type_options"
+	FieldSecurityHeadersXSSProtection             = "security_headers.xss_protection"
+	FieldSecurityHeadersReferrerPolicy            = "security_headers.referrer_policy"
+	FieldSecurityHeadersPermissionsPolicy         = "security_headers.permissions_policy"
+	FieldSecurityHeadersCrossOriginOpenerPolicy   = "security_headers.cross_origin_opener_policy"
+	FieldSecurityHeadersCrossOriginResourcePolicy = "security_headers.cross_origin_resource_policy"
+	FieldSecurityHeadersOverrides                 = "security_headers.overrides"
+)
+
+// Security headers sent with every response
+const (
+	FrameOptionsHeader              = "X-Frame-Options"
+	ContentTypeOptionsHeader        = "X-Content-Type-Options"
+	XSSProtectionHeader             = "X-XSS-Protection"
+	ReferrerPolicyHeader            = "Referrer-Policy"
+	PermissionsPolicyHeader         = "Permissions-Policy"
+	CrossOriginOpenerPolicyHeader   = "Cross-Origin-Opener-Policy"
+	CrossOriginResourcePolicyHeader = "Cross-Origin-Resource-Policy"
+)
+
+// StrictTransportSecurityHeader is only sent over HTTPS, its value comes from the TLS config
+const StrictTransportSecurityHeader = "Strict-Transport-Security"
+
+// SecurityHeaderNames are the security headers in the order they're sent
+var SecurityHeaderNames = []string{
+	FrameOptionsHeader,
+	ContentTypeOptionsHeader,
+	XSSProtectionHeader,
+	ReferrerPolicyHeader,
+	PermissionsPolicyHeader,
+	CrossOriginOpenerPolicyHeader,
+	CrossOriginResourcePolicyHeader,
+}
+
+// securityHeadersOverrideSeparator separates the overrides in the config, header values can contain commas and
+// semicolons
+const securityHeadersOverrideSeparator = "|"
+
+var referrerPolicies = map[string]bool{
+	"no-referrer":                     true,
+	"no-referrer-when-downgrade":      true,
+	"origin":                          true,
+	"origin-when-cross-origin":        true,
+	"same-origin":                     true,
+	"strict-origin":                   true,
+	"strict-origin-when-cross-origin": true,
+	"unsafe-url":                      true,
+}
+
+var crossOriginOpenerPolicies = map[string]bool{
+	"same-origin":              true,
+	"same-origin-allow-popups": true,
+	"unsafe-none":              true,
+}
+
+var crossOriginResourcePolicies = map[string]bool{
+	"same-origin":  true,
+	"same-site":    true,
+	"cross-origin": true,
+}
+
+// SecurityHeadersOverride replaces a security header for the paths starting with a prefix
+type SecurityHeadersOverride struct {
+	PathPrefix string
+
+	// Header is one of the SecurityHeaderNames or the StrictTransportSecurityHeader
+	Header string
+
+	// Value replaces the header's value, it is empty to not send the header
+	Value string
+}
+
+// String formats the override as it is written in the config
+func (override SecurityHeadersOverride) String() string {
+	return fmt.Sprintf("%s %s=%s", override.PathPrefix, override.Header, override.Value)
+}
+
+// SecurityHeader is a header and its value
+type SecurityHeader struct {
+	Name string
+
+	Value string
+}
+
+// SecurityHeadersConfig configures the security headers sent with every response, other than the
+// Content-Security-Policy and Strict-Transport-Security, which can still be overridden. A header is not sent when its
+// value is empty
+type SecurityHeadersConfig struct {
+	FrameOptions string
+
+	ContentTypeOptions string
+
+	XSSProtection string
+
+	ReferrerPolicy string
+
+	PermissionsPolicy string
+
+	CrossOriginOpenerPolicy string
+
+	CrossOriginResourcePolicy string
+
+	// Overrides replace headers for particular paths, the override with the longest matching prefix is used
+	Overrides []SecurityHeadersOverride
+}
+
+// ParseSecurityHeadersOverrides parses overrides separated by |, each like "/admin Referrer-Policy=no-referrer"
+func ParseSecurityHeadersOverrides(value string) ([]SecurityHeadersOverride, error) {
+	overrides := make([]SecurityHeadersOverride, 0)
+	for _, entry := range strings.Split(value, securityHeadersOverrideSeparator) {
+		entry = strings.TrimSpace(entry)
+		if len(entry) <= 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, " ", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("%s: '%s'", SecurityHeaderOverrideInvalidError, entry)
+		}
+
+		header := strings.SplitN(strings.TrimSpace(parts[1]), "=", 2)
+		if len(header) != 2 {
+			return nil, fmt.Errorf("%s: '%s'", SecurityHeaderOverrideInvalidError, entry)
+		}
+
+		overrides = append(overrides, SecurityHeadersOverride{
+			PathPrefix: parts[0],
+			Header:     canonicalSecurityHeader(strings.TrimSpace(header[0])),
+			Value:      strings.TrimSpace(header[1]),
+		})
+	}
+	return overrides, nil
+}
+
+// canonicalSecurityHeader writes a security header the way it is sent whatever its case, e.g. x-xss-protection is
+// X-XSS-Protection. Other headers are returned as they are
+func canonicalSecurityHeader(header string) string {
+	for _, name := range append([]string{StrictTransportSecurityHeader}, SecurityHeaderNames...) {
+		if strings.EqualFold(name, header) {
+			return name
+		}
+	}
+	return header
+}
+
+// values are the configured value of each header, before any overrides. Strict-Transport-Security is configured by
+// the TLS config so is empty here
+func (securityHeadersConfig *SecurityHeadersConfig) values() map[string]string {
+	return map[string]string{
+		StrictTransportSecurityHeader:   "",
+		FrameOptionsHeader:              securityHeadersConfig.FrameOptions,
+		ContentTypeOptionsHeader:        securityHeadersConfig.ContentTypeOptions,
+		XSSProtectionHeader:             securityHeadersConfig.XSSProtection,
+		ReferrerPolicyHeader:            securityHeadersConfig.ReferrerPolicy,
+		PermissionsPolicyHeader:         securityHeadersConfig.PermissionsPolicy,
+		CrossOriginOpenerPolicyHeader:   securityHeadersConfig.CrossOriginOpenerPolicy,
+		CrossOriginResourcePolicyHeader: securityHeadersConfig.CrossOriginResourcePolicy,
+	}
+}
+
+// HeadersFor returns the headers sent with the responses for a path, in the order they're sent, with the overrides for
+// the path applied. strictTransportSecurity is the HSTS header for the request, empty when it isn't made over HTTPS,
+// in which case an override can't add it
+func (securityHeadersConfig *SecurityHeadersConfig) HeadersFor(path string, strictTransportSecurity string) []SecurityHeader {
+	values := securityHeadersConfig.values()
+	values[StrictTransportSecurityHeader] = strictTransportSecurity
+	matchedPrefixLengths := make(map[string]int)
+	for _, override := range securityHeadersConfig.Overrides {
+		prefixLength, isMatched := matchedPrefixLengths[override.Header]
+		if !strings.HasPrefix(path, override.PathPrefix) || (isMatched && prefixLength > len(override.PathPrefix)) {
+			continue
+		}
+		values[override.Header] = override.Value
+		matchedPrefixLengths[override.Header] = len(override.PathPrefix)
+	}
+	if len(strictTransportSecurity) <= 0 {
+		values[StrictTransportSecurityHeader] = ""
+	}
+
+	headers := make([]SecurityHeader, 0, len(SecurityHeaderNames)+1)
+	for _, name := range append([]string{StrictTransportSecurityHeader}, SecurityHeaderNames...) {
+		if len(values[name]) > 0 {
+			headers = append(headers, SecurityHeader{Name: name, Value: values[name]})
+		}
+	}
+	return headers
+}
+
+// ValidateInto adds every problem with the security headers config to the report
+func (securityHeadersConfig *SecurityHeadersConfig) ValidateInto(report *ValidationReport) {
+	fields := map[string]string{
+		FrameOptionsHeader:              FieldSecurityHeadersFrameOptions,
+		ContentTypeOptionsHeader:        FieldSecurityHeadersContentTypeOptions,
+		XSSProtectionHeader:             FieldSecurityHeadersXSSProtection,
+		ReferrerPolicyHeader:            FieldSecurityHeadersReferrerPolicy,
+		PermissionsPolicyHeader:         FieldSecurityHeadersPermissionsPolicy,
+		CrossOriginOpenerPolicyHeader:   FieldSecurityHeadersCrossOriginOpenerPolicy,
+		CrossOriginResourcePolicyHeader: FieldSecurityHeadersCrossOriginResourcePolicy,
+	}
+
+	values := securityHeadersConfig.values()
+	for _, name := range SecurityHeaderNames {
+		problem := validateSecurityHeader(name, values[name])
+		if len(problem) > 0 {
+			report.Add(fields[name], problem)
+		}
+	}
+
+	for _, override := range securityHeadersConfig.Overrides {
+		if _, isKnown := values[override.Header]; !isKnown {
+			report.Add(FieldSecurityHeadersOverrides, fmt.Sprintf("%s: '%s'", SecurityHeaderUnknownError, override))
+			continue
+		}
+
+		problem := validateSecurityHeader(override.Header, override.Value)
+		if len(problem) > 0 {
+			report.Add(FieldSecurityHeadersOverrides, problem)
+		}
+	}
+}
+
+// validateSecurityHeader returns the problem with a header's value, or an empty string if it is valid
+func validateSecurityHeader(name string, value string) string {
+	if len(value) <= 0 {
+		return ""
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return SecurityHeaderValueInvalidError
+	}
+
+	switch name {
+	case ReferrerPolicyHeader:
+		// Browsers use the last policy in the list they understand
+		for _, policy := range strings.Split(value, ",") {
+			if !referrerPolicies[strings.TrimSpace(policy)] {
+				return fmt.Sprintf("%s: '%s'", ReferrerPolicyInvalidError, value)
+			}
+		}
+	case CrossOriginOpenerPolicyHeader:
+		if !crossOriginOpenerPolicies[value] {
+			return fmt.Sprintf("%s: '%s'", CrossOriginOpenerPolicyInvalidError, value)
+		}
+	case CrossOriginResourcePolicyHeader:
+		if !crossOriginResourcePolicies[value] {
+			return fmt.Sprintf("%s: '%s'", CrossOriginResourcePolicyInvalidError, value)
+		}
+	case StrictTransportSecurityHeader:
+		if !strings.HasPrefix(value, "max-age=") {
+			return fmt.Sprintf("%s: '%s'", StrictTransportSecurityInvalidError, value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	TlsConflictError            = "TLS certificate files and ACME domains can't both be configured"
+	TlsKeyFileMissingError      = "TLS key file must be provided with the certificate file"
+	TlsCertFileMissingError     = "TLS certificate file must be provided with the key file"
+	TlsFileUnreadableError      = "TLS file is not a readable file"
+	TlsHttpsPortInvalidError    = "provided HTTPS port was not valid"
+	TlsHttpsPortConflictError   = "HTTPS port must differ from the HTTP port"
+	TlsAcmeCacheDirMissingError = "ACME certificate cache directory was not provided"
+	TlsAcmeDirectoryURLError    = "ACME directory URL must be an absolute https URL"
+	HstsMaxAgeInvalidError      = "HSTS max age can't be negative"
+	HstsPreloadInvalidError     = "HSTS preload needs a max age of at least a year and subdomains to be included"
+)
+
+// Fields reported in validation reports, named after their keys in the config file
+const (
+	FieldTlsHttpsPort             = "tls.https_port"
+	FieldTlsCertFile              = "tls.cert_file"
+	FieldTlsKeyFile               = "tls.key_file"
+	FieldTlsAcmeDomains           = "tls.acme_domains"
+	FieldTlsAcmeEmail             = "tls.acme_email"
+	FieldTlsAcmeCacheDir          = "tls.acme_cache_dir"
+	FieldTlsAcmeDirectoryURL      = "tls.acme_directory_url"
+	FieldTlsHstsMaxAge            = "tls.hsts_max_age"
+	FieldTlsHstsIncludeSubdomains = "tls.hsts_include_subdomains"
+	FieldTlsHstsPreload           = "tls.hsts_preload"
+)
+
+// minHstsPreloadMaxAge is the shortest HSTS max-age browsers' preload lists accept
+const minHstsPreloadMaxAge = 365 * 24 * time.Hour
+
+// TLSConfig configures serving HTTPS natively, rather than behind a load balancer. TLS is enabled by configuring either
+// a certificate and key file or the domains to get certificates for from an ACME certificate authority such as
+// Let's Encrypt. The HTTP port then only redirects to HTTPS
+type TLSConfig struct {
+	// HttpsPort is the port to serve HTTPS on
+	HttpsPort int
+
+	// CertFile is the PEM encoded certificate chain, it is reloaded when it changes
+	CertFile string
+
+	// KeyFile is the PEM encoded private key for the certificate
+	KeyFile string
+
+	// AcmeDomains are the domains to get certificates for automatically
+	AcmeDomains []string
+
+	// AcmeEmail is the contact email address given to the certificate authority, it is optional
+	AcmeEmail string
+
+	// AcmeCacheDir is where certificates from the certificate authority are kept between restarts
+	AcmeCacheDir string
+
+	// AcmeDirectoryURL overrides the certificate authority, e.g. to use the Let's Encrypt staging environment
+	AcmeDirectoryURL string
+
+	// HstsMaxAge is the max-age of the Strict-Transport-Security header sent over HTTPS, zero doesn't send it
+	HstsMaxAge time.Duration
+
+	// HstsIncludeSubdomains extends HSTS to every subdomain
+	HstsIncludeSubdomains bool
+
+	// HstsPreload asks for the domain to be included in browsers' HSTS preload lists, which is hard to undo
+	HstsPreload bool
+}
+
+// StrictTransportSecurity is the Strict-Transport-Security header sent over HTTPS, it is empty when HSTS isn't
+// configured or there is no TLS config
+func (tlsConfig *TLSConfig) StrictTransportSecurity() string {
+	if tlsConfig == nil || tlsConfig.HstsMaxAge <= 0 {
+		return ""
+	}
+
+	value := fmt.Sprintf("max-age=%d", int64(tlsConfig.HstsMaxAge.Seconds()))
+	if tlsConfig.HstsIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if tlsConfig.HstsPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+// Enabled is true when HTTPS should be served natively, it is false when there is no TLS config
+func (tlsConfig *TLSConfig) Enabled() bool {
+	return tlsConfig.UsesCertFiles() || tlsConfig.UsesAcme()
+}
+
+// UsesCertFiles is true when the certificate is read from files
+func (tlsConfig *TLSConfig) UsesCertFiles() bool {
+	return tlsConfig != nil && (len(tlsConfig.CertFile) > 0 || len(tlsConfig.KeyFile) > 0)
+}
+
+// UsesAcme is true when certificates are got from an ACME certificate authority
+func (tlsConfig *TLSConfig) UsesAcme() bool {
+	return tlsConfig != nil && len(tlsConfig.AcmeDomains) > 0
+}
+
+// ValidateInto adds every problem with the TLS config to the report, httpPort is the port the redirect listener uses
+func (tlsConfig *TLSConfig) ValidateInto(report *ValidationReport, httpPort int) {
+	if tlsConfig.HstsMaxAge < 0 {
+		report.Add(FieldTlsHstsMaxAge, HstsMaxAgeInvalidError)
+	}
+
+	if tlsConfig.HstsPreload && (tlsConfig.HstsMaxAge < minHstsPreloadMaxAge || !tlsConfig.HstsIncludeSubdomains) {
+		report.Add(FieldTlsHstsPreload, HstsPreloadInvalidError)
+	}
+
+	if !tlsConfig.Enabled() {
+		return
+	}
+
+	if tlsConfig.HttpsPort < minHttpPort || tlsConfig.HttpsPort > maxHttpPort {
+		report.Add(FieldTlsHttpsPort, fmt.Sprintf("%s, must be between %d and %d", TlsHttpsPortInvalidError, minHttpPort, maxHttpPort))
+	} else if tlsConfig.HttpsPort == httpPort {
+		report.Add(FieldTlsHttpsPort, TlsHttpsPortConflictError)
+	}
+
+	if tlsConfig.UsesCertFiles() && tlsConfig.UsesAcme() {
+		report.Add(FieldTlsAcmeDomains, TlsConflictError)
+	}
+
+	if tlsConfig.UsesCertFiles() {
+		if len(tlsConfig.CertFile) <= 0 {
+			report.Add(FieldTlsCertFile, TlsCertFileMissingError)
+		} else if !isReadableFile(tlsConfig.CertFile) {
+			report.Add(FieldTlsCertFile, fmt.Sprintf("%s: '%s'", TlsFileUnreadableError, tlsConfig.CertFile))
+		}
+
+		if len(tlsConfig.KeyFile) <= 0 {
+			report.Add(FieldTlsKeyFile, TlsKeyFileMissingError)
+		} else if !isReadableFile(tlsConfig.KeyFile) {
+			report.Add(FieldTlsKeyFile, fmt.Sprintf("%s: '%s'", TlsFileUnreadableError, tlsConfig.KeyFile))
+		}
+	}
+
+	if tlsConfig.UsesAcme() {
+		if len(tlsConfig.AcmeEmail) > 0 {
+			err := validateEmailFormat(tlsConfig.AcmeEmail)
+			if err != nil {
+				report.Add(FieldTlsAcmeEmail, fmt.Sprintf("%s: '%s'", err.Error(), tlsConfig.AcmeEmail))
+			}
+		}
+
+		if len(tlsConfig.AcmeCacheDir) <= 0 {
+			report.Add(FieldTlsAcmeCacheDir, TlsAcmeCacheDirMissingError)
+		}
+
+		if len(tlsConfig.AcmeDirectoryURL) > 0 {
+			directoryURL, err := url.Parse(tlsConfig.AcmeDirectoryURL)
+			if err != nil || directoryURL.Scheme != "https" || len(directoryURL.Host) <= 0 {
+				report.Add(FieldTlsAcmeDirectoryURL, fmt.Sprintf("%s: '%s'", TlsAcmeDirectoryURLError, tlsConfig.AcmeDirectoryURL))
+			}
+		}
+	}
+}
+
+// isReadableFile is true when the path is a regular file that can be opened
+func isReadableFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
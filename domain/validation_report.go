@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationProblem is a single problem found when validating
+type ValidationProblem struct {
+	// Field is the name of the setting with the problem
+	Field string
+
+	// Message describes the problem
+	Message string
+}
+
+// ValidationReport collects every problem found when validating, rather than stopping at the first
+type ValidationReport struct {
+	Problems []ValidationProblem
+}
+
+// Add adds a problem to the report. Only the first problem for each field is kept, later ones are usually a
+// consequence of it, e.g. a missing email address is also malformed
+func (report *ValidationReport) Add(field string, message string) {
+	if report.HasProblem(field) {
+		return
+	}
+	report.Problems = append(report.Problems, ValidationProblem{Field: field, Message: message})
+}
+
+// HasProblem is true when the field already has a problem
+func (report *ValidationReport) HasProblem(field string) bool {
+	for _, problem := range report.Problems {
+		if problem.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// HasProblems is true when any problem has been found
+func (report *ValidationReport) HasProblems() bool {
+	return len(report.Problems) > 0
+}
+
+// Err returns the report as an error if it has any problems, otherwise nil
+func (report *ValidationReport) Err() error {
+	if !report.HasProblems() {
+		return nil
+	}
+	return report
+}
+
+// Error lists every problem, one per line
+func (report *ValidationReport) Error() string {
+	lines := make([]string, 0, len(report.Problems)+1)
+	if len(report.Problems) == 1 {
+		lines = append(lines, "config is invalid, 1 problem found:")
+	} else {
+		lines = append(lines, fmt.Sprintf("config is invalid, %d problems found:", len(report.Problems)))
+	}
+
+	for _, problem := range report.Problems {
+		lines = append(lines, fmt.Sprintf("  - %s: %s", problem.Field, problem.Message))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// The directories of the frontend, in the embedded files and any override dir
+const (
+	viewsDir  = "views"
+	publicDir = "public"
+)
+
+// embeddedFrontend is the views and public files built into the binary, so it can be started from any directory.
+// Hidden files, such as .gitkeep, aren't embedded
+//
+//go:embed views public
+var embeddedFrontend embed.FS
+
+// overlayFS reads files from the override dir when they're there, and from the embedded files when they aren't.
+// Listing a directory lists the files in both
+type overlayFS struct {
+	override fs.FS
+
+	base fs.FS
+}
+
+// newFrontendFS returns the frontend's files. Files in the override dir, laid out like the repo with views/ and
+// public/, replace the embedded files of the same name one by one, so a page or stylesheet can be changed without
+// rebuilding. Without an override dir only the embedded files are used
+func newFrontendFS(overrideDir string) fs.FS {
+	if len(overrideDir) <= 0 {
+		return embeddedFrontend
+	}
+	return &overlayFS{override: os.DirFS(overrideDir), base: embeddedFrontend}
+}
+
+// Open opens the override file if there is one, otherwise the embedded file. Any other error opening the override
+// file, e.g. it can't be read, is returned rather than quietly serving the embedded file in its place
+func (overlay *overlayFS) Open(name string) (fs.File, error) {
+	file, err := overlay.override.Open(name)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+	return overlay.base.Open(name)
+}
+
+// ReadDir lists the files in the directory of both, the override's where both have one of the same name. As with Open,
+// only a missing override directory falls back to the embedded one
+func (overlay *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	overrideEntries, overrideErr := fs.ReadDir(overlay.override, name)
+	if overrideErr != nil && !errors.Is(overrideErr, fs.ErrNotExist) {
+		return nil, overrideErr
+	}
+	baseEntries, baseErr := fs.ReadDir(overlay.base, name)
+	if overrideErr != nil && baseErr != nil {
+		return nil, baseErr
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	for _, entry := range baseEntries {
+		entries[entry.Name()] = entry
+	}
+	for _, entry := range overrideEntries {
+		entries[entry.Name()] = entry
+	}
+
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
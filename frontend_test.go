@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFrontendOverride writes a file to the override dir
+func writeFrontendOverride(t *testing.T, overrideDir string, name string, contents string) {
+	file := filepath.Join(overrideDir, filepath.FromSlash(name))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.NoError(t, ioutil.WriteFile(file, []byte(contents), 0644))
+}
+
+func TestTheViewsAndPublicFilesAreEmbedded(t *testing.T) {
+	frontend := newFrontendFS("")
+
+	for _, name := range []string{"views/index.html", "views/500.html", "public/css/styles.css", "public/favicon.ico"} {
+		_, err := fs.Stat(frontend, name)
+		assert.NoError(t, err, "%s isn't embedded", name)
+	}
+
+	_, err := fs.Stat(frontend, "public/.gitkeep")
+	assert.True(t, os.IsNotExist(err), "hidden files shouldn't be embedded")
+}
+
+func TestFilesInTheFrontendDirOverrideTheEmbeddedOnesOneByOne(t *testing.T) {
+	overrideDir, err := ioutil.TempDir("", "frontend")
+	assert.NoError(t, err, "unable to create temp dir")
+	defer os.RemoveAll(overrideDir)
+
+	writeFrontendOverride(t, overrideDir, "public/css/styles.css", "body { color: red; }")
+	writeFrontendOverride(t, overrideDir, "public/css/extra.css", "p { margin: 0; }")
+	frontend := newFrontendFS(overrideDir)
+
+	styles, err := fs.ReadFile(frontend, "public/css/styles.css")
+	assert.NoError(t, err)
+	assert.Equal(t, "body { color: red; }", string(styles))
+
+	// Files which aren't overridden are still embedded
+	embeddedPopup, err := fs.ReadFile(embeddedFrontend, "public/css/jquery-eu-cookie-law-popup.css")
+	assert.NoError(t, err)
+	popup, err := fs.ReadFile(frontend, "public/css/jquery-eu-cookie-law-popup.css")
+	assert.NoError(t, err)
+	assert.Equal(t, embeddedPopup, popup)
+
+	// Listing a directory lists both
+	entries, err := fs.ReadDir(frontend, "public/css")
+	assert.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"extra.css", "jquery-eu-cookie-law-popup.css", "jquery.modal.min.css", "styles.css"}, names)
+}
+
+func TestAnOverrideEditedAfterStartUpIsServedAsItWasHashed(t *testing.T) {
+	overrideDir, err := ioutil.TempDir("", "frontend")
+	assert.NoError(t, err, "unable to create temp dir")
+	defer os.RemoveAll(overrideDir)
+
+	writeFrontendOverride(t, overrideDir, "public/css/styles.css", "body { color: red; }")
+	manifest, err := newAssetManifest(newFrontendFS(overrideDir), false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	writeFrontendOverride(t, overrideDir, "public/css/styles.css", "body { color: blue; }")
+
+	served := servePublicFile(manifest, manifest.byPath["/css/styles.css"].fingerprintedPath, "")
+	assert.Equal(t, http.StatusOK, served.Code)
+	assert.Equal(t, "body { color: red; }", served.Body.String())
+}
+
+// unreadableFS fails to open every file as though it isn't allowed to read it
+type unreadableFS struct{}
+
+func (unreadableFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func TestAnOverrideWhichCantBeReadIsNotReplacedByTheEmbeddedFile(t *testing.T) {
+	frontend := &overlayFS{override: unreadableFS{}, base: embeddedFrontend}
+
+	_, err := fs.ReadFile(frontend, "public/css/styles.css")
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, fs.ErrPermission), err.Error())
+	}
+
+	_, err = fs.ReadDir(frontend, "public/css")
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, fs.ErrPermission), err.Error())
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// livenessPath is polled to check the process is alive
+	livenessPath = "/healthz"
+
+	// readinessPath is polled to check the instance can serve traffic
+	readinessPath = "/readyz"
+
+	// readinessCheckTimeout is how long each readiness check is given
+	readinessCheckTimeout = 5 * time.Second
+
+	// dependencyCheckInterval is how long the results of checking the third party services are reused, so polling
+	// readiness doesn't call them for every poll
+	dependencyCheckInterval = time.Minute
+
+	healthStatusOK           = "ok"
+	healthStatusFail         = "fail"
+	healthStatusShuttingDown = "shutting down"
+
+	serviceNotConfiguredError = "not configured"
+)
+
+// requiredTemplates are the templates the site can't serve pages without
+var requiredTemplates = []string{
+	"index.html",
+	"privacy.html",
+	"cookies.html",
+	"contact.html",
+	"thanks.html",
+	"404.html",
+	"413.html",
+	"500.html",
+	"admin-reports.html",
+}
+
+// healthCheck checks one thing the instance needs to serve traffic
+type healthCheck struct {
+	Name string
+
+	// Check returns an error if the check fails, it gives up when the context is done
+	Check func(ctx context.Context) error
+}
+
+// healthCheckResult is the outcome of a single health check
+type healthCheckResult struct {
+	Status string `json:"status"`
+
+	// LatencyMs is how long the check took in milliseconds
+	LatencyMs float64 `json:"latencyMs"`
+
+	// Error is why the check failed. It is logged rather than returned, as errors from the third party services can
+	// include their endpoints, request IDs and how credentials were found
+	Error string `json:"-"`
+}
+
+// healthResponse is the body returned by the health endpoints
+type healthResponse struct {
+	Status string `json:"status"`
+
+	// Checks are the results of each check, keyed by name
+	Checks map[string]*healthCheckResult `json:"checks,omitempty"`
+
+	// Dependencies are the latest results of checking the third party services, keyed by name. They're reported but
+	// don't affect the status
+	Dependencies map[string]*healthCheckResult `json:"dependencies,omitempty"`
+}
+
+// cachedHealthChecks runs checks at most once an interval, reusing their results in between. The checks run detached
+// from the request triggering them, so a client giving up doesn't fail them, and without holding the lock, so other
+// callers get the previous results rather than waiting for the checks
+type cachedHealthChecks struct {
+	logger services.Logger
+
+	checks []healthCheck
+
+	interval time.Duration
+
+	// mutex guards the results and the checks in progress, it isn't held while the checks run
+	mutex sync.Mutex
+
+	results map[string]*healthCheckResult
+
+	// checkedAt is when the results were got, zero until the checks first run
+	checkedAt time.Time
+
+	// refreshed is closed once the checks in progress finish, it is nil when they aren't running
+	refreshed chan struct{}
+}
+
+// newCachedHealthChecks creates the checks run at most once an interval
+func newCachedHealthChecks(logger services.Logger, checks []healthCheck, interval time.Duration) *cachedHealthChecks {
+	return &cachedHealthChecks{
+		logger:   logger,
+		checks:   checks,
+		interval: interval,
+	}
+}
+
+// Results returns the results of the checks, running them if they haven't been run within the interval. The caller
+// starting the checks, and callers when there are no results yet, wait for them until their context is done; other
+// callers get the previous results while the checks run. It is nil when there are no results to return
+func (cached *cachedHealthChecks) Results(ctx context.Context) map[string]*healthCheckResult {
+	cached.mutex.Lock()
+	refreshed := cached.refreshed
+	isStarting := refreshed == nil && (cached.results == nil || time.Since(cached.checkedAt) >= cached.interval)
+	if isStarting {
+		refreshed = make(chan struct{})
+		cached.refreshed = refreshed
+		go cached.refresh(refreshed)
+	}
+	results := cached.results
+	cached.mutex.Unlock()
+
+	if results != nil && !isStarting {
+		return results
+	}
+
+	select {
+	case <-refreshed:
+	case <-ctx.Done():
+		return results
+	}
+
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	return cached.results
+}
+
+// refresh runs the checks, each with its own timeout, then closes refreshed
+func (cached *cachedHealthChecks) refresh(refreshed chan struct{}) {
+	results := runHealthChecks(context.Background(), cached.logger, cached.checks)
+
+	cached.mutex.Lock()
+	cached.results = results
+	cached.checkedAt = time.Now()
+	cached.refreshed = nil
+	cached.mutex.Unlock()
+
+	close(refreshed)
+}
+
+// newLivenessHandler creates the handler reporting the process is alive, it is always ok while the server is serving
+func newLivenessHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, &healthResponse{Status: healthStatusOK})
+	}
+}
+
+// newReadinessHandler creates the handler reporting whether the instance can serve traffic. It responds 503 when any
+// check fails or the instance is shutting down, so load balancers stop sending it requests. The checks are only of the
+// instance's own state, a third party service being unreachable doesn't make every instance unready so the
+// dependencies are reported alongside them without affecting the status. Only the status and latency of each check are
+// returned, why a check failed is logged
+func newReadinessHandler(ctx *AppContext, checks []healthCheck, dependencies *cachedHealthChecks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !ctx.IsReady() {
+			return c.JSON(http.StatusServiceUnavailable, &healthResponse{Status: healthStatusShuttingDown})
+		}
+
+		response := &healthResponse{
+			Status:       healthStatusOK,
+			Checks:       runHealthChecks(c.Request().Context(), ctx.Logger, checks),
+			Dependencies: dependencies.Results(c.Request().Context()),
+		}
+
+		code := http.StatusOK
+		for _, result := range response.Checks {
+			if result.Status != healthStatusOK {
+				response.Status = healthStatusFail
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		return c.JSON(code, response)
+	}
+}
+
+// runHealthChecks runs the checks concurrently, each with its own timeout, logging why any failed
+func runHealthChecks(ctx context.Context, logger services.Logger, checks []healthCheck) map[string]*healthCheckResult {
+	results := make(map[string]*healthCheckResult)
+	resultsMutex := sync.Mutex{}
+
+	wg := sync.WaitGroup{}
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check healthCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(checkCtx)
+			result := &healthCheckResult{
+				Status:    healthStatusOK,
+				LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			if err != nil {
+				result.Status = healthStatusFail
+				result.Error = err.Error()
+				logger.WithContext(ctx).Warn("Health check failed", services.Fields{"check": check.Name, "error": result.Error})
+			}
+
+			resultsMutex.Lock()
+			defer resultsMutex.Unlock()
+			results[check.Name] = result
+		}(check)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// newReadinessChecks creates the checks the instance must pass to be ready: the templates parsed and the certificate
+// cache is writable when certificates are got automatically. The site has no other data store of its own
+func newReadinessChecks(ctx *AppContext, templates *template.Template) []healthCheck {
+	checks := []healthCheck{
+		{
+			Name: "templates",
+			Check: func(_ context.Context) error {
+				return checkTemplates(templates)
+			},
+		},
+	}
+
+	tlsConfig := ctx.CurrentConfig().TLS
+	if tlsConfig.UsesAcme() {
+		checks = append(checks, healthCheck{
+			Name: "certificateCache",
+			Check: func(_ context.Context) error {
+				return checkWritableDir(tlsConfig.AcmeCacheDir)
+			},
+		})
+	}
+
+	return checks
+}
+
+// newDependencyChecks creates the checks of the third party services: the email and captcha services are configured
+// and reachable
+func newDependencyChecks(ctx *AppContext) []healthCheck {
+	return []healthCheck{
+		{
+			Name: "email",
+			Check: func(checkCtx context.Context) error {
+				contactFormService, _ := ctx.CurrentServices()
+				return checkServiceHealth(checkCtx, contactFormService)
+			},
+		},
+		{
+			Name: "captcha",
+			Check: func(checkCtx context.Context) error {
+				_, recaptchaService := ctx.CurrentServices()
+				return checkServiceHealth(checkCtx, recaptchaService)
+			},
+		},
+	}
+}
+
+// checkTemplates checks every required template was parsed
+func checkTemplates(templates *template.Template) error {
+	if templates == nil {
+		return errors.New("templates were not parsed")
+	}
+
+	for _, name := range requiredTemplates {
+		if templates.Lookup(name) == nil {
+			return errors.Errorf("template '%s' is missing", name)
+		}
+	}
+
+	return nil
+}
+
+// checkServiceHealth checks the service is configured and, if it can, that what it depends on is reachable
+func checkServiceHealth(ctx context.Context, service interface{}) error {
+	if service == nil {
+		return errors.New(serviceNotConfiguredError)
+	}
+
+	if healthChecker, ok := service.(services.HealthChecker); ok {
+		return healthChecker.CheckHealth(ctx)
+	}
+
+	return nil
+}
+
+// checkWritableDir checks a file can be written to the directory, creating it if it doesn't exist
+func checkWritableDir(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(dir, ".readyz")
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDependencyChecksAreOnlyRunOnceAnInterval(t *testing.T) {
+	calls := 0
+	dependencies := newCachedHealthChecks(newLogger(), []healthCheck{
+		{
+			Name: "email",
+			Check: func(_ context.Context) error {
+				calls++
+				return nil
+			},
+		},
+	}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		results := dependencies.Results(context.Background())
+		assert.Equal(t, healthStatusOK, results["email"].Status)
+	}
+	assert.Equal(t, 1, calls)
+
+	// Once the interval has passed they're checked again
+	dependencies.checkedAt = time.Now().Add(-time.Hour)
+	dependencies.Results(context.Background())
+	assert.Equal(t, 2, calls)
+}
+
+func TestDependencyChecksAreNotFailedByTheRequestGivingUp(t *testing.T) {
+	release := make(chan struct{})
+	dependencies := newCachedHealthChecks(newLogger(), []healthCheck{
+		{
+			Name: "email",
+			Check: func(checkCtx context.Context) error {
+				select {
+				case <-release:
+					return nil
+				case <-checkCtx.Done():
+					return checkCtx.Err()
+				}
+			},
+		},
+	}, time.Hour)
+
+	// The request gives up before the checks finish, without waiting for them
+	requestCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(t, dependencies.Results(requestCtx))
+
+	// The checks carry on, so the next caller gets their result rather than the request's cancellation
+	close(release)
+	results := dependencies.Results(context.Background())
+	if assert.Contains(t, results, "email") {
+		assert.Equal(t, healthStatusOK, results["email"].Status)
+	}
+}
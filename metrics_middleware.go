@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"time"
+)
+
+const (
+	// metricsPath serves the metrics to Prometheus
+	metricsPath = "/metrics"
+
+	// unmatchedRoute labels requests which didn't match a route, so unknown paths don't each get their own series
+	unmatchedRoute = "unmatched"
+)
+
+// newMetricsMiddleware creates the middleware recording the method, route, status and duration of every request. It
+// comes before the error handling middleware, so the status of the error page is the one recorded
+func newMetricsMiddleware(metrics services.Metrics) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			route := c.Path()
+			if len(route) <= 0 {
+				route = unmatchedRoute
+			}
+
+			metrics.ObserveRequest(c.Request().Method, route, c.Response().Status, time.Since(start))
+			return err
+		}
+	}
+}
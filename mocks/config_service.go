@@ -24,3 +24,26 @@ func (_m *ConfigService) LoadConfig() *domain.AppConfig {
 
 	return r0
 }
+
+// TryLoadConfig provides a mock function with given fields:
+func (_m *ConfigService) TryLoadConfig() (*domain.AppConfig, error) {
+	ret := _m.Called()
+
+	var r0 *domain.AppConfig
+	if rf, ok := ret.Get(0).(func() *domain.AppConfig); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*domain.AppConfig)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
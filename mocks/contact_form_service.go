@@ -1,6 +1,7 @@
 // Code generated by mockery v1.0.0. DO NOT EDIT.
 package mocks
 
+import context "context"
 import domain "github.com/adbourne/website-seacitysoftware/domain"
 import mock "github.com/stretchr/testify/mock"
 
@@ -9,13 +10,13 @@ type ContactFormService struct {
 	mock.Mock
 }
 
-// Process provides a mock function with given fields: contactForm
-func (_m *ContactFormService) Process(contactForm *domain.ContactForm) error {
-	ret := _m.Called(contactForm)
+// Process provides a mock function with given fields: ctx, contactForm
+func (_m *ContactFormService) Process(ctx context.Context, contactForm *domain.ContactForm) error {
+	ret := _m.Called(ctx, contactForm)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*domain.ContactForm) error); ok {
-		r0 = rf(contactForm)
+	if rf, ok := ret.Get(0).(func(context.Context, *domain.ContactForm) error); ok {
+		r0 = rf(ctx, contactForm)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -1,6 +1,7 @@
 // Code generated by mockery v1.0.0. DO NOT EDIT.
 package mocks
 
+import context "context"
 import mock "github.com/stretchr/testify/mock"
 import services "github.com/adbourne/website-seacitysoftware/services"
 
@@ -28,3 +29,35 @@ func (_m *Logger) Info(message string, fields services.Fields) {
 func (_m *Logger) Warn(message string, fields services.Fields) {
 	_m.Called(message, fields)
 }
+
+// With provides a mock function with given fields: fields
+func (_m *Logger) With(fields services.Fields) services.Logger {
+	ret := _m.Called(fields)
+
+	var r0 services.Logger
+	if rf, ok := ret.Get(0).(func(services.Fields) services.Logger); ok {
+		r0 = rf(fields)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(services.Logger)
+		}
+	}
+
+	return r0
+}
+
+// WithContext provides a mock function with given fields: ctx
+func (_m *Logger) WithContext(ctx context.Context) services.Logger {
+	ret := _m.Called(ctx)
+
+	var r0 services.Logger
+	if rf, ok := ret.Get(0).(func(context.Context) services.Logger); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(services.Logger)
+		}
+	}
+
+	return r0
+}
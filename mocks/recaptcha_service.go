@@ -1,6 +1,7 @@
 // Code generated by mockery v1.0.0. DO NOT EDIT.
 package mocks
 
+import context "context"
 import mock "github.com/stretchr/testify/mock"
 
 // RecaptchaService is an autogenerated mock type for the RecaptchaService type
@@ -8,13 +9,13 @@ type RecaptchaService struct {
 	mock.Mock
 }
 
-// Verify provides a mock function with given fields: response
-func (_m *RecaptchaService) Verify(response string) error {
-	ret := _m.Called(response)
+// Verify provides a mock function with given fields: ctx, response
+func (_m *RecaptchaService) Verify(ctx context.Context, response string) error {
+	ret := _m.Called(ctx, response)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(response)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, response)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/labstack/echo"
+	"net/http"
+	"time"
+)
+
+// pageView is the view model shared by every template
+type pageView struct {
+	// Site is the site settings
+	Site *domain.SiteConfig
+
+	// Year is the current year, shown in the copyright notice
+	Year int
+
+	// Path is the path of the page, joined with the site's base URL for the canonical link
+	Path string
+
+	// Tagline is the page's heading
+	Tagline string
+
+	// TaglineSummary is shown under the page's heading
+	TaglineSummary string
+
+	// RequestID identifies the request, error pages show it so visitors can quote it
+	RequestID string
+
+	// IncidentID identifies an unexpected failure, the 500 page shows it so visitors can quote it
+	IncidentID string
+
+	// CSPNonce is the nonce every inline script must be given to be allowed by the Content-Security-Policy
+	CSPNonce string
+
+	// Page holds the parameters specific to the page
+	Page map[string]interface{}
+}
+
+// newPageView creates the view model for the page being rendered for the request
+func newPageView(ctx *AppContext, c echo.Context, tagline string, taglineSummary string, page map[string]interface{}) *pageView {
+	site := &domain.SiteConfig{}
+	if appConfig := ctx.CurrentConfig(); appConfig != nil && appConfig.Site != nil {
+		site = appConfig.Site
+	}
+
+	if page == nil {
+		page = make(map[string]interface{})
+	}
+
+	return &pageView{
+		Site:           site,
+		Year:           time.Now().Year(),
+		Path:           c.Request().URL.Path,
+		Tagline:        tagline,
+		TaglineSummary: taglineSummary,
+		RequestID:      requestID(c),
+		CSPNonce:       cspNonce(c),
+		Page:           page,
+	}
+}
+
+// newStaticPageHandler creates a handler rendering a page which only needs the shared view model
+func newStaticPageHandler(ctx *AppContext, templateName string, tagline string, taglineSummary string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Render(http.StatusOK, templateName, newPageView(ctx, c, tagline, taglineSummary, nil))
+	}
+}
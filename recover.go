@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Sources of the errors reported from outside of a request, tagged on the report
+const (
+	errorSourceBackground   = "background_worker"
+	errorSourceConfigReload = "config_reload"
+)
+
+// panicError is the error a recovered panic is handled as, carrying the incident ID shown on the error page
+type panicError struct {
+	// incidentID is the reference visitors can quote for the panic
+	incidentID string
+
+	// value is the value the handler panicked with
+	value interface{}
+}
+
+func (err *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.value)
+}
+
+// newIncidentID creates a short reference for an incident, e.g. 3F2A9C1B, which is easy for visitors to read out
+func newIncidentID() (string, error) {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(fmt.Sprintf("%x", b)), nil
+}
+
+// newRecoverMiddleware creates the middleware recovering from panics in the handlers and templates. The panic is logged
+// with its stack, reported to the error tracker and handled as a server error, so the visitor sees the 500 page with an
+// incident ID rather than an empty response. The error reporter is looked up for each panic, so reloading the config
+// changes it
+func newRecoverMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	logger := appCtx.Logger
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				value := recover()
+				if value == nil {
+					return
+				}
+
+				// The server aborts the response without logging it when it is panicked with ErrAbortHandler
+				if value == http.ErrAbortHandler {
+					panic(value)
+				}
+
+				stack := debug.Stack()
+				incidentID, idErr := newIncidentID()
+				if idErr != nil {
+					incidentID = requestID(c)
+				}
+
+				recovered := &panicError{
+					incidentID: incidentID,
+					value:      value,
+				}
+
+				request := c.Request()
+				ctx := request.Context()
+				logger.WithContext(ctx).Error("Recovered from a panic", services.Fields{
+					"error":      recovered.Error(),
+					"incidentId": incidentID,
+					"stack":      string(stack),
+				})
+				appCtx.CurrentErrorReporter().Report(ctx, &services.ErrorEvent{
+					IncidentID: incidentID,
+					Err:        recovered,
+					Stack:      stack,
+					Panic:      true,
+					Request:    request,
+				})
+
+				err = recovered
+			}()
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/adbourne/website-seacitysoftware/mocks"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// serveWithRecovery serves the request with the recover middleware and error pages, from a handler which fails
+func serveWithRecovery(request *http.Request, logger services.Logger, errorReporter services.ErrorReporter, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	e := echo.New()
+	assets, err := newAssetManifest(embeddedFrontend, false)
+	if err != nil {
+		panic(err)
+	}
+	e.Renderer = &EchoTemplate{
+		templates: template.Must(parseTemplates(embeddedFrontend, assets)),
+	}
+	appCtx := &AppContext{Logger: logger, ErrorReporter: errorReporter}
+	e.HTTPErrorHandler = (&CustomEchoErrorHandler{Logger: logger, AppContext: appCtx}).handle
+	e.Use(newRequestIDMiddleware())
+	e.Use(newErrorHandlingMiddleware())
+	e.Use(newRecoverMiddleware(appCtx))
+	e.GET("/", handler)
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAPanicRendersTheErrorPageWithAnIncidentID(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("WithContext", mock.Anything).Return(logger)
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+
+	errorReporter := &mocks.ErrorReporter{}
+	errorReporter.On("Report", mock.Anything, mock.Anything).Return()
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(echo.HeaderXRequestID, "request-1")
+	recorder := serveWithRecovery(request, logger, errorReporter, func(c echo.Context) error {
+		panic("template exploded")
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	incident := regexp.MustCompile(`Incident: ([0-9A-F]{8})`).FindStringSubmatch(recorder.Body.String())
+	if !assert.Len(t, incident, 2, "the error page should show the incident ID") {
+		return
+	}
+	assert.Contains(t, recorder.Body.String(), "Reference: request-1")
+
+	// The panic is logged with its stack and the incident ID
+	logger.AssertCalled(t, "Error", "Recovered from a panic", mock.MatchedBy(func(fields services.Fields) bool {
+		stack, _ := fields["stack"].(string)
+		return fields["error"] == "panic: template exploded" && fields["incidentId"] == incident[1] &&
+			regexp.MustCompile(`recover_test\.go`).MatchString(stack)
+	}))
+
+	// And reported with the request's context
+	if !assert.Len(t, errorReporter.Calls, 1) {
+		return
+	}
+	assert.Equal(t, "request-1", services.RequestIDFromContext(errorReporter.Calls[0].Arguments.Get(0).(context.Context)))
+	event := errorReporter.Calls[0].Arguments.Get(1).(*services.ErrorEvent)
+	assert.Equal(t, incident[1], event.IncidentID)
+	assert.True(t, event.Panic)
+	assert.NotEmpty(t, event.Stack)
+}
+
+func TestAPanicAfterTheResponseHasStartedIsOnlyLogged(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("WithContext", mock.Anything).Return(logger)
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+
+	errorReporter := &mocks.ErrorReporter{}
+	errorReporter.On("Report", mock.Anything, mock.Anything).Return()
+
+	recorder := serveWithRecovery(httptest.NewRequest("GET", "/", nil), logger, errorReporter, func(c echo.Context) error {
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Write([]byte("<html>"))
+		panic("template exploded")
+	})
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "<html>", recorder.Body.String())
+	logger.AssertCalled(t, "Error", "Recovered from a panic", mock.Anything)
+	errorReporter.AssertNumberOfCalls(t, "Report", 1)
+}
+
+func TestAServerErrorIsReportedWithAnIncidentID(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("WithContext", mock.Anything).Return(logger)
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+
+	errorReporter := &mocks.ErrorReporter{}
+	errorReporter.On("Report", mock.Anything, mock.Anything).Return()
+
+	recorder := serveWithRecovery(httptest.NewRequest("GET", "/", nil), logger, errorReporter, func(c echo.Context) error {
+		return errors.New("unable to load the page")
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	incident := regexp.MustCompile(`Incident: ([0-9A-F]{8})`).FindStringSubmatch(recorder.Body.String())
+	if !assert.Len(t, incident, 2, "the error page should show the incident ID") {
+		return
+	}
+
+	if !assert.Len(t, errorReporter.Calls, 1) {
+		return
+	}
+	event := errorReporter.Calls[0].Arguments.Get(1).(*services.ErrorEvent)
+	assert.Equal(t, incident[1], event.IncidentID)
+	assert.EqualError(t, event.Err, "unable to load the page")
+	assert.False(t, event.Panic)
+	assert.NotNil(t, event.Request)
+}
+
+func TestClientErrorsAreNotReported(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("WithContext", mock.Anything).Return(logger)
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+
+	errorReporter := &mocks.ErrorReporter{}
+
+	recorder := serveWithRecovery(httptest.NewRequest("GET", "/", nil), logger, errorReporter, func(c echo.Context) error {
+		return echo.ErrNotFound
+	})
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	errorReporter.AssertNotCalled(t, "Report", mock.Anything, mock.Anything)
+}
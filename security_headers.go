@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+)
+
+// isHTTPS is true when the request was made over HTTPS, either to the server or to a load balancer which terminated
+// TLS for it
+func isHTTPS(request *http.Request) bool {
+	return request.TLS != nil || request.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// newSecurityHeadersMiddleware creates the middleware sending the security headers, with any overrides for the
+// request's path applied. Strict-Transport-Security is only sent over HTTPS, when the TLS config asks for it. The
+// headers are read from the current config, so they can be changed by reloading the config
+func newSecurityHeadersMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			appConfig := appCtx.CurrentConfig()
+			securityHeadersConfig := appConfig.SecurityHeaders
+			if securityHeadersConfig == nil {
+				securityHeadersConfig = &domain.SecurityHeadersConfig{}
+			}
+
+			strictTransportSecurity := ""
+			if isHTTPS(c.Request()) {
+				strictTransportSecurity = appConfig.TLS.StrictTransportSecurity()
+			}
+
+			header := c.Response().Header()
+			for _, securityHeader := range securityHeadersConfig.HeadersFor(c.Request().URL.Path, strictTransportSecurity) {
+				header.Set(securityHeader.Name, securityHeader.Value)
+			}
+			return next(c)
+		}
+	}
+}
+
+// logEffectiveSecurityHeaders is a self-test run on start up. A request for every route, as if it were made over HTTPS,
+// is sent through the middleware setting the security headers, and the headers it is sent are logged so a mistake in
+// the policy is seen before any visitor meets it. A route not sent HSTS when the config asks for it is logged as an
+// error, and an override whose path prefix matches no route as a warning, as it is most likely a typo
+func logEffectiveSecurityHeaders(logger services.Logger, appConfig *domain.AppConfig, routes []*echo.Route, middleware ...echo.MiddlewareFunc) {
+	selfTest := echo.New()
+	selfTest.Use(middleware...)
+	selfTest.Any("/*", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	paths := make([]string, 0, len(routes))
+	isSeen := make(map[string]bool)
+	for _, route := range routes {
+		if !isSeen[route.Path] {
+			paths = append(paths, route.Path)
+			isSeen[route.Path] = true
+		}
+	}
+	sort.Strings(paths)
+
+	securityHeadersConfig := appConfig.SecurityHeaders
+	if securityHeadersConfig == nil {
+		securityHeadersConfig = &domain.SecurityHeadersConfig{}
+	}
+	for _, path := range paths {
+		requestPath := strings.Replace(path, "*", "", -1)
+		request := httptest.NewRequest("GET", requestPath, nil)
+		request.Header.Set("X-Forwarded-Proto", "https")
+		recorder := httptest.NewRecorder()
+		selfTest.ServeHTTP(recorder, request)
+
+		headers := make(map[string]string)
+		for _, name := range append([]string{domain.StrictTransportSecurityHeader}, domain.SecurityHeaderNames...) {
+			if value := recorder.Header().Get(name); len(value) > 0 {
+				headers[name] = value
+			}
+		}
+		logger.Info("Effective security headers", services.Fields{"route": path, "headers": headers})
+
+		if len(headers[domain.StrictTransportSecurityHeader]) <= 0 && isHSTSExpected(securityHeadersConfig, requestPath, appConfig.TLS) {
+			logger.Error("HSTS isn't sent over HTTPS though the config asks for it", services.Fields{"route": path})
+		}
+	}
+
+	for _, override := range securityHeadersConfig.Overrides {
+		if !isAnyRouteMatched(override.PathPrefix, paths) {
+			logger.Warn("Security header override matches no route", services.Fields{"override": override.String()})
+		}
+	}
+}
+
+// isHSTSExpected is true when the config asks for HSTS to be sent with a request for the path over HTTPS, i.e. the
+// TLS config has a max age and no override stops it being sent
+func isHSTSExpected(securityHeadersConfig *domain.SecurityHeadersConfig, path string, tlsConfig *domain.TLSConfig) bool {
+	for _, securityHeader := range securityHeadersConfig.HeadersFor(path, tlsConfig.StrictTransportSecurity()) {
+		if securityHeader.Name == domain.StrictTransportSecurityHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// isAnyRouteMatched is true when a path prefix matches any of the route paths, either because a route is under the
+// prefix or because the prefix is under a route with a wildcard or parameter. The public files are served by a
+// wildcard at the root, which would match every prefix, so it isn't counted
+func isAnyRouteMatched(pathPrefix string, paths []string) bool {
+	for _, path := range paths {
+		if strings.HasPrefix(path, pathPrefix) {
+			return true
+		}
+
+		wildcard := strings.IndexAny(path, "*:")
+		if wildcard > 1 && strings.HasPrefix(pathPrefix, path[:wildcard]) {
+			return true
+		}
+	}
+	return false
+}
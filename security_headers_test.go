@@ -0,0 +1,173 @@
+package main
+
+import (
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/mocks"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testSecurityHeadersConfig sends every header, with the admin area and reports endpoint overriding some of them
+var testSecurityHeadersConfig = &domain.SecurityHeadersConfig{
+	FrameOptions:              "DENY",
+	ContentTypeOptions:        "nosniff",
+	ReferrerPolicy:            "strict-origin-when-cross-origin",
+	PermissionsPolicy:         "camera=(), microphone=()",
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginResourcePolicy: "same-origin",
+	Overrides: []domain.SecurityHeadersOverride{
+		{PathPrefix: "/admin/reports", Header: domain.ReferrerPolicyHeader, Value: "same-origin"},
+		{PathPrefix: "/admin", Header: domain.ReferrerPolicyHeader, Value: "no-referrer"},
+		{PathPrefix: "/reports", Header: domain.CrossOriginResourcePolicyHeader, Value: ""},
+	},
+}
+
+// serveWithSecurityHeaders serves a request for the path with the security header middleware
+func serveWithSecurityHeaders(appConfig *domain.AppConfig, path string, forwardedProto string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.Use(newSecurityHeadersMiddleware(&AppContext{Config: appConfig}))
+	e.Any("/*", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	request := httptest.NewRequest("GET", path, nil)
+	if len(forwardedProto) > 0 {
+		request.Header.Set("X-Forwarded-Proto", forwardedProto)
+	}
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestTheSecurityHeadersAreSentWithEveryResponse(t *testing.T) {
+	appConfig := &domain.AppConfig{TLS: &domain.TLSConfig{}, SecurityHeaders: testSecurityHeadersConfig}
+	header := serveWithSecurityHeaders(appConfig, "/", "").Header()
+
+	assert.Equal(t, "DENY", header.Get(domain.FrameOptionsHeader))
+	assert.Equal(t, "nosniff", header.Get(domain.ContentTypeOptionsHeader))
+	assert.Equal(t, "strict-origin-when-cross-origin", header.Get(domain.ReferrerPolicyHeader))
+	assert.Equal(t, "camera=(), microphone=()", header.Get(domain.PermissionsPolicyHeader))
+	assert.Equal(t, "same-origin", header.Get(domain.CrossOriginOpenerPolicyHeader))
+	assert.Equal(t, "same-origin", header.Get(domain.CrossOriginResourcePolicyHeader))
+
+	// Headers without a value aren't sent
+	assert.NotContains(t, header, domain.XSSProtectionHeader)
+}
+
+func TestTheLongestMatchingOverrideIsUsed(t *testing.T) {
+	appConfig := &domain.AppConfig{TLS: &domain.TLSConfig{}, SecurityHeaders: testSecurityHeadersConfig}
+
+	assert.Equal(t, "no-referrer", serveWithSecurityHeaders(appConfig, "/admin", "").Header().Get(domain.ReferrerPolicyHeader))
+	assert.Equal(t, "same-origin", serveWithSecurityHeaders(appConfig, "/admin/reports", "").Header().Get(domain.ReferrerPolicyHeader))
+
+	// An override without a value stops the header being sent
+	header := serveWithSecurityHeaders(appConfig, "/reports", "").Header()
+	assert.NotContains(t, header, domain.CrossOriginResourcePolicyHeader)
+	assert.Equal(t, "DENY", header.Get(domain.FrameOptionsHeader))
+}
+
+func TestHSTSIsOnlySentOverHTTPS(t *testing.T) {
+	appConfig := &domain.AppConfig{
+		TLS: &domain.TLSConfig{
+			HstsMaxAge:            365 * 24 * time.Hour,
+			HstsIncludeSubdomains: true,
+			HstsPreload:           true,
+		},
+	}
+
+	assert.Equal(t, "max-age=31536000; includeSubDomains; preload", serveWithSecurityHeaders(appConfig, "/", "https").Header().Get(domain.StrictTransportSecurityHeader))
+	assert.Empty(t, serveWithSecurityHeaders(appConfig, "/", "").Header().Get(domain.StrictTransportSecurityHeader))
+}
+
+func TestHSTSCanBeOverriddenForAPath(t *testing.T) {
+	appConfig := &domain.AppConfig{
+		TLS: &domain.TLSConfig{HstsMaxAge: time.Hour},
+		SecurityHeaders: &domain.SecurityHeadersConfig{
+			Overrides: []domain.SecurityHeadersOverride{
+				{PathPrefix: "/.well-known/", Header: domain.StrictTransportSecurityHeader, Value: ""},
+				{PathPrefix: "/admin", Header: domain.StrictTransportSecurityHeader, Value: "max-age=86400"},
+			},
+		},
+	}
+
+	assert.Equal(t, "max-age=3600", serveWithSecurityHeaders(appConfig, "/", "https").Header().Get(domain.StrictTransportSecurityHeader))
+	assert.NotContains(t, serveWithSecurityHeaders(appConfig, "/.well-known/security.txt", "https").Header(), domain.StrictTransportSecurityHeader)
+	assert.Equal(t, "max-age=86400", serveWithSecurityHeaders(appConfig, "/admin", "https").Header().Get(domain.StrictTransportSecurityHeader))
+
+	// An override can't send it over HTTP
+	assert.NotContains(t, serveWithSecurityHeaders(appConfig, "/admin", "").Header(), domain.StrictTransportSecurityHeader)
+}
+
+func TestHSTSIsNotSentWithoutATLSConfig(t *testing.T) {
+	appConfig := &domain.AppConfig{SecurityHeaders: testSecurityHeadersConfig}
+
+	header := serveWithSecurityHeaders(appConfig, "/", "https").Header()
+	assert.Empty(t, header.Get(domain.StrictTransportSecurityHeader))
+	assert.Equal(t, "DENY", header.Get(domain.FrameOptionsHeader))
+	assert.False(t, appConfig.TLS.Enabled())
+}
+
+func TestTheEffectiveSecurityHeadersOfEveryRouteAreLogged(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("Info", mock.Anything, mock.Anything).Return()
+
+	appConfig := &domain.AppConfig{TLS: &domain.TLSConfig{HstsMaxAge: time.Hour}, SecurityHeaders: testSecurityHeadersConfig}
+	routes := []*echo.Route{{Method: "GET", Path: "/"}, {Method: "POST", Path: "/reports"}, {Method: "GET", Path: "/admin/reports"}}
+	logEffectiveSecurityHeaders(logger, appConfig, routes, newSecurityHeadersMiddleware(&AppContext{Config: appConfig}))
+
+	logger.AssertNumberOfCalls(t, "Info", 3)
+	logger.AssertCalled(t, "Info", "Effective security headers", mock.MatchedBy(func(fields services.Fields) bool {
+		headers, _ := fields["headers"].(map[string]string)
+		return fields["route"] == "/admin/reports" && headers[domain.ReferrerPolicyHeader] == "same-origin" &&
+			headers[domain.StrictTransportSecurityHeader] == "max-age=3600"
+	}))
+	logger.AssertNotCalled(t, "Error", mock.Anything, mock.Anything)
+	logger.AssertNotCalled(t, "Warn", mock.Anything, mock.Anything)
+}
+
+func TestRoutesNotSentHSTSWhenTheTLSConfigAsksForItAreLoggedAsErrors(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("Info", mock.Anything, mock.Anything).Return()
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+
+	// The security headers middleware being left out is caught by the self-test, but an override removing HSTS isn't
+	// an error
+	appConfig := &domain.AppConfig{
+		TLS: &domain.TLSConfig{HstsMaxAge: time.Hour},
+		SecurityHeaders: &domain.SecurityHeadersConfig{
+			FrameOptions: "DENY",
+			Overrides:    []domain.SecurityHeadersOverride{{PathPrefix: "/.well-known/", Header: domain.StrictTransportSecurityHeader}},
+		},
+	}
+	logEffectiveSecurityHeaders(logger, appConfig, []*echo.Route{{Method: "GET", Path: "/"}, {Method: "GET", Path: "/.well-known/*"}})
+
+	logger.AssertNumberOfCalls(t, "Error", 1)
+	logger.AssertCalled(t, "Error", "HSTS isn't sent over HTTPS though the config asks for it", services.Fields{"route": "/"})
+}
+
+func TestOverridesWhichMatchNoRouteAreLoggedAsWarnings(t *testing.T) {
+	logger := &mocks.Logger{}
+	logger.On("Info", mock.Anything, mock.Anything).Return()
+	logger.On("Warn", mock.Anything, mock.Anything).Return()
+
+	securityHeadersConfig := &domain.SecurityHeadersConfig{
+		FrameOptions: "DENY",
+		Overrides: []domain.SecurityHeadersOverride{
+			{PathPrefix: "/admn", Header: domain.ReferrerPolicyHeader, Value: "no-referrer"},
+			{PathPrefix: "/admin", Header: domain.ReferrerPolicyHeader, Value: "no-referrer"},
+			{PathPrefix: "/public/css", Header: domain.CrossOriginResourcePolicyHeader, Value: "cross-origin"},
+		},
+	}
+	appConfig := &domain.AppConfig{SecurityHeaders: securityHeadersConfig}
+	routes := []*echo.Route{{Method: "GET", Path: "/*"}, {Method: "GET", Path: "/admin/reports"}, {Method: "GET", Path: "/public/*"}}
+	logEffectiveSecurityHeaders(logger, appConfig, routes, newSecurityHeadersMiddleware(&AppContext{Config: appConfig}))
+
+	logger.AssertNumberOfCalls(t, "Warn", 1)
+	logger.AssertCalled(t, "Warn", "Security header override matches no route", services.Fields{"override": "/admn Referrer-Policy=no-referrer"})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/adbourne/website-seacitysoftware/domain"
 	"github.com/adbourne/website-seacitysoftware/services"
@@ -8,58 +9,108 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ses"
-	"github.com/sirupsen/logrus"
-	"net/http"
-	"path/filepath"
-	"time"
 	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/sirupsen/logrus"
 	"html/template"
 	"io"
-	"gopkg.in/go-playground/validator.v9"
-	"github.com/unrolled/secure"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func main() {
 	logger := newLogger()
 
-	// Load the application config
+	if len(os.Args) > 1 && os.Args[1] == checkConfigCommand {
+		os.Exit(runCheckConfig(os.Args[2:], logger, os.Stdout))
+	}
+
+	// Load the application config, reporting every problem with it rather than panicking on an invalid config
 	configService := newConfigService(logger)
-	appConfig := configService.LoadConfig()
-	emailConfig := appConfig.EmailConfig
+	appConfig, err := configService.TryLoadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Log as configured, until then everything is logged to stdout as JSON
+	err = logger.Configure(appConfig.Log)
+	if err != nil {
+		panic(err.Error())
+	}
 
-	// Get the absolute path to the templates directory
-	templateDir := appConfig.FrontendDir
-	absTemplateDir := getAbsolutePathOrPanic(templateDir, logger)
+	// Trace requests and the calls they make
+	shutdownTracing, err := setupTracing(appConfig, logger)
+	if err != nil {
+		panic(err.Error())
+	}
 
 	// Create the services
-	sesClient := newSesClient(emailConfig)
-	contactFormService := newContactFormService(logger, emailConfig, sesClient)
-	httpClient := newHttpClient()
-	recaptchaService := newRecaptchaService(appConfig.RecaptchaSecret, logger, httpClient)
+	httpClient := newHttpClient(appConfig)
+	metrics := services.NewPrometheusMetrics()
+	servicesFactory := newConfigDependentServicesFactory(logger, httpClient, tracingNoPropagateHosts(appConfig), metrics)
+	contactFormService, recaptchaService, err := servicesFactory(appConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// Report errors to the error tracker, if there is one
+	errorReporterFactory := func(appConfig *domain.AppConfig) (services.ErrorReporter, closeErrorReporterFunc, error) {
+		return newErrorReporter(appConfig, logger, httpClient, metrics)
+	}
+	errorReporter, closeErrorReporter, err := errorReporterFactory(appConfig)
+	if err != nil {
+		panic(err.Error())
+	}
 
 	// Create the AppContext
-	ctx := &AppContext{
+	appCtx := &AppContext{
 		Config:             appConfig,
-		TemplateDir:        absTemplateDir,
 		Logger:             logger,
+		Metrics:            metrics,
+		ErrorReporter:      errorReporter,
+		closeErrorReporter: closeErrorReporter,
 		ContactFormService: contactFormService,
 		RecaptchaService:   recaptchaService,
 		//ContactPageHandler:  contactPageHandler,
 	}
 
+	// Shut down gracefully on SIGTERM or SIGINT
+	ctx, cancel := newShutdownContext(logger)
+	defer cancel()
+
+	// Reload the config on SIGHUP, and whenever the config file changes if asked to
+	configReloader := NewConfigReloader(appCtx, configService, servicesFactory, errorReporterFactory)
+	configReloader.WatchSignals(ctx.Done())
+	if appConfig.WatchConfigFile && len(appConfig.ConfigFile) > 0 {
+		appCtx.Go(func() {
+			configReloader.WatchFile(appConfig.ConfigFile, configFileWatchInterval, ctx.Done())
+		})
+	}
+
 	// Run the application
-	RunApp(ctx)
-}
+	err = RunApp(ctx, appCtx)
+	if err != nil {
+		logger.Error("Server stopped with an error", services.Fields{"error": err.Error()})
+	}
+
+	// Export any traces and send any error reports which haven't been yet
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	shutdownTracing(tracingCtx)
+	cancelTracing()
 
-// getAbsolutePathOrPanic gets the absolute path of the provide path or panics
-func getAbsolutePathOrPanic(frontendDirectory string, logger services.Logger) string {
-	absPath, err := filepath.Abs(frontendDirectory)
+	errorReporterCtx, cancelErrorReporter := context.WithTimeout(context.Background(), errorReporterShutdownTimeout)
+	appCtx.swapErrorReporter(services.NewNoopErrorReporter(), nil)(errorReporterCtx)
+	cancelErrorReporter()
+
+	services.FlushLogger(logger)
+	logger.Close()
 	if err != nil {
-		logger.Error("Unable to get the absolute path for provide directory", services.Fields{"path": absPath})
-		panic(err)
+		os.Exit(1)
 	}
-	logger.Info("Found directory", services.Fields{"path": absPath})
-	return absPath
 }
 
 // AppContext is the application's context
@@ -67,12 +118,19 @@ type AppContext struct {
 	// Port is the port the application should run on
 	Config *domain.AppConfig
 
-	// TemplateDir is the directory containing the templates
-	TemplateDir string
-
 	// Logger is the application's logger
 	Logger services.Logger
 
+	// Metrics records measurements of the application
+	Metrics services.Metrics
+
+	// ErrorReporter reports errors to the error tracker, errors aren't reported when it is nil
+	ErrorReporter services.ErrorReporter
+
+	// closeErrorReporter sends the error reports the ErrorReporter hasn't sent yet, it is nil when there is nothing to
+	// send
+	closeErrorReporter closeErrorReporterFunc
+
 	// ContactFormService is a service responsible for dealing with Contact Forms
 	ContactFormService services.ContactFormService
 
@@ -81,45 +139,196 @@ type AppContext struct {
 
 	// ContactPageHandler is the handler for the contact page
 	ContactPageHandler http.Handler
+
+	// workers tracks the background workers started with Go
+	workers sync.WaitGroup
+
+	// ready is 1 when the instance should be sent traffic, it is accessed atomically
+	ready int32
+
+	// reloadMutex guards the config and the services built from it, which are swapped when the config is reloaded
+	reloadMutex sync.RWMutex
+}
+
+// CurrentConfig returns the config currently in use
+func (ctx *AppContext) CurrentConfig() *domain.AppConfig {
+	ctx.reloadMutex.RLock()
+	defer ctx.reloadMutex.RUnlock()
+	return ctx.Config
+}
+
+// CurrentServices returns the services built from the config currently in use. They're returned together so a request
+// never sees services built from different configs
+func (ctx *AppContext) CurrentServices() (services.ContactFormService, services.RecaptchaService) {
+	ctx.reloadMutex.RLock()
+	defer ctx.reloadMutex.RUnlock()
+	return ctx.ContactFormService, ctx.RecaptchaService
+}
+
+// CurrentErrorReporter returns the error reporter, which discards errors when none was given
+func (ctx *AppContext) CurrentErrorReporter() services.ErrorReporter {
+	ctx.reloadMutex.RLock()
+	defer ctx.reloadMutex.RUnlock()
+	if ctx.ErrorReporter == nil {
+		return services.NewNoopErrorReporter()
+	}
+	return ctx.ErrorReporter
+}
+
+// swapErrorReporter replaces the error reporter, returning the func sending what the replaced one hasn't sent yet
+func (ctx *AppContext) swapErrorReporter(errorReporter services.ErrorReporter, closeErrorReporter closeErrorReporterFunc) closeErrorReporterFunc {
+	ctx.reloadMutex.Lock()
+	defer ctx.reloadMutex.Unlock()
+	closeReplaced := ctx.closeErrorReporter
+	ctx.ErrorReporter = errorReporter
+	ctx.closeErrorReporter = closeErrorReporter
+	if closeReplaced == nil {
+		return func(_ context.Context) error { return nil }
+	}
+	return closeReplaced
+}
+
+// SetReady marks whether the instance should be sent traffic, it is reported by the readiness endpoint
+func (ctx *AppContext) SetReady(ready bool) {
+	value := int32(0)
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&ctx.ready, value)
+}
+
+// IsReady is true when the instance should be sent traffic
+func (ctx *AppContext) IsReady() bool {
+	return atomic.LoadInt32(&ctx.ready) == 1
 }
 
-func newLogger() services.Logger {
+// swapConfig atomically replaces the config and the services built from it
+func (ctx *AppContext) swapConfig(appConfig *domain.AppConfig, contactFormService services.ContactFormService, recaptchaService services.RecaptchaService) {
+	ctx.reloadMutex.Lock()
+	defer ctx.reloadMutex.Unlock()
+	ctx.Config = appConfig
+	ctx.ContactFormService = contactFormService
+	ctx.RecaptchaService = recaptchaService
+}
+
+func newLogger() *services.LogrusLogger {
 	logrusLogger := logrus.New()
-	logrus.SetFormatter(&logrus.JSONFormatter{})
-	logrus.SetLevel(logrus.InfoLevel)
+	logrusLogger.Formatter = &logrus.JSONFormatter{}
+	logrusLogger.SetLevel(logrus.InfoLevel)
 	logger := services.NewLogrusLogger(logrusLogger)
 	logger.Info("Starting logger", services.Fields{})
 	return logger
 }
 
-func newHttpClient() *http.Client {
+// errorReporterShutdownTimeout is how long is spent sending the remaining error reports when the application exits
+const errorReporterShutdownTimeout = 5 * time.Second
+
+// closeErrorReporterFunc sends any error reports which haven't been sent yet
+type closeErrorReporterFunc func(ctx context.Context) error
+
+// newErrorReporter creates the reporter sending errors to the error tracker, or discarding them when there isn't one
+func newErrorReporter(appConfig *domain.AppConfig, logger services.Logger, httpClient *http.Client, metrics services.Metrics) (services.ErrorReporter, closeErrorReporterFunc, error) {
+	if appConfig.ErrorReporting == nil || !appConfig.ErrorReporting.Enabled() {
+		return services.NewNoopErrorReporter(), func(_ context.Context) error { return nil }, nil
+	}
+
+	reporter, err := services.NewSentryReporter(appConfig.ErrorReporting, version, logger, httpClient, metrics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("Reporting errors", services.Fields{"environment": appConfig.ErrorReporting.Environment})
+	return reporter, reporter.Close, nil
+}
+
+// outboundRequestTimeout is how long outbound requests, e.g. to reCAPTCHA and SES, are given
+const outboundRequestTimeout = time.Second * 15
+
+func newHttpClient(appConfig *domain.AppConfig) *http.Client {
 	return &http.Client{
-		Timeout: time.Second * 15,
+		Timeout:   outboundRequestTimeout,
+		Transport: services.NewTracingTransport(http.DefaultTransport, tracingNoPropagateHosts(appConfig)),
 	}
 }
 
+// tracingNoPropagateHosts are the hosts outbound requests don't send the trace context to, the configured hosts and the
+// error tracker's, as it is a third party too
+func tracingNoPropagateHosts(appConfig *domain.AppConfig) []string {
+	hosts := append([]string{}, appConfig.TracingNoPropagateHosts...)
+	if appConfig.ErrorReporting != nil && appConfig.ErrorReporting.Enabled() {
+		if _, server, _, err := appConfig.ErrorReporting.ParseDsn(); err == nil {
+			hosts = append(hosts, server.Hostname())
+		}
+	}
+	return hosts
+}
+
 func newConfigService(logger services.Logger) services.ConfigService {
-	return services.NewEnvVarConfigService(logger)
+	return services.NewLayeredConfigService(logger, os.Args[1:])
+}
+
+// newSesConfig creates the config of the AWS SES client. Static credentials are used when they're configured,
+// otherwise they're left unset so the default AWS credential chain is used: environment variables, the shared
+// credentials and config files, then the ECS container or EC2 instance role, or a web identity token
+func newSesConfig(emailConfig *domain.EmailConfig) aws.Config {
+	awsConfig := aws.Config{
+		Region:     aws.String(emailConfig.AwsSesRegion),
+		HTTPClient: &http.Client{Timeout: outboundRequestTimeout},
+	}
+
+	if emailConfig.HasStaticCredentials() {
+		awsConfig.Credentials = credentials.NewStaticCredentials(emailConfig.AwsSesAccessKey, emailConfig.AwsSesSecretKey.Reveal(), "")
+	}
+
+	if len(emailConfig.AwsSesEndpoint) > 0 {
+		awsConfig.Endpoint = aws.String(emailConfig.AwsSesEndpoint)
+	}
+	return awsConfig
 }
 
-func newSesClient(emailConfig *domain.EmailConfig) *ses.SES {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(emailConfig.AwsSesRegion),
-		Credentials: credentials.NewStaticCredentials(emailConfig.AwsSesAccessKey, emailConfig.AwsSesSecretKey, ""),
+// newSesClient creates the AWS SES client, its requests are traced like any other outbound request. The session loads
+// any custom CA bundle, e.g. from AWS_CA_BUNDLE, into its client's transport, which it can only do to an
+// *http.Transport, so the transport is wrapped once the session has been created
+func newSesClient(emailConfig *domain.EmailConfig, noPropagateHosts []string) (*ses.SES, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            newSesConfig(emailConfig),
+		SharedConfigState: session.SharedConfigEnable,
 	})
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
-	return ses.New(sess)
+	transport := sess.Config.HTTPClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	sess.Config.HTTPClient.Transport = services.NewTracingTransport(transport, noPropagateHosts)
+
+	return ses.New(sess), nil
 }
 
-func newRecaptchaService(secret string, logger services.Logger, httpClient *http.Client) services.RecaptchaService {
-	return services.NewDefaultRecaptchaService(secret, logger, httpClient)
+func newRecaptchaService(secret domain.Secret, logger services.Logger, httpClient *http.Client, metrics services.Metrics) services.RecaptchaService {
+	return services.NewDefaultRecaptchaService(secret, logger, httpClient, metrics)
 }
 
-func newContactFormService(logger services.Logger, emailConfig *domain.EmailConfig, sesClient *ses.SES) services.ContactFormService {
-	return services.NewContactFormEmailService(logger, emailConfig, sesClient)
+func newContactFormService(logger services.Logger, emailConfig *domain.EmailConfig, sesClient *ses.SES, metrics services.Metrics) services.ContactFormService {
+	return services.NewContactFormEmailService(logger, emailConfig, sesClient, metrics)
+}
+
+// newConfigDependentServicesFactory creates the factory used to build the services which depend on the config, both on
+// start up and whenever the config is reloaded. Like the HTTP client, the hosts the trace context isn't sent to are only
+// read on start up
+func newConfigDependentServicesFactory(logger services.Logger, httpClient *http.Client, noPropagateHosts []string, metrics services.Metrics) ConfigDependentServicesFactory {
+	return func(appConfig *domain.AppConfig) (services.ContactFormService, services.RecaptchaService, error) {
+		sesClient, err := newSesClient(appConfig.EmailConfig, noPropagateHosts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		contactFormService := newContactFormService(logger, appConfig.EmailConfig, sesClient, metrics)
+		recaptchaService := newRecaptchaService(appConfig.RecaptchaSecret, logger, httpClient, metrics)
+		return contactFormService, recaptchaService, nil
+	}
 }
 
 //func newContactPageHandler(contactFormFilePath string, logger services.Logger, csrfService services.CsrfService) http.Handler {
@@ -136,6 +345,9 @@ func (t *EchoTemplate) Render(w io.Writer, name string, data interface{}, c echo
 
 type CustomEchoErrorHandler struct {
 	Logger services.Logger
+
+	// AppContext provides the site settings for the error pages
+	AppContext *AppContext
 }
 
 func (ceh *CustomEchoErrorHandler) handle(err error, c echo.Context) {
@@ -144,147 +356,404 @@ func (ceh *CustomEchoErrorHandler) handle(err error, c echo.Context) {
 		code = he.Code
 	}
 
-	ceh.Logger.Error("Rendering error page", services.Fields{
+	// Server errors are reported, panics already have been by the recover middleware
+	incidentID := ""
+	if recovered, ok := err.(*panicError); ok {
+		incidentID = recovered.incidentID
+	} else if code >= http.StatusInternalServerError {
+		incidentID = ceh.reportError(c, err)
+	}
+
+	logger := ceh.Logger.WithContext(c.Request().Context())
+	fields := services.Fields{
 		"error": err.Error(),
+	}
+	if len(incidentID) > 0 {
+		fields["incidentId"] = incidentID
+	}
+	logger.Error("Rendering error page", fields)
+
+	// A handler or template can fail after it has started writing the response, it is too late for the error page
+	if c.Response().Committed {
+		return
+	}
+
+	view := newPageView(ceh.AppContext, c, "", "", map[string]interface{}{
+		"Code": code,
 	})
+	view.IncidentID = incidentID
 
-	err = c.Render(code, fmt.Sprintf("%d.html", code), code)
+	err = c.Render(code, fmt.Sprintf("%d.html", code), view)
 	if err != nil {
-		ceh.Logger.Error("Unable to render error page", services.Fields{
+		logger.Error("Unable to render error page", services.Fields{
 			"error": err.Error(),
 		})
 	}
 }
 
-func RunApp(ctx *AppContext) {
-	logger := ctx.Logger
+// reportError reports an error returned by a handler to the error tracker, returning the incident ID it was reported as
+func (ceh *CustomEchoErrorHandler) reportError(c echo.Context, err error) string {
+	incidentID, idErr := newIncidentID()
+	if idErr != nil {
+		incidentID = requestID(c)
+	}
+
+	ceh.AppContext.CurrentErrorReporter().Report(c.Request().Context(), &services.ErrorEvent{
+		IncidentID: incidentID,
+		Err:        err,
+		Request:    c.Request(),
+	})
+	return incidentID
+}
+
+// newErrorHandlingMiddleware creates the middleware rendering the error page for an error returned by a handler or the
+// middleware after it. The middleware before it, which observe every request, see the error page's status rather than
+// the error
+func newErrorHandlingMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			return nil
+		}
+	}
+}
+
+// uploadRoutes are the routes taking form submissions and uploads, keyed by method and path. They're allowed larger
+// request bodies than everything else
+var uploadRoutes = map[string]bool{
+	"POST /contact":       true,
+	"POST " + reportsPath: true,
+}
+
+// isUploadRoute is true when the request is for one of the uploadRoutes
+func isUploadRoute(c echo.Context) bool {
+	return uploadRoutes[c.Request().Method+" "+c.Path()]
+}
+
+// newBodyLimitMiddleware limits the size of request bodies to the limit in the current config, so reloading the config
+// changes it. The limits are parsed when the config is loaded, so each request only reads them. Bodies over the limit
+// are rejected with a 413
+func newBodyLimitMiddleware(appCtx *AppContext, skipper middleware.Skipper, limit func(appConfig *domain.AppConfig) int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+
+			bodyLimit := limit(appCtx.CurrentConfig())
+			request := c.Request()
+			if request.ContentLength > bodyLimit {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+
+			// A body sent without its length is cut off once it passes the limit. Handlers don't all keep the error
+			// reading it, so whether the limit was passed is recorded by the body
+			body := &limitedBody{ReadCloser: request.Body, remaining: bodyLimit}
+			request.Body = body
+			err := next(c)
+			if err != nil && body.isExceeded {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+			return err
+		}
+	}
+}
+
+// limitedBody is a request body cut off at the body limit, recording whether it was
+type limitedBody struct {
+	io.ReadCloser
+
+	// remaining is how much more can be read before the limit is passed
+	remaining int64
+
+	isExceeded bool
+}
+
+func (body *limitedBody) Read(b []byte) (int, error) {
+	if int64(len(b)) > body.remaining+1 {
+		b = b[:body.remaining+1]
+	}
+
+	n, err := body.ReadCloser.Read(b)
+	if int64(n) > body.remaining {
+		body.isExceeded = true
+		return int(body.remaining), echo.ErrStatusRequestEntityTooLarge
+	}
+	body.remaining -= int64(n)
+	return n, err
+}
+
+// limitedBinder binds requests with echo's binder, rejecting a body cut off at the body limit with a 413 rather than
+// the 400 echo gives it
+type limitedBinder struct {
+	echo.DefaultBinder
+}
+
+func (binder *limitedBinder) Bind(i interface{}, c echo.Context) error {
+	err := binder.DefaultBinder.Bind(i, c)
+	if body, isLimited := c.Request().Body.(*limitedBody); isLimited && err != nil && body.isExceeded {
+		return echo.ErrStatusRequestEntityTooLarge
+	}
+	return err
+}
+
+// RunApp serves the application until the context is done, then stops accepting connections and waits for in flight
+// requests and background workers to finish within the configured shutdown timeout
+func RunApp(ctx context.Context, appCtx *AppContext) error {
+	logger := appCtx.Logger
 
 	e := echo.New()
+	appConfig := appCtx.CurrentConfig()
 
-	secureMiddleware := secure.New(secure.Options{
-		FrameDeny:          true,
-		ContentTypeNosniff: true,
-		BrowserXssFilter:   true,
-	})
-	e.Use(echo.WrapMiddleware(secureMiddleware.Handler))
+	// Compress HTML and text responses, first so the error pages are compressed too
+	e.Use(newCompressionMiddleware(appCtx))
+
+	// Identify, trace, record metrics for and log every request, then render the error page for any error so they
+	// record its status
+	e.Use(newRequestIDMiddleware())
+	e.Use(newTracingMiddleware(appCtx))
+	e.Use(newMetricsMiddleware(appCtx.Metrics))
+	e.Use(newAccessLogMiddleware(appCtx))
+	e.Use(newErrorHandlingMiddleware())
+
+	// Render the error page rather than an empty response when a handler or template panics
+	e.Use(newRecoverMiddleware(appCtx))
 
-	// Configure echo logging
-	//e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-	//	Format: "method=${method}, uri=${uri}, status=${status}\n",
-	//}))
+	// Send the security headers, HSTS only over HTTPS
+	securityHeadersMiddleware := newSecurityHeadersMiddleware(appCtx)
+	e.Use(securityHeadersMiddleware)
+
+	// Only allow the scripts, styles and frames the site needs, and ask browsers to report network errors
+	e.Use(newCSPMiddleware(appCtx))
+	e.Use(newNELMiddleware(appCtx))
 
 	// Configure echo error handling
 	customerErrorHandler := &CustomEchoErrorHandler{
-		Logger: logger,
+		Logger:     logger,
+		AppContext: appCtx,
 	}
 	e.HTTPErrorHandler = customerErrorHandler.handle
 
-	// Configure templates
-	viewsDir := filepath.Join(getAbsolutePathOrPanic("views", ctx.Logger), "*.html")
-	logger.Info("Views directory", services.Fields{
-		"viewsDir": viewsDir,
+	// Limit the size of request bodies, routes taking form submissions and uploads get their own larger limit
+	e.Binder = &limitedBinder{}
+	e.Use(newBodyLimitMiddleware(appCtx, isUploadRoute, func(appConfig *domain.AppConfig) int64 {
+		return appConfig.BodyLimit
+	}))
+	uploadBodyLimit := newBodyLimitMiddleware(appCtx, middleware.DefaultSkipper, func(appConfig *domain.AppConfig) int64 {
+		return appConfig.UploadBodyLimit
 	})
+
+	// Read the views and public files from the binary, or the frontend dir where it overrides them
+	frontend := newFrontendFS(appConfig.FrontendDir)
+	if len(appConfig.FrontendDir) > 0 {
+		logger.Info("Overriding the frontend files", services.Fields{"frontendDir": appConfig.FrontendDir})
+	}
+
+	// Fingerprint the public files, so they can be cached forever, and compress them once rather than for every request
+	assets, err := newAssetManifest(frontend, appConfig.Compression != nil && appConfig.Compression.Precompress)
+	if err != nil {
+		return err
+	}
+	logger.Info("Fingerprinted the public files", services.Fields{"files": len(assets.byPath)})
+
+	// Configure templates
+	templates, err := parseTemplates(frontend, assets)
+	if err != nil {
+		return err
+	}
 	t := &EchoTemplate{
-		templates: template.Must(template.ParseGlob("views/*.html")),
+		templates: templates,
 	}
 	e.Renderer = t
 
 	// Register the public dir
-	e.Static("/", "public")
+	e.GET("/*", newPublicFileHandler(appCtx, assets))
+
+	// Register the health checks
+	e.GET(livenessPath, newLivenessHandler())
+	e.GET(readinessPath, newReadinessHandler(appCtx, newReadinessChecks(appCtx, t.templates),
+		newCachedHealthChecks(logger, newDependencyChecks(appCtx), dependencyCheckInterval)))
+
+	// Serve the metrics alongside the site, unless they have their own port
+	var metricsServer *http.Server
+	if appConfig.MetricsEnabled {
+		if appConfig.MetricsPort > 0 {
+			metricsServer = newMetricsServer(appConfig, appCtx.Metrics)
+		} else {
+			e.GET(metricsPath, echo.WrapHandler(appCtx.Metrics.Handler()))
+		}
+	}
 
 	// Register endpoints
-	e.GET("/", func(c echo.Context) error {
+	e.GET("/", newStaticPageHandler(appCtx, "index.html",
+		"We build great software and enable others to do the same",
+		"From concept to reality. We provide the services and the support to make your software project a success."))
 
-		params := map[string]interface{}{
-			"Tagline":        "We build great software and enable others to do the same",
-			"TaglineSummary": "From concept to reality. We provide the services and the support to make your software project a success.",
-		}
+	e.GET("/services", newStaticPageHandler(appCtx, "index.html", "", ""))
 
-		return c.Render(http.StatusOK, "index.html", params)
-	})
+	e.GET("/privacy", newStaticPageHandler(appCtx, "privacy.html",
+		"Privacy notice",
+		"This privacy notice is for visitors of this website."))
 
-	e.GET("/services", func(c echo.Context) error {
-		params := map[string]interface{}{
-		}
+	e.GET("/cookies", newStaticPageHandler(appCtx, "cookies.html",
+		"Cookies Policy",
+		"This cookie policy is for visitors of this website."))
 
-		return c.Render(http.StatusOK, "index.html", params)
-	})
+	e.GET("/contact", newContactPageHandler(appCtx))
 
-	e.GET("/privacy", func(c echo.Context) error {
-		params := map[string]interface{}{
-			"Tagline":        "Privacy notice",
-			"TaglineSummary": "This privacy notice is for visitors of this website.",
-		}
+	submissionReceipts := services.NewSubmissionReceiptStore(maxSubmissionReceipts, submissionReceiptMaxAge)
+	e.GET(contactThanksPath, newContactThanksPageHandler(appCtx, submissionReceipts))
 
-		return c.Render(http.StatusOK, "privacy.html", params)
-	})
+	e.POST("/contact", newContactFormSubmissionHandler(appCtx, submissionReceipts), uploadBodyLimit)
 
-	e.GET("/cookies", func(c echo.Context) error {
-		params := map[string]interface{}{
-			"Tagline":        "Cookies Policy",
-			"TaglineSummary": "This cookie policy is for visitors of this website.",
-		}
+	// Count the CSP violations and network errors browsers report, they're shown in the admin area
+	reportingConfig := appConfig.Reporting
+	if reportingConfig == nil {
+		reportingConfig = defaultReportingConfig
+	}
+	browserReports := services.NewBrowserReportStore(reportingConfig, logger, appCtx.Metrics)
+	e.POST(reportsPath, newBrowserReportsHandler(appCtx, browserReports), uploadBodyLimit)
+	e.GET(adminReportsPath, newAdminReportsPageHandler(appCtx, browserReports), newAdminAuthMiddleware(appCtx, services.NewFailedLoginLimiter(adminMaxFailedLogins, adminLoginWindow)))
 
-		return c.Render(http.StatusOK, "cookies.html", params)
-	})
+	// Check and log the security headers each route is sent
+	logEffectiveSecurityHeaders(logger, appConfig, e.Routes(), securityHeadersMiddleware)
+
+	// TODO: move to service
+	httpPort := appConfig.HttpPort
+	serverErrors := make(chan error, 3)
+
+	if metricsServer != nil {
+		logger.Info("Starting metrics server", services.Fields{"port": appConfig.MetricsPort})
+		go func() {
+			serverErrors <- metricsServer.ListenAndServe()
+		}()
+	}
 
-	e.GET("/contact", func(c echo.Context) error {
-		params := map[string]interface{}{
-			"Tagline":        "Contact",
-			"TaglineSummary": "Have a question? Want to chat about a project you're working on? Fill in the form below or drop us an email. We'll be right with you.",
+	var redirectServer *http.Server
+	if appConfig.TLS.Enabled() {
+		// Serve HTTPS, plain HTTP only redirects to it and answers health probes
+		certificates, err := newCertificateSource(appConfig.TLS, logger)
+		if err != nil {
+			return err
+		}
+		if reloading, isReloading := certificates.(*reloadingCertificate); isReloading {
+			appCtx.Go(func() {
+				reloading.Watch(certificateCheckInterval, ctx.Done())
+			})
 		}
 
-		return c.Render(http.StatusOK, "contact.html", params)
-	})
+		httpsPort := appConfig.TLS.HttpsPort
+		logger.Info("Starting server", services.Fields{"port": httpsPort, "redirectPort": httpPort})
 
-	e.POST("/contact", func(c echo.Context) error {
+		e.TLSServer.Addr = fmt.Sprintf(":%d", httpsPort)
+		e.TLSServer.TLSConfig = newServerTLSConfig(certificates)
+		configureServerTimeouts(e.TLSServer, appConfig)
 
-		contactFormSubmission := &domain.ContactForm{
-			Name:              c.FormValue("name"),
-			Email:             c.FormValue("email"),
-			Company:           c.FormValue("company"),
-			Number:            c.FormValue("number"),
-			Message:           c.FormValue("message"),
-			RecaptchaResponse: c.FormValue("g-recaptcha-response"),
+		redirectServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", httpPort),
+			Handler: certificates.HTTPHandler(newHTTPSRedirectHandler(httpsRedirectHosts(appConfig), httpsPort, e)),
 		}
+		configureServerTimeouts(redirectServer, appConfig)
+
+		go func() {
+			serverErrors <- e.StartServer(e.TLSServer)
+		}()
+		go func() {
+			serverErrors <- redirectServer.ListenAndServe()
+		}()
+	} else {
+		logger.Info("Starting server", services.Fields{"port": httpPort})
+
+		configureServerTimeouts(e.Server, appConfig)
+		go func() {
+			serverErrors <- e.Start(fmt.Sprintf(":%d", httpPort))
+		}()
+	}
 
-		validate := validator.New()
-		err := validate.Struct(contactFormSubmission)
-		if err != nil {
-			logger.Error("Received an invalid contact form", services.Fields{"error": err.Error()})
-			return c.JSON(400, "")
-		}
+	appCtx.SetReady(true)
 
-		recaptchaService := ctx.RecaptchaService
-		err = recaptchaService.Verify(contactFormSubmission.RecaptchaResponse)
-		if err != nil {
-			errorMessage := err.Error()
-			if errorMessage == services.CannotCommunicateRecaptchaError {
-				logger.Error("Unable to communicate with Recaptcha Service", services.Fields{"error": err.Error()})
-				return c.JSON(500, "")
-			} else if errorMessage == services.NotVerifiedError {
-				logger.Warn("Received a submit contact form request for a user not verified by Recaptcha", services.Fields{"error": err.Error()})
-				return c.JSON(403, "")
-			} else {
-				logger.Warn("Unexpected error verifying contact form submission with Recaptcha", services.Fields{"error": err.Error()})
-				return c.JSON(500, "")
+	select {
+	case err := <-serverErrors:
+		// A server stopped without being asked to, e.g. the port is in use
+		appCtx.SetReady(false)
+		e.Close()
+		for _, server := range []*http.Server{redirectServer, metricsServer} {
+			if server != nil {
+				server.Close()
 			}
 		}
+		return err
+	case <-ctx.Done():
+	}
 
-		err = ctx.ContactFormService.Process(contactFormSubmission)
-		if err != nil {
-			logger.Error("Unable to process contact form", services.Fields{"error": err.Error()})
-			return c.JSON(500, "")
+	return shutdownServer(e, appCtx, redirectServer, metricsServer)
+}
+
+// newMetricsServer creates the server serving the metrics on their own port, so they needn't be exposed publicly
+func newMetricsServer(appConfig *domain.AppConfig, metrics services.Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metrics.Handler())
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", appConfig.MetricsPort),
+		Handler: mux,
+	}
+	configureServerTimeouts(server, appConfig)
+	return server
+}
+
+// configureServerTimeouts applies the configured timeouts to the server
+func configureServerTimeouts(server *http.Server, appConfig *domain.AppConfig) {
+	server.ReadTimeout = appConfig.ReadTimeout
+	server.ReadHeaderTimeout = appConfig.ReadHeaderTimeout
+	server.WriteTimeout = appConfig.WriteTimeout
+	server.IdleTimeout = appConfig.IdleTimeout
+}
+
+// shutdownServer stops the server, and any other servers such as the HTTPS redirect server, accepting connections then
+// waits for in flight requests and background workers to finish, giving up on them at the shutdown deadline
+func shutdownServer(e *echo.Echo, appCtx *AppContext, otherServers ...*http.Server) error {
+	logger := appCtx.Logger
+	appConfig := appCtx.CurrentConfig()
+
+	// Report not ready then keep serving for a while, so load balancers stop sending requests before connections are
+	// refused
+	appCtx.SetReady(false)
+	if appConfig.ShutdownDrainDelay > 0 {
+		logger.Info("Marked not ready, waiting for load balancers to notice", services.Fields{"delay": appConfig.ShutdownDrainDelay.String()})
+		time.Sleep(appConfig.ShutdownDrainDelay)
+	}
+
+	shutdownTimeout := appConfig.ShutdownTimeout
+	logger.Info("Shutting down server", services.Fields{"timeout": shutdownTimeout.String()})
+
+	deadline, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, server := range otherServers {
+		if server != nil {
+			server.Shutdown(deadline)
 		}
+	}
 
-		return c.JSON(200, "")
+	serverErr := e.Shutdown(deadline)
+	if serverErr != nil {
+		logger.Error("In flight requests did not finish before the shutdown deadline", services.Fields{"error": serverErr.Error()})
+		e.Close()
+	}
 
-	})
+	workersErr := appCtx.waitForWorkers(deadline)
+	if workersErr != nil {
+		logger.Error("Background workers did not finish before the shutdown deadline", services.Fields{"error": workersErr.Error()})
+	}
 
-	// TODO: move to service
-	httpPort := ctx.Config.HttpPort
-	logger.Info("Starting server", services.Fields{"port": httpPort})
+	logger.Info("Server stopped", services.Fields{})
 
-	// TODO: Timeouts
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%d", httpPort)))
+	if serverErr != nil {
+		return serverErr
+	}
+	return workersErr
 }
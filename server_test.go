@@ -1,26 +1,45 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/adbourne/website-seacitysoftware/domain"
 	"github.com/adbourne/website-seacitysoftware/mocks"
 	"github.com/adbourne/website-seacitysoftware/services"
-	"github.com/gin-gonic/gin/json"
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	awsses "github.com/aws/aws-sdk-go/service/ses"
+	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"html"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-const TemplatesDir = "./target/build/"
-
 type ApplicationTestSuite struct {
 	suite.Suite
 	Logger services.Logger
@@ -29,6 +48,19 @@ type ApplicationTestSuite struct {
 	MockContactFormService *mocks.ContactFormService
 	MockRecaptchaService   *mocks.RecaptchaService
 	AppContext             *AppContext
+
+	// stopApp stops the app started by startApp
+	stopApp context.CancelFunc
+
+	// appErrors receives the error RunApp returns
+	appErrors chan error
+
+	// transport is used for every request to the app, so its connections can be closed before the app is shut down.
+	// The app's certificates are self-signed so aren't verified
+	transport *http.Transport
+
+	// client makes the requests to the app
+	client *http.Client
 }
 
 func (suite *ApplicationTestSuite) SetupTest() {
@@ -37,33 +69,63 @@ func (suite *ApplicationTestSuite) SetupTest() {
 	suite.MockContactFormService = new(mocks.ContactFormService)
 	suite.MockRecaptchaService = new(mocks.RecaptchaService)
 	// TODO: Figure out why this can't be done in the test function
-	suite.MockContactFormService.On("Process", mock.Anything).Return(nil)
-	suite.MockRecaptchaService.On("Verify", mock.Anything).Return(nil)
+	suite.MockContactFormService.On("Process", mock.Anything, mock.Anything).Return(nil)
+	suite.MockRecaptchaService.On("Verify", mock.Anything, mock.Anything).Return(nil)
 	suite.AppContext = suite.createTestAppContext(suite.Port, suite.MockContactFormService, suite.MockRecaptchaService)
+	suite.stopApp = nil
+	suite.transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	suite.client = &http.Client{Timeout: 5 * time.Second, Transport: suite.transport}
+}
+
+func (suite *ApplicationTestSuite) TearDownTest() {
+	if suite.stopApp == nil {
+		return
+	}
+
+	// An idle connection, or one dialed but never used, would hold up the shutdown
+	suite.transport.CloseIdleConnections()
+	suite.stopApp()
+	select {
+	case err := <-suite.appErrors:
+		assert.NoError(suite.T(), err, "the app did not shut down cleanly")
+	case <-time.After(10 * time.Second):
+		assert.Fail(suite.T(), "the app did not shut down")
+	}
+}
+
+// startApp runs the app in the background, it is shut down when the test finishes
+func (suite *ApplicationTestSuite) startApp() {
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.stopApp = cancel
+	suite.appErrors = make(chan error, 1)
+
+	go func() {
+		suite.appErrors <- RunApp(ctx, suite.AppContext)
+	}()
 }
 
 func (suite *ApplicationTestSuite) TestThatAFaviconExists() {
-	go RunApp(suite.AppContext)
+	suite.startApp()
 	homePageURL := fmt.Sprintf("http://localhost:%d/favicon.ico", suite.Port)
 	suite.assertPageReturns200(homePageURL)
 }
 
 func (suite *ApplicationTestSuite) TestThatAHomePageExists() {
-	go RunApp(suite.AppContext)
+	suite.startApp()
 
 	homePageURL := fmt.Sprintf("http://localhost:%d/", suite.Port)
 	suite.assertPageReturns200(homePageURL)
 }
 
 func (suite *ApplicationTestSuite) TestThatAContactPageExists() {
-	go RunApp(suite.AppContext)
+	suite.startApp()
 
 	contactPageURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
 	suite.assertPageReturns200(contactPageURL)
 }
 
 func (suite *ApplicationTestSuite) TestAContactPageCanBeSubmitted() {
-	go RunApp(suite.AppContext)
+	suite.startApp()
 
 	contactApiURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
 
@@ -95,8 +157,9 @@ func (suite *ApplicationTestSuite) TestAContactPageCanBeSubmitted() {
 		fmt.Printf("%+v\n", request)
 
 		client := &http.Client{
-			Timeout: 5 * time.Second,
-			Jar:     cookieJar,
+			Timeout:   5 * time.Second,
+			Jar:       cookieJar,
+			Transport: suite.transport,
 		}
 
 		resp, err := client.Do(request)
@@ -118,20 +181,944 @@ func (suite *ApplicationTestSuite) TestAContactPageCanBeSubmitted() {
 	suite.MockContactFormService.AssertExpectations(suite.T())
 }
 
+func (suite *ApplicationTestSuite) TestAContactFormCanBeSubmittedAsAForm() {
+	suite.startApp()
+
+	contactApiURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
+
+	form := url.Values{}
+	form.Set("name", "Bob")
+	form.Set("email", "bob@someemail.com")
+	form.Set("company", "Bobcorp")
+	form.Set("number", "12345678")
+	form.Set("message", "Hey there!")
+	form.Set("g-recaptcha-response", "token")
+
+	receipt := &domain.ContactFormReceipt{}
+	location := ""
+	err := Eventually(func() error {
+		resp, err := suite.client.PostForm(contactApiURL, form)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if http.StatusCreated != resp.StatusCode {
+			return fmt.Errorf("received http status code %d", resp.StatusCode)
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(receipt)
+		if err != nil {
+			return err
+		}
+
+		location = resp.Header.Get("Location")
+		if location != contactThanksPath+"?ref="+url.QueryEscape(receipt.ID) {
+			return fmt.Errorf("unexpected location '%s'", location)
+		}
+
+		return nil
+	}, 10, 2*time.Second)
+
+	assert.NoError(suite.T(), err, "201 not returned by contact form submission")
+	assert.NotEmpty(suite.T(), receipt.ID)
+	suite.MockRecaptchaService.AssertCalled(suite.T(), "Verify", mock.Anything, "token")
+
+	// The Location can be followed to the submission's receipt
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d%s", suite.Port, location), http.StatusOK, func(resp *http.Response) error {
+		followed := &domain.ContactFormReceipt{}
+		err := json.NewDecoder(resp.Body).Decode(followed)
+		if err != nil {
+			return err
+		}
+		assert.Equal(suite.T(), receipt, followed)
+		return nil
+	})
+
+	// A reference which isn't one of ours isn't found
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d%s?ref=nope", suite.Port, contactThanksPath), http.StatusNotFound, func(resp *http.Response) error {
+		return nil
+	})
+
+	// Nor is a well formed reference which was never issued
+	unknownID, err := newSubmissionID()
+	assert.NoError(suite.T(), err)
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d%s?ref=%s", suite.Port, contactThanksPath, unknownID), http.StatusNotFound, func(resp *http.Response) error {
+		return nil
+	})
+}
+
+func (suite *ApplicationTestSuite) TestABrowserContactFormSubmissionIsRedirected() {
+	suite.startApp()
+
+	contactApiURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
+
+	form := url.Values{}
+	form.Set("name", "Bob")
+	form.Set("email", "bob@someemail.com")
+	form.Set("company", "Bobcorp")
+	form.Set("number", "12345678")
+	form.Set("message", "Hey there!")
+	form.Set("g-recaptcha-response", "token")
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: suite.transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	location := ""
+	err := Eventually(func() error {
+		request, err := http.NewRequest("POST", contactApiURL, strings.NewReader(form.Encode()))
+		assert.NoError(suite.T(), err, "unable to construct post request")
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+
+		resp, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if http.StatusSeeOther != resp.StatusCode {
+			return fmt.Errorf("received http status code %d", resp.StatusCode)
+		}
+
+		location = resp.Header.Get("Location")
+		if !strings.HasPrefix(location, contactThanksPath+"?ref=") {
+			return fmt.Errorf("unexpected location '%s'", location)
+		}
+
+		return nil
+	}, 10, 2*time.Second)
+
+	assert.NoError(suite.T(), err, "303 not returned by browser contact form submission")
+
+	// The browser is shown the thanks page with the reference on it
+	request, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d%s", suite.Port, location), nil)
+	assert.NoError(suite.T(), err, "unable to construct get request")
+	request.Header.Set("Accept", "text/html")
+	resp, err := client.Do(request)
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Contains(suite.T(), string(body), strings.TrimPrefix(location, contactThanksPath+"?ref="))
+
+	// A reference which wasn't issued isn't shown, so the page can't be made to show text of someone else's choosing
+	spoofed := "Call 0800 000 000 to confirm your bank details"
+	request, err = http.NewRequest("GET", fmt.Sprintf("http://localhost:%d%s?ref=%s", suite.Port, contactThanksPath, url.QueryEscape(spoofed)), nil)
+	assert.NoError(suite.T(), err, "unable to construct get request")
+	request.Header.Set("Accept", "text/html")
+	spoofedResp, err := client.Do(request)
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+	defer spoofedResp.Body.Close()
+	body, err = ioutil.ReadAll(spoofedResp.Body)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, spoofedResp.StatusCode)
+	assert.NotContains(suite.T(), string(body), "bank details")
+	assert.NotContains(suite.T(), string(body), "Your reference is")
+}
+
+func (suite *ApplicationTestSuite) TestAnInvalidBrowserContactFormSubmissionIsRenderedWithErrors() {
+	suite.startApp()
+
+	contactApiURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
+
+	form := url.Values{}
+	form.Set("name", "Bob")
+	form.Set("email", "not an email")
+	form.Set("message", "Hey there!")
+
+	var body []byte
+	err := Eventually(func() error {
+		request, err := http.NewRequest("POST", contactApiURL, strings.NewReader(form.Encode()))
+		assert.NoError(suite.T(), err, "unable to construct post request")
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Accept", "text/html")
+
+		resp, err := suite.client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if http.StatusBadRequest != resp.StatusCode {
+			return fmt.Errorf("received http status code %d", resp.StatusCode)
+		}
+
+		body, err = ioutil.ReadAll(resp.Body)
+		return err
+	}, 10, 2*time.Second)
+
+	assert.NoError(suite.T(), err, "400 not returned by invalid browser contact form submission")
+	assert.Contains(suite.T(), string(body), `value="Bob"`)
+	assert.Contains(suite.T(), string(body), contactFieldErrorMessages["email"])
+	assert.Contains(suite.T(), string(body), contactFieldErrorMessages["number"])
+	suite.MockContactFormService.AssertNotCalled(suite.T(), "Process", mock.Anything, mock.Anything)
+}
+
+func (suite *ApplicationTestSuite) TestThatPagesShowTheSiteSettings() {
+	suite.startApp()
+
+	contactPageURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
+	suite.assertPageHasStatusCallback(contactPageURL, 200, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		for _, expected := range []string{
+			"| Test Site</title>",
+			`<link rel="canonical" href="https://test.seacitysoftware.com/contact">`,
+			"Test Holder Ltd. All rights reserved.",
+			"Company No. 00000000.",
+			"gtag/js?id=UA-TEST-1",
+			`'sitekey': "test-site-key"`,
+		} {
+			if !strings.Contains(string(body), expected) {
+				return fmt.Errorf("page does not contain '%s'", expected)
+			}
+		}
+		return nil
+	})
+}
+
 func (suite *ApplicationTestSuite) TestThatAPrivacyPolicyPageExists() {
-	go RunApp(suite.AppContext)
+	suite.startApp()
 
 	privacyPageURL := fmt.Sprintf("http://localhost:%d/privacy", suite.Port)
-	suite.assertPageReturns200(privacyPageURL)
+	suite.assertPageHasStatusCallback(privacyPageURL, http.StatusOK, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(string(body), "We are Test Holder Ltd,") {
+			return errors.New("the privacy notice doesn't name the copyright holder")
+		}
+		return nil
+	})
 }
 
 func (suite *ApplicationTestSuite) TestThatACookiesPolicyPageExists() {
-	go RunApp(suite.AppContext)
+	suite.startApp()
 
 	privacyPageURL := fmt.Sprintf("http://localhost:%d/cookies", suite.Port)
 	suite.assertPageReturns200(privacyPageURL)
 }
 
+func (suite *ApplicationTestSuite) TestAnOversizedContactFormSubmissionIsRejected() {
+	suite.startApp()
+
+	form := url.Values{}
+	form.Set("name", "Bob")
+	form.Set("email", "bob@someemail.com")
+	form.Set("company", "Bobcorp")
+	form.Set("number", "12345678")
+	form.Set("message", strings.Repeat("a", 128*1024))
+
+	suite.assertPageReturns200(fmt.Sprintf("http://localhost:%d/", suite.Port))
+	request, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/contact", suite.Port), strings.NewReader(form.Encode()))
+	assert.NoError(suite.T(), err, "unable to construct post request")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "text/html")
+
+	resp, err := suite.client.Do(request)
+	assert.NoError(suite.T(), err, "unable to post the contact form")
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(suite.T(), err, "unable to read the response")
+	assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Contains(suite.T(), string(body), "413")
+
+	// A body sent without its length is cut off at the limit too
+	request, err = http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/contact", suite.Port), ioutil.NopCloser(strings.NewReader(form.Encode())))
+	assert.NoError(suite.T(), err, "unable to construct post request")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "text/html")
+
+	chunkedResp, err := suite.client.Do(request)
+	if assert.NoError(suite.T(), err, "unable to post the contact form") {
+		chunkedResp.Body.Close()
+		assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, chunkedResp.StatusCode)
+	}
+	suite.MockContactFormService.AssertNotCalled(suite.T(), "Process", mock.Anything, mock.Anything)
+}
+
+func (suite *ApplicationTestSuite) TestOtherRoutesOnlyAcceptSmallBodies() {
+	suite.startApp()
+
+	suite.assertPageReturns200(fmt.Sprintf("http://localhost:%d/", suite.Port))
+	resp, err := suite.client.Post(fmt.Sprintf("http://localhost:%d/privacy", suite.Port), "text/plain", strings.NewReader(strings.Repeat("a", 8*1024)))
+	assert.NoError(suite.T(), err, "unable to post to the privacy page")
+	resp.Body.Close()
+
+	assert.Equal(suite.T(), http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// The limit is read for every request, so reloading the config changes it
+	reloadedConfig := *suite.AppContext.CurrentConfig()
+	reloadedConfig.BodyLimit = 16 * 1024
+	contactFormService, recaptchaService := suite.AppContext.CurrentServices()
+	suite.AppContext.swapConfig(&reloadedConfig, contactFormService, recaptchaService)
+
+	resp, err = suite.client.Post(fmt.Sprintf("http://localhost:%d/privacy", suite.Port), "text/plain", strings.NewReader(strings.Repeat("a", 8*1024)))
+	assert.NoError(suite.T(), err, "unable to post to the privacy page")
+	resp.Body.Close()
+
+	assert.NotEqual(suite.T(), http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func (suite *ApplicationTestSuite) TestInFlightSubmissionsFinishWhenShuttingDown() {
+	slowContactFormService := new(mocks.ContactFormService)
+	slowContactFormService.On("Process", mock.Anything, mock.Anything).After(time.Second).Return(nil)
+	suite.AppContext.ContactFormService = slowContactFormService
+
+	workerStopped := make(chan struct{})
+	suite.startApp()
+	suite.assertPageReturns200(fmt.Sprintf("http://localhost:%d/", suite.Port))
+
+	// A background worker which takes a moment to stop
+	done := make(chan struct{})
+	suite.AppContext.Go(func() {
+		<-done
+		time.Sleep(100 * time.Millisecond)
+		close(workerStopped)
+	})
+
+	form := url.Values{}
+	form.Set("name", "Bob")
+	form.Set("email", "bob@someemail.com")
+	form.Set("company", "Bobcorp")
+	form.Set("number", "12345678")
+	form.Set("message", "Hey there!")
+
+	statusCodes := make(chan int, 1)
+	go func() {
+		resp, err := suite.client.PostForm(fmt.Sprintf("http://localhost:%d/contact", suite.Port), form)
+		if err != nil {
+			statusCodes <- 0
+			return
+		}
+		resp.Body.Close()
+		statusCodes <- resp.StatusCode
+	}()
+
+	// Shut down while the submission is being processed
+	time.Sleep(200 * time.Millisecond)
+	close(done)
+	suite.stopApp()
+
+	assert.NoError(suite.T(), <-suite.appErrors, "the app did not shut down cleanly")
+	assert.Equal(suite.T(), http.StatusCreated, <-statusCodes, "the in flight submission was cut off")
+
+	select {
+	case <-workerStopped:
+	default:
+		assert.Fail(suite.T(), "RunApp returned before the background worker stopped")
+	}
+
+	_, err := suite.client.Get(fmt.Sprintf("http://localhost:%d/", suite.Port))
+	assert.Error(suite.T(), err, "the server is still accepting connections")
+	suite.stopApp = nil
+}
+
+func (suite *ApplicationTestSuite) TestHTTPSCanBeServedNatively() {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(suite.T(), err, "unable to create temp dir")
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(suite.T(), dir, 1)
+	httpsPort := suite.getFreePort()
+	suite.AppContext.Config.TLS = &domain.TLSConfig{
+		HttpsPort:  httpsPort,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		HstsMaxAge: 24 * time.Hour,
+	}
+	suite.AppContext.Config.Site.BaseURL = "https://localhost"
+	suite.startApp()
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: suite.transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var resp *http.Response
+	err = Eventually(func() error {
+		resp, err = client.Get(fmt.Sprintf("https://localhost:%d/", httpsPort))
+		return err
+	}, 10, 500*time.Millisecond)
+	assert.NoError(suite.T(), err, "unable to get the home page over HTTPS")
+	resp.Body.Close()
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(suite.T(), "max-age=86400", resp.Header.Get("Strict-Transport-Security"))
+
+	err = Eventually(func() error {
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/contact", suite.Port))
+		return err
+	}, 10, 500*time.Millisecond)
+	assert.NoError(suite.T(), err, "unable to get the contact page over HTTP")
+	resp.Body.Close()
+	assert.Equal(suite.T(), http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(suite.T(), fmt.Sprintf("https://localhost:%d/contact", httpsPort), resp.Header.Get("Location"))
+
+	// Only the site's own host is redirected to
+	request, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/contact", suite.Port), nil)
+	assert.NoError(suite.T(), err)
+	request.Host = "evil.example.net"
+	resp, err = client.Do(request)
+	if assert.NoError(suite.T(), err, "unable to get the contact page for another host over HTTP") {
+		resp.Body.Close()
+		assert.Equal(suite.T(), http.StatusBadRequest, resp.StatusCode)
+		assert.Empty(suite.T(), resp.Header.Get("Location"))
+	}
+
+	// Health probes of the HTTP port are answered rather than redirected
+	resp, err = client.Get(fmt.Sprintf("http://localhost:%d%s", suite.Port, readinessPath))
+	if assert.NoError(suite.T(), err, "unable to get readiness over HTTP") {
+		resp.Body.Close()
+		assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	}
+}
+
+// unreachableRecaptchaService is a recaptcha service which can't reach recaptcha
+type unreachableRecaptchaService struct {
+	*mocks.RecaptchaService
+}
+
+func (*unreachableRecaptchaService) CheckHealth(_ context.Context) error {
+	return errors.New("recaptcha is unreachable")
+}
+
+func (suite *ApplicationTestSuite) getHealth(path string) (int, *healthResponse) {
+	var resp *http.Response
+	err := Eventually(func() error {
+		var err error
+		resp, err = suite.client.Get(fmt.Sprintf("http://localhost:%d%s", suite.Port, path))
+		return err
+	}, 10, 500*time.Millisecond)
+	assert.NoError(suite.T(), err, "unable to get "+path)
+	defer resp.Body.Close()
+
+	health := &healthResponse{}
+	err = json.NewDecoder(resp.Body).Decode(health)
+	assert.NoError(suite.T(), err, "unable to decode the health response")
+	return resp.StatusCode, health
+}
+
+func (suite *ApplicationTestSuite) TestTheInstanceReportsItIsAliveAndReady() {
+	suite.startApp()
+
+	code, health := suite.getHealth(livenessPath)
+	assert.Equal(suite.T(), http.StatusOK, code)
+	assert.Equal(suite.T(), healthStatusOK, health.Status)
+
+	code, health = suite.getHealth(readinessPath)
+	assert.Equal(suite.T(), http.StatusOK, code)
+	assert.Equal(suite.T(), healthStatusOK, health.Status)
+	if assert.Contains(suite.T(), health.Checks, "templates") {
+		assert.Equal(suite.T(), healthStatusOK, health.Checks["templates"].Status)
+	}
+	for _, name := range []string{"email", "captcha"} {
+		if assert.Contains(suite.T(), health.Dependencies, name) {
+			assert.Equal(suite.T(), healthStatusOK, health.Dependencies[name].Status, name)
+		}
+	}
+
+	// Shutting down
+	suite.AppContext.SetReady(false)
+	code, health = suite.getHealth(readinessPath)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, code)
+	assert.Equal(suite.T(), healthStatusShuttingDown, health.Status)
+}
+
+func (suite *ApplicationTestSuite) TestAnUnreachableDependencyIsReportedWithoutMakingTheInstanceUnready() {
+	suite.AppContext.RecaptchaService = &unreachableRecaptchaService{suite.MockRecaptchaService}
+	suite.startApp()
+
+	code, health := suite.getHealth(readinessPath)
+	assert.Equal(suite.T(), http.StatusOK, code)
+	assert.Equal(suite.T(), healthStatusOK, health.Status)
+	if assert.Contains(suite.T(), health.Dependencies, "captcha") {
+		assert.Equal(suite.T(), healthStatusFail, health.Dependencies["captcha"].Status)
+		// Why it failed is logged rather than given to whoever polls readiness
+		assert.Empty(suite.T(), health.Dependencies["captcha"].Error)
+	}
+	assert.Equal(suite.T(), healthStatusOK, health.Dependencies["email"].Status)
+}
+
+// getMetrics scrapes the metrics from the url
+func (suite *ApplicationTestSuite) getMetrics(url string) string {
+	var resp *http.Response
+	err := Eventually(func() error {
+		var err error
+		resp, err = suite.client.Get(url)
+		return err
+	}, 10, 500*time.Millisecond)
+	assert.NoError(suite.T(), err, "unable to get the metrics")
+	defer resp.Body.Close()
+
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(suite.T(), err, "unable to read the metrics")
+	return string(body)
+}
+
+func (suite *ApplicationTestSuite) TestMetricsAreServedForRequestsAndSubmissions() {
+	suite.startApp()
+	suite.assertPageReturns200(fmt.Sprintf("http://localhost:%d/", suite.Port))
+
+	contactForm := &domain.ContactForm{Name: "Bob", Email: "not an email"}
+	jsonBody, err := json.Marshal(contactForm)
+	assert.NoError(suite.T(), err, "unable to marshal contact form request")
+	resp, err := suite.client.Post(fmt.Sprintf("http://localhost:%d/contact", suite.Port), "application/json", strings.NewReader(string(jsonBody)))
+	assert.NoError(suite.T(), err, "unable to post the contact form")
+	resp.Body.Close()
+
+	metrics := suite.getMetrics(fmt.Sprintf("http://localhost:%d%s", suite.Port, metricsPath))
+	assert.Contains(suite.T(), metrics, `website_http_requests_total{method="GET",route="/",status="200"}`)
+	assert.Contains(suite.T(), metrics, `website_http_requests_total{method="POST",route="/contact",status="400"} 1`)
+	assert.Contains(suite.T(), metrics, `website_contact_submissions_total{outcome="invalid"} 1`)
+	assert.Contains(suite.T(), metrics, "go_goroutines")
+}
+
+func (suite *ApplicationTestSuite) TestMetricsAreNotServedAlongsideTheSiteByDefault() {
+	defaults, err := services.NewLayeredConfigService(newLogger(), validConfigFlags).TryLoadConfig()
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+	assert.True(suite.T(), defaults.MetricsEnabled)
+	assert.NotZero(suite.T(), defaults.MetricsPort, "metrics should have their own port by default")
+
+	// Serve them on a free port rather than the default, which may be in use
+	suite.AppContext.Config.MetricsEnabled = defaults.MetricsEnabled
+	suite.AppContext.Config.MetricsPort = suite.getFreePort()
+	suite.startApp()
+
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d%s", suite.Port, metricsPath), http.StatusNotFound, func(_ *http.Response) error {
+		return nil
+	})
+}
+
+func (suite *ApplicationTestSuite) TestMetricsCanBeServedOnTheirOwnPort() {
+	suite.AppContext.Config.MetricsEnabled = true
+	suite.AppContext.Config.MetricsPort = suite.getFreePort()
+	suite.startApp()
+
+	// The metrics aren't served alongside the site
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d%s", suite.Port, metricsPath), 404, func(_ *http.Response) error {
+		return nil
+	})
+
+	metrics := suite.getMetrics(fmt.Sprintf("http://localhost:%d%s", suite.AppContext.Config.MetricsPort, metricsPath))
+	assert.Contains(suite.T(), metrics, `status="404"`)
+}
+
+// callerTraceID is the trace of the traceparent header sent by tracedRequestSpan
+const callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+// tracedRequestSpan gets the contact page as a caller with a trace of its own, returning the span the request was traced
+// with
+func (suite *ApplicationTestSuite) tracedRequestSpan() sdktrace.ReadOnlySpan {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	suite.startApp()
+
+	err := Eventually(func() error {
+		request, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/contact", suite.Port), nil)
+		assert.NoError(suite.T(), err, "unable to construct get request")
+		request.Header.Set("traceparent", "00-"+callerTraceID+"-00f067aa0ba902b7-01")
+
+		resp, err := suite.client.Do(request)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}, 10, 500*time.Millisecond)
+	assert.NoError(suite.T(), err, "unable to get the contact page")
+
+	// The span ends once the response has been sent
+	var requestSpan sdktrace.ReadOnlySpan
+	Eventually(func() error {
+		for _, span := range recorder.Ended() {
+			if span.Name() == "GET /contact" {
+				requestSpan = span
+				return nil
+			}
+		}
+		return errors.New("the request span hasn't ended")
+	}, 10, 100*time.Millisecond)
+	return requestSpan
+}
+
+func (suite *ApplicationTestSuite) TestRequestsStartANewTraceLinkedToTheCallers() {
+	requestSpan := suite.tracedRequestSpan()
+
+	if assert.NotNil(suite.T(), requestSpan, "the request was not traced") {
+		assert.NotEqual(suite.T(), callerTraceID, requestSpan.SpanContext().TraceID().String())
+		assert.False(suite.T(), requestSpan.Parent().IsValid(), "the request span should be a root span")
+		if assert.Len(suite.T(), requestSpan.Links(), 1) {
+			assert.Equal(suite.T(), callerTraceID, requestSpan.Links()[0].SpanContext.TraceID().String())
+		}
+		assert.Contains(suite.T(), requestSpan.Attributes(), attribute.Int("http.response.status_code", 200))
+	}
+}
+
+func (suite *ApplicationTestSuite) TestRequestsContinueTheCallersTraceWhenItIsTrusted() {
+	suite.AppContext.Config.TracingTrustIncoming = true
+	requestSpan := suite.tracedRequestSpan()
+
+	if assert.NotNil(suite.T(), requestSpan, "the request was not traced") {
+		assert.Equal(suite.T(), callerTraceID, requestSpan.SpanContext().TraceID().String())
+		assert.Empty(suite.T(), requestSpan.Links())
+	}
+}
+
+func (suite *ApplicationTestSuite) TestErrorPagesShowTheRequestID() {
+	suite.startApp()
+
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d/does-not-exist", suite.Port), 404, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		requestID := resp.Header.Get("X-Request-ID")
+		assert.NotEmpty(suite.T(), requestID, "no request ID was returned")
+		assert.Contains(suite.T(), string(body), "Reference: "+requestID)
+		return nil
+	})
+}
+
+func (suite *ApplicationTestSuite) TestEveryScriptIsGivenTheNonceAllowedByThePolicy() {
+	suite.AppContext.Config.CSP = testCSPConfig
+	suite.startApp()
+
+	nonceAttribute := regexp.MustCompile(`<script([^>]*)>`)
+	for _, path := range []string{"/", "/contact", "/does-not-exist"} {
+		err := Eventually(func() error {
+			resp, err := suite.client.Get(fmt.Sprintf("http://localhost:%d%s", suite.Port, path))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+
+			policy := resp.Header.Get(domain.CspHeader)
+			nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(policy)
+			if !assert.Len(suite.T(), nonce, 2, "%s: the policy has no nonce", path) {
+				return nil
+			}
+
+			scripts := nonceAttribute.FindAllStringSubmatch(string(body), -1)
+			assert.NotEmpty(suite.T(), scripts, "%s: no scripts found", path)
+			for _, script := range scripts {
+				assert.Contains(suite.T(), script[1], `nonce="`+nonce[1]+`"`, "%s: a script is missing the nonce", path)
+			}
+			return nil
+		}, 10, 2*time.Second)
+		assert.NoError(suite.T(), err, "unable to get %s", path)
+	}
+}
+
+func (suite *ApplicationTestSuite) TestStylesheetsAreServedAtFingerprintedURLsWhichAreCachedForever() {
+	suite.startApp()
+
+	stylesheet := regexp.MustCompile(`href="(/css/styles\.[0-9a-f]{12}\.css)" integrity="(sha384-[^"]+)"`)
+	var match []string
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d/", suite.Port), http.StatusOK, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		match = stylesheet.FindStringSubmatch(string(body))
+		return nil
+	})
+	if !assert.Len(suite.T(), match, 3, "the page doesn't link the fingerprinted stylesheet") {
+		return
+	}
+
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d%s", suite.Port, match[1]), http.StatusOK, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		integrity := sha512.Sum384(body)
+		assert.Equal(suite.T(), immutableCacheControl, resp.Header.Get("Cache-Control"))
+		assert.Equal(suite.T(), "sha384-"+base64.StdEncoding.EncodeToString(integrity[:]), html.UnescapeString(match[2]))
+		return nil
+	})
+
+	// The plain path is still served, but is checked with the server before it's used
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d/css/styles.css", suite.Port), http.StatusOK, func(resp *http.Response) error {
+		assert.Equal(suite.T(), revalidateCacheControl, resp.Header.Get("Cache-Control"))
+		return nil
+	})
+}
+
+func (suite *ApplicationTestSuite) TestTheFrontendDirOverridesTheEmbeddedFiles() {
+	overrideDir, err := ioutil.TempDir("", "frontend")
+	assert.NoError(suite.T(), err, "unable to create temp dir")
+	defer os.RemoveAll(overrideDir)
+
+	writeFrontendOverride(suite.T(), overrideDir, "views/privacy.html", "An overridden privacy notice")
+	writeFrontendOverride(suite.T(), overrideDir, "public/css/styles.css", "body { color: #333; }")
+	suite.AppContext.Config.FrontendDir = overrideDir
+	suite.startApp()
+
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d/privacy", suite.Port), http.StatusOK, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		assert.Equal(suite.T(), "An overridden privacy notice", string(body))
+		return nil
+	})
+
+	// The other pages are still embedded, and link the overridden stylesheet
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d/", suite.Port), http.StatusOK, func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		assert.Contains(suite.T(), string(body), `href="/css/styles.76456d98f075.css"`)
+		return nil
+	})
+}
+
+func (suite *ApplicationTestSuite) TestPagesAndPublicFilesAreCompressed() {
+	suite.AppContext.Config.Compression = &domain.CompressionConfig{Enabled: true, MinSize: 1024, Precompress: true}
+	suite.startApp()
+
+	for _, path := range []string{"/", "/does-not-exist", "/css/styles.css"} {
+		err := Eventually(func() error {
+			request, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d%s", suite.Port, path), nil)
+			if err != nil {
+				return err
+			}
+			request.Header.Set("Accept-Encoding", "gzip, br")
+
+			resp, err := suite.client.Do(request)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(suite.T(), "br", resp.Header.Get("Content-Encoding"), "%s isn't compressed", path)
+			assert.Equal(suite.T(), "Accept-Encoding", resp.Header.Get("Vary"), path)
+			return nil
+		}, 10, 2*time.Second)
+		assert.NoError(suite.T(), err, "unable to get %s", path)
+	}
+}
+
+// postBrowserReport sends a report to the reports endpoint as a browser would, returning the status
+func (suite *ApplicationTestSuite) postBrowserReport(contentType string, body string) int {
+	status := 0
+	err := Eventually(func() error {
+		resp, err := suite.client.Post(fmt.Sprintf("http://localhost:%d%s", suite.Port, reportsPath), contentType, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		return nil
+	}, 10, 2*time.Second)
+	assert.NoError(suite.T(), err, "unable to send a report")
+	return status
+}
+
+func (suite *ApplicationTestSuite) TestBrowserReportsAreCountedAndShownInTheAdminArea() {
+	suite.AppContext.Config.Admin = &domain.AdminConfig{Username: "admin", Password: "admin-password"}
+	suite.startApp()
+
+	violation := `{"csp-report": {
+		"document-uri": "https://test.seacitysoftware.com/contact?email=bob@someemail.com",
+		"effective-directive": "script-src-elem",
+		"blocked-uri": "https://evil.example.com/steal.js",
+		"disposition": "enforce"
+	}}`
+	assert.Equal(suite.T(), http.StatusNoContent, suite.postBrowserReport(services.MediaTypeCSPReport, violation))
+	assert.Equal(suite.T(), http.StatusNoContent, suite.postBrowserReport(services.MediaTypeCSPReport, violation))
+	assert.Equal(suite.T(), http.StatusNoContent, suite.postBrowserReport(services.MediaTypeReports,
+		`[{"type": "network-error", "url": "https://test.seacitysoftware.com/", "body": {"type": "dns.unreachable"}}]`))
+	assert.Equal(suite.T(), http.StatusBadRequest, suite.postBrowserReport("text/plain", "hello"))
+
+	metrics := suite.getMetrics(fmt.Sprintf("http://localhost:%d%s", suite.Port, metricsPath))
+	assert.Contains(suite.T(), metrics, `website_browser_reports_total{category="script-src-elem",outcome="counted",type="csp"} 1`)
+	assert.Contains(suite.T(), metrics, `website_browser_reports_total{category="script-src-elem",outcome="duplicate",type="csp"} 1`)
+	assert.Contains(suite.T(), metrics, `website_browser_reports_total{category="dns",outcome="counted",type="nel"} 1`)
+
+	adminURL := fmt.Sprintf("http://localhost:%d%s", suite.Port, adminReportsPath)
+	resp, err := suite.client.Get(adminURL)
+	if assert.NoError(suite.T(), err) {
+		resp.Body.Close()
+		assert.Equal(suite.T(), http.StatusUnauthorized, resp.StatusCode)
+		assert.Contains(suite.T(), resp.Header.Get("WWW-Authenticate"), "Basic")
+	}
+
+	request, _ := http.NewRequest("GET", adminURL, nil)
+	request.SetBasicAuth("admin", "admin-password")
+	resp, err = suite.client.Do(request)
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(suite.T(), "no-store", resp.Header.Get("Cache-Control"))
+	assert.Contains(suite.T(), string(body), "script-src-elem")
+	assert.Contains(suite.T(), string(body), "https://evil.example.com")
+	assert.Contains(suite.T(), string(body), "dns.unreachable")
+	assert.NotContains(suite.T(), string(body), "someemail.com")
+}
+
+func (suite *ApplicationTestSuite) TestAClientGuessingTheAdminPasswordIsLockedOut() {
+	suite.AppContext.Config.Admin = &domain.AdminConfig{Username: "admin", Password: "admin-password"}
+	suite.startApp()
+
+	adminURL := fmt.Sprintf("http://localhost:%d%s", suite.Port, adminReportsPath)
+	login := func(username string, password string) *http.Response {
+		request, _ := http.NewRequest("GET", adminURL, nil)
+		request.SetBasicAuth(username, password)
+		var resp *http.Response
+		err := Eventually(func() error {
+			var err error
+			resp, err = suite.client.Do(request)
+			return err
+		}, 10, 500*time.Millisecond)
+		assert.NoError(suite.T(), err, "unable to get the admin area")
+		resp.Body.Close()
+		return resp
+	}
+
+	// Guessing other usernames doesn't lock out the admin
+	for i := 0; i < adminMaxFailedLogins; i++ {
+		assert.Equal(suite.T(), http.StatusUnauthorized, login("root", fmt.Sprintf("guess-%d", i)).StatusCode)
+	}
+	assert.Equal(suite.T(), http.StatusOK, login("admin", "admin-password").StatusCode)
+
+	for i := 0; i < adminMaxFailedLogins; i++ {
+		assert.Equal(suite.T(), http.StatusUnauthorized, login("admin", fmt.Sprintf("guess-%d", i)).StatusCode)
+	}
+
+	// Even the right password is refused until the lock out ends
+	resp := login("admin", "admin-password")
+	assert.Equal(suite.T(), http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(suite.T(), resp.Header.Get("Retry-After"))
+}
+
+func (suite *ApplicationTestSuite) TestTheAdminAreaIsNotServedWithoutAPassword() {
+	suite.startApp()
+
+	adminURL := fmt.Sprintf("http://localhost:%d%s", suite.Port, adminReportsPath)
+	suite.assertPageHasStatusCallback(adminURL, http.StatusNotFound, func(_ *http.Response) error {
+		return nil
+	})
+}
+
+func (suite *ApplicationTestSuite) TestBrowsersAreAskedToReportNetworkErrors() {
+	suite.AppContext.Config.Reporting = &domain.ReportingConfig{RateLimit: 30, MaxReports: 100, NelMaxAge: 24 * time.Hour}
+	suite.startApp()
+
+	suite.assertPageHasStatusCallback(fmt.Sprintf("http://localhost:%d/", suite.Port), http.StatusOK, func(resp *http.Response) error {
+		assert.Equal(suite.T(), `{"report_to":"network-errors","max_age":86400}`, resp.Header.Get("NEL"))
+		assert.Equal(suite.T(), `{"group":"network-errors","max_age":86400,"endpoints":[{"url":"https://test.seacitysoftware.com/reports"}]}`,
+			resp.Header.Get("Report-To"))
+		return nil
+	})
+}
+
+// lockedBuffer is a bytes.Buffer which can be written by the app while the test reads it
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.String()
+}
+
+func (suite *ApplicationTestSuite) TestNoPersonalDataIsLoggedWhenAContactFormCantBeProcessed() {
+	logs := &lockedBuffer{}
+	logger := newLogger()
+	logger.Logger.Out = logs
+	suite.AppContext.Logger = logger
+
+	contactFormService := new(mocks.ContactFormService)
+	contactFormService.On("Process", mock.Anything, mock.Anything).Return(errors.New("MessageRejected: Email address is not verified: bob@someemail.com"))
+	suite.AppContext.ContactFormService = contactFormService
+	suite.startApp()
+
+	contactURL := fmt.Sprintf("http://localhost:%d/contact", suite.Port)
+	submit := func(form url.Values) {
+		err := Eventually(func() error {
+			resp, err := suite.client.PostForm(contactURL, form)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			return nil
+		}, 10, 2*time.Second)
+		assert.NoError(suite.T(), err, "unable to submit the contact form")
+	}
+
+	form := url.Values{
+		"name":    {"Bob Bobson"},
+		"email":   {"bob@someemail.com"},
+		"company": {"Bobcorp"},
+		"number":  {"07700 900123"},
+		"message": {"Please call me back"},
+	}
+	submit(form)
+
+	// An invalid submission
+	form.Set("email", "bob at someemail.com")
+	submit(form)
+
+	err := Eventually(func() error {
+		if strings.Count(logs.String(), "Handled request") < 2 {
+			return errors.New("the requests haven't been logged")
+		}
+		return nil
+	}, 10, 200*time.Millisecond)
+	assert.NoError(suite.T(), err)
+
+	output := logs.String()
+	assert.Contains(suite.T(), output, "Unable to process contact form")
+	assert.Contains(suite.T(), output, "Received an invalid contact form")
+	for _, personalData := range []string{"Bob Bobson", "bob@someemail.com", "bob at someemail.com", "Bobcorp", "07700 900123", "Please call me back"} {
+		assert.NotContains(suite.T(), output, personalData)
+	}
+}
+
 func TestRunApplicationTestSuite(t *testing.T) {
 	suite.Run(t, new(ApplicationTestSuite))
 }
@@ -153,8 +1140,6 @@ func (suite *ApplicationTestSuite) getFreePort() int {
 func (suite *ApplicationTestSuite) createTestAppContext(port int, contactFormService services.ContactFormService, recaptchaService services.RecaptchaService) *AppContext {
 	logger := newLogger()
 
-	pathToFrontend := getAbsolutePathOrPanic(TemplatesDir, logger)
-
 	emailConfig := &domain.EmailConfig{
 		Sender:          "",
 		Recipient:       "",
@@ -166,11 +1151,29 @@ func (suite *ApplicationTestSuite) createTestAppContext(port int, contactFormSer
 
 	return &AppContext{
 		Config: &domain.AppConfig{
-			HttpPort:    port,
-			EmailConfig: emailConfig,
+			HttpPort:          port,
+			ShutdownTimeout:   5 * time.Second,
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       time.Minute,
+			BodyLimit:         4 * 1024,
+			UploadBodyLimit:   64 * 1024,
+			EmailConfig:       emailConfig,
+			Site: &domain.SiteConfig{
+				Name:             "Test Site",
+				BaseURL:          "https://test.seacitysoftware.com",
+				CompanyNumber:    "00000000",
+				CopyrightHolder:  "Test Holder Ltd",
+				ContactEmail:     "test@seacitysoftware.com",
+				AnalyticsIDs:     []string{"UA-TEST-1"},
+				RecaptchaSiteKey: "test-site-key",
+			},
+			MetricsEnabled: true,
+			TLS:            &domain.TLSConfig{},
 		},
-		TemplateDir:        pathToFrontend,
 		Logger:             logger,
+		Metrics:            services.NewPrometheusMetrics(),
 		ContactFormService: contactFormService,
 		RecaptchaService:   recaptchaService,
 	}
@@ -186,7 +1189,7 @@ func (suite *ApplicationTestSuite) assertPageReturns200(url string) {
 
 func (suite *ApplicationTestSuite) assertPageHasStatusCallback(url string, expectedStatusCode int, callback func(resp *http.Response) error) {
 	err := Eventually(func() error {
-		resp, err := http.Get(url)
+		resp, err := suite.client.Get(url)
 		if err != nil {
 			return err
 		}
@@ -218,3 +1221,73 @@ func Eventually(fx func() error, maxAttempts int, wait time.Duration) error {
 	}
 	return errors.New("max attempts exceeded")
 }
+
+func TestTheSesClientSendsToTheConfiguredEndpointWithTheStaticCredentials(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var sent url.Values
+	authorization := ""
+	traceparent := ""
+	sesServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		traceparent = r.Header.Get("traceparent")
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sent = r.PostForm
+		w.Header().Set(echo.HeaderContentType, "text/xml")
+		fmt.Fprint(w, `<SendEmailResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/">`+
+			`<SendEmailResult><MessageId>test-message-id</MessageId></SendEmailResult>`+
+			`<ResponseMetadata><RequestId>test-request-id</RequestId></ResponseMetadata></SendEmailResponse>`)
+	}))
+	defer sesServer.Close()
+
+	sesClient, err := newSesClient(&domain.EmailConfig{
+		AwsSesRegion:    "eu-west-1",
+		AwsSesAccessKey: "test-access-key",
+		AwsSesSecretKey: "test-secret-key",
+		AwsSesEndpoint:  sesServer.URL,
+	}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+	output, err := sesClient.SendEmailWithContext(ctx, &awsses.SendEmailInput{
+		Source:      awssdk.String("website@test.seacitysoftware.com"),
+		Destination: &awsses.Destination{ToAddresses: []*string{awssdk.String("contact@test.seacitysoftware.com")}},
+		Message: &awsses.Message{
+			Subject: &awsses.Content{Data: awssdk.String("Test subject")},
+			Body:    &awsses.Body{Text: &awsses.Content{Data: awssdk.String("Test body")}},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "test-message-id", *output.MessageId)
+	assert.Equal(t, "SendEmail", sent.Get("Action"))
+	assert.Equal(t, "website@test.seacitysoftware.com", sent.Get("Source"))
+	assert.Contains(t, authorization, "Credential=test-access-key/")
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String(), "the request to SES wasn't traced")
+}
+
+func TestTheTraceContextIsNotSentToTheErrorTracker(t *testing.T) {
+	appConfig := &domain.AppConfig{
+		TracingNoPropagateHosts: []string{"www.google.com"},
+		ErrorReporting:          &domain.ErrorReportingConfig{Dsn: domain.Secret("https://key@errors.example.com/1")},
+	}
+
+	assert.Equal(t, []string{"www.google.com", "errors.example.com"}, tracingNoPropagateHosts(appConfig))
+}
+
+func TestTheSesClientUsesTheDefaultCredentialsWhenNoKeysAreConfigured(t *testing.T) {
+	awsConfig := newSesConfig(&domain.EmailConfig{AwsSesRegion: "eu-west-1"})
+
+	assert.Nil(t, awsConfig.Credentials)
+	assert.Equal(t, "eu-west-1", *awsConfig.Region)
+	assert.Nil(t, awsConfig.Endpoint)
+}
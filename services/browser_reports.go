@@ -0,0 +1,488 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/pkg/errors"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of report sent by browsers
+const (
+	BrowserReportTypeCSP = "csp"
+	BrowserReportTypeNEL = "nel"
+)
+
+// Media types browsers send reports as
+const (
+	// MediaTypeCSPReport is the media type of the violation reports sent to a policy's report-uri
+	MediaTypeCSPReport = "application/csp-report"
+
+	// MediaTypeReports is the media type of the batches of reports sent by the Reporting API
+	MediaTypeReports = "application/reports+json"
+)
+
+// Types of report in a Reporting API batch which are counted, the rest are ignored
+const (
+	reportingTypeCSPViolation = "csp-violation"
+	reportingTypeNetworkError = "network-error"
+)
+
+// BrowserReportCategoryOther is the category of reports whose directive or class of network error isn't a known one,
+// so junk sent to the endpoint can't create any number of metrics series
+const BrowserReportCategoryOther = "other"
+
+const (
+	UnsupportedBrowserReportError = "reports must be sent as application/csp-report or application/reports+json"
+	InvalidBrowserReportError     = "the report could not be parsed"
+)
+
+const (
+	// maxReportedPathLength is the longest document path kept, longer paths are cut short
+	maxReportedPathLength = 200
+
+	// maxReportDetailLength is the longest detail kept, longer details are replaced with BrowserReportCategoryOther
+	maxReportDetailLength = 100
+
+	// browserReportRateLimitWindow is the window each client's rate limit applies to
+	browserReportRateLimitWindow = time.Minute
+
+	// maxTrackedReportClients is the most clients rate limited at once, the window started longest ago is forgotten
+	// to make room for a new client
+	maxTrackedReportClients = 10000
+
+	// maxTrackedClientReports is the most reports de-duplicated at once, the report counted longest ago is forgotten
+	// to make room for a new one
+	maxTrackedClientReports = 100000
+)
+
+// cspDirectives are the directives a policy can be violated by
+var cspDirectives = map[string]bool{
+	"default-src":               true,
+	"script-src":                true,
+	"script-src-elem":           true,
+	"script-src-attr":           true,
+	"style-src":                 true,
+	"style-src-elem":            true,
+	"style-src-attr":            true,
+	"img-src":                   true,
+	"font-src":                  true,
+	"connect-src":               true,
+	"frame-src":                 true,
+	"child-src":                 true,
+	"worker-src":                true,
+	"manifest-src":              true,
+	"media-src":                 true,
+	"object-src":                true,
+	"base-uri":                  true,
+	"form-action":               true,
+	"frame-ancestors":           true,
+	"require-trusted-types-for": true,
+	"trusted-types":             true,
+}
+
+// nelErrorClasses are the classes of network error, the first part of the error type, e.g. tcp in tcp.timed_out
+var nelErrorClasses = map[string]bool{
+	"ok":        true,
+	"dns":       true,
+	"tcp":       true,
+	"tls":       true,
+	"http":      true,
+	"h2":        true,
+	"h3":        true,
+	"quic":      true,
+	"abandoned": true,
+	"unknown":   true,
+}
+
+// BrowserReport is a CSP violation or network error reported by a browser, reduced to what's needed to count it. It
+// holds no personal data, URLs are reduced to their path or origin
+type BrowserReport struct {
+	// Type is BrowserReportTypeCSP or BrowserReportTypeNEL
+	Type string
+
+	// Category is the violated CSP directive or the class of network error, e.g. tcp
+	Category string
+
+	// Detail is the origin of what a CSP blocked, or a keyword such as inline or eval, or the network error, e.g.
+	// tcp.timed_out
+	Detail string
+
+	// DocumentPath is the path of the page the report is about
+	DocumentPath string
+
+	// Disposition is whether a CSP violation was blocked, enforce, or only reported, report
+	Disposition string
+}
+
+// legacyCSPReport is the violation report sent to a policy's report-uri
+type legacyCSPReport struct {
+	Report *struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is a report in a batch sent by the Reporting API, the fields of the body depend on the type
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	Body struct {
+		// CSP violations
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		Disposition        string `json:"disposition"`
+
+		// Network errors
+		Type string `json:"type"`
+	} `json:"body"`
+}
+
+// ParseBrowserReports parses the reports in a request body sent by a browser, the media type is the request's
+// Content-Type. Reports sent as application/json are parsed as whichever format they're in
+func ParseBrowserReports(contentType string, body []byte) ([]BrowserReport, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errors.New(UnsupportedBrowserReportError)
+	}
+
+	if mediaType == "application/json" {
+		mediaType = MediaTypeCSPReport
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			mediaType = MediaTypeReports
+		}
+	}
+
+	switch mediaType {
+	case MediaTypeCSPReport:
+		legacyReport := &legacyCSPReport{}
+		err = json.Unmarshal(body, legacyReport)
+		if err != nil || legacyReport.Report == nil {
+			return nil, errors.New(InvalidBrowserReportError)
+		}
+
+		directive := legacyReport.Report.EffectiveDirective
+		if len(directive) <= 0 {
+			// Older browsers report the whole directive, including its sources
+			directive = strings.SplitN(strings.TrimSpace(legacyReport.Report.ViolatedDirective), " ", 2)[0]
+		}
+		return []BrowserReport{newCSPReport(legacyReport.Report.DocumentURI, directive, legacyReport.Report.BlockedURI, legacyReport.Report.Disposition)}, nil
+	case MediaTypeReports:
+		batch := []reportingAPIReport{}
+		err = json.Unmarshal(body, &batch)
+		if err != nil {
+			return nil, errors.New(InvalidBrowserReportError)
+		}
+
+		reports := []BrowserReport{}
+		for _, report := range batch {
+			switch report.Type {
+			case reportingTypeCSPViolation:
+				reports = append(reports, newCSPReport(report.Body.DocumentURL, report.Body.EffectiveDirective, report.Body.BlockedURL, report.Body.Disposition))
+			case reportingTypeNetworkError:
+				reports = append(reports, newNELReport(report.URL, report.Body.Type))
+			}
+		}
+		return reports, nil
+	default:
+		return nil, errors.New(UnsupportedBrowserReportError)
+	}
+}
+
+// newCSPReport creates the report of a CSP violation, reducing the URLs to what's needed to count it
+func newCSPReport(documentURL string, directive string, blockedURL string, disposition string) BrowserReport {
+	category := strings.ToLower(directive)
+	if !cspDirectives[category] {
+		category = BrowserReportCategoryOther
+	}
+
+	if disposition != "enforce" && disposition != "report" {
+		disposition = ""
+	}
+
+	return BrowserReport{
+		Type:         BrowserReportTypeCSP,
+		Category:     category,
+		Detail:       blockedOrigin(blockedURL),
+		DocumentPath: reportedPath(documentURL),
+		Disposition:  disposition,
+	}
+}
+
+// newNELReport creates the report of a network error
+func newNELReport(documentURL string, errorType string) BrowserReport {
+	category := strings.SplitN(errorType, ".", 2)[0]
+	if !nelErrorClasses[category] {
+		category = BrowserReportCategoryOther
+	}
+
+	detail := errorType
+	if len(detail) > maxReportDetailLength || strings.Trim(detail, "abcdefghijklmnopqrstuvwxyz0123456789._") != "" {
+		detail = BrowserReportCategoryOther
+	}
+
+	return BrowserReport{
+		Type:         BrowserReportTypeNEL,
+		Category:     category,
+		Detail:       detail,
+		DocumentPath: reportedPath(documentURL),
+	}
+}
+
+// blockedOrigin reduces what a CSP blocked to its origin, or its scheme if it has no host, e.g. data. Keywords such
+// as inline and eval are kept as they are
+func blockedOrigin(blockedURL string) string {
+	if len(blockedURL) <= 0 {
+		return ""
+	}
+
+	blocked, err := url.Parse(blockedURL)
+	if err != nil {
+		return BrowserReportCategoryOther
+	}
+
+	origin := blockedURL
+	if len(blocked.Host) > 0 {
+		origin = blocked.Scheme + "://" + blocked.Host
+	} else if len(blocked.Scheme) > 0 {
+		origin = blocked.Scheme
+	}
+
+	if len(origin) > maxReportDetailLength || strings.ContainsAny(origin, " \t\r\n") {
+		return BrowserReportCategoryOther
+	}
+	return strings.ToLower(origin)
+}
+
+// reportedPath reduces the URL of the page a report is about to its path, dropping the query which could hold
+// personal data
+func reportedPath(documentURL string) string {
+	document, err := url.Parse(documentURL)
+	if err != nil || len(document.Path) <= 0 {
+		return ""
+	}
+
+	if len(document.Path) > maxReportedPathLength {
+		return document.Path[:maxReportedPathLength]
+	}
+	return document.Path
+}
+
+// BrowserReportCount is how many times a report has been counted
+type BrowserReportCount struct {
+	BrowserReport
+
+	Count int
+
+	FirstSeen time.Time
+
+	LastSeen time.Time
+}
+
+// clientReport is a report from a particular client, the key reports are de-duplicated by
+type clientReport struct {
+	Client string
+
+	Report BrowserReport
+}
+
+// rateLimitWindow is how many reports a client has sent in the current window
+type rateLimitWindow struct {
+	client string
+
+	start time.Time
+
+	count int
+}
+
+// countedClientReport is when a client's report was last counted
+type countedClientReport struct {
+	key clientReport
+
+	lastCounted time.Time
+}
+
+// BrowserReportStore counts the reports sent by browsers in memory. The same report from the same client is only
+// counted once within the de-duplication window, each client is rate limited and the number of distinct reports
+// counted is capped. The first time a report is counted it is logged as a warning, so new violations stand out.
+// Everything tracked is kept in a list, oldest first, so the oldest entries are expired or evicted without looking
+// through the rest
+type BrowserReportStore struct {
+	logger Logger
+
+	metrics Metrics
+
+	rateLimit int
+
+	dedupeWindow time.Duration
+
+	maxReports int
+
+	// now is the current time, replaced in tests
+	now func() time.Time
+
+	mutex sync.Mutex
+
+	// counts are the counts by report, the list holds the *BrowserReportCount seen least recently first
+	counts map[BrowserReport]*list.Element
+
+	countsBySeen *list.List
+
+	// lastCounted are when each client's report was last counted, the list holds the *countedClientReport counted
+	// longest ago first
+	lastCounted map[clientReport]*list.Element
+
+	lastCountedByTime *list.List
+
+	// windows are each client's rate limit window, the list holds the *rateLimitWindow started longest ago first
+	windows map[string]*list.Element
+
+	windowsByStart *list.List
+}
+
+// NewBrowserReportStore creates a BrowserReportStore
+func NewBrowserReportStore(reportingConfig *domain.ReportingConfig, logger Logger, metrics Metrics) *BrowserReportStore {
+	return &BrowserReportStore{
+		logger:            logger,
+		metrics:           metrics,
+		rateLimit:         reportingConfig.RateLimit,
+		dedupeWindow:      reportingConfig.DedupeWindow,
+		maxReports:        reportingConfig.MaxReports,
+		now:               time.Now,
+		counts:            make(map[BrowserReport]*list.Element),
+		countsBySeen:      list.New(),
+		lastCounted:       make(map[clientReport]*list.Element),
+		lastCountedByTime: list.New(),
+		windows:           make(map[string]*list.Element),
+		windowsByStart:    list.New(),
+	}
+}
+
+// Record counts a report sent by a client, returning the outcome, e.g. BrowserReportCounted
+func (store *BrowserReportStore) Record(ctx context.Context, client string, report BrowserReport) string {
+	store.mutex.Lock()
+	outcome, isNew := store.record(client, report, store.now())
+	store.mutex.Unlock()
+
+	store.metrics.ObserveBrowserReport(report.Type, report.Category, outcome)
+
+	fields := Fields{
+		"reportType":   report.Type,
+		"category":     report.Category,
+		"detail":       report.Detail,
+		"documentPath": report.DocumentPath,
+		"disposition":  report.Disposition,
+	}
+	logger := store.logger.WithContext(ctx)
+	switch {
+	case isNew && report.Type == BrowserReportTypeCSP:
+		logger.Warn("Browser reported a new Content-Security-Policy violation", fields)
+	case isNew:
+		logger.Warn("Browser reported a new network error", fields)
+	case outcome == BrowserReportCounted:
+		logger.Debug("Browser reported a known problem", fields)
+	}
+	return outcome
+}
+
+// record applies the rate limit and de-duplication then counts the report, it is true when the report hadn't been
+// counted before. The mutex must be held
+func (store *BrowserReportStore) record(client string, report BrowserReport, now time.Time) (string, bool) {
+	store.expire(now)
+
+	windowElement, isFound := store.windows[client]
+	if !isFound {
+		if len(store.windows) >= maxTrackedReportClients {
+			oldest := store.windowsByStart.Remove(store.windowsByStart.Front()).(*rateLimitWindow)
+			delete(store.windows, oldest.client)
+		}
+		windowElement = store.windowsByStart.PushBack(&rateLimitWindow{client: client, start: now})
+		store.windows[client] = windowElement
+	}
+	window := windowElement.Value.(*rateLimitWindow)
+	if window.count >= store.rateLimit {
+		return BrowserReportRateLimited, false
+	}
+	window.count++
+
+	key := clientReport{Client: client, Report: report}
+	if _, isFound := store.lastCounted[key]; isFound {
+		return BrowserReportDuplicate, false
+	}
+
+	countElement, isCounted := store.counts[report]
+	if isCounted {
+		store.countsBySeen.MoveToBack(countElement)
+	} else {
+		if len(store.counts) >= store.maxReports {
+			leastRecent := store.countsBySeen.Remove(store.countsBySeen.Front()).(*BrowserReportCount)
+			delete(store.counts, leastRecent.BrowserReport)
+		}
+		countElement = store.countsBySeen.PushBack(&BrowserReportCount{BrowserReport: report, FirstSeen: now})
+		store.counts[report] = countElement
+	}
+	count := countElement.Value.(*BrowserReportCount)
+	count.Count++
+	count.LastSeen = now
+
+	if store.dedupeWindow > 0 {
+		if len(store.lastCounted) >= maxTrackedClientReports {
+			oldest := store.lastCountedByTime.Remove(store.lastCountedByTime.Front()).(*countedClientReport)
+			delete(store.lastCounted, oldest.key)
+		}
+		store.lastCounted[key] = store.lastCountedByTime.PushBack(&countedClientReport{key: key, lastCounted: now})
+	}
+	return BrowserReportCounted, !isCounted
+}
+
+// expire removes the rate limit windows and de-duplication entries which have ended, oldest first, stopping at the
+// first which hasn't. The mutex must be held
+func (store *BrowserReportStore) expire(now time.Time) {
+	for element := store.windowsByStart.Front(); element != nil; element = store.windowsByStart.Front() {
+		window := element.Value.(*rateLimitWindow)
+		if now.Sub(window.start) < browserReportRateLimitWindow {
+			break
+		}
+		store.windowsByStart.Remove(element)
+		delete(store.windows, window.client)
+	}
+
+	for element := store.lastCountedByTime.Front(); element != nil; element = store.lastCountedByTime.Front() {
+		counted := element.Value.(*countedClientReport)
+		if now.Sub(counted.lastCounted) < store.dedupeWindow {
+			break
+		}
+		store.lastCountedByTime.Remove(element)
+		delete(store.lastCounted, counted.key)
+	}
+}
+
+// Counts returns how many times each report has been counted, most counted first
+func (store *BrowserReportStore) Counts() []BrowserReportCount {
+	store.mutex.Lock()
+	counts := make([]BrowserReportCount, 0, len(store.counts))
+	for element := store.countsBySeen.Front(); element != nil; element = element.Next() {
+		counts = append(counts, *element.Value.(*BrowserReportCount))
+	}
+	store.mutex.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].LastSeen.After(counts[j].LastSeen)
+	})
+	return counts
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newTestBrowserReportStore creates a store whose clock is only moved by the test
+func newTestBrowserReportStore(reportingConfig *domain.ReportingConfig) (*BrowserReportStore, *time.Time) {
+	store := NewBrowserReportStore(reportingConfig, &noopLogger{}, NewPrometheusMetrics())
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+var testReportingConfig = &domain.ReportingConfig{
+	RateLimit:    10,
+	DedupeWindow: 10 * time.Minute,
+	MaxReports:   100,
+}
+
+var testCSPViolation = BrowserReport{
+	Type:         BrowserReportTypeCSP,
+	Category:     "script-src-elem",
+	Detail:       "https://evil.example.com",
+	DocumentPath: "/contact",
+	Disposition:  "enforce",
+}
+
+func TestReportUriViolationsAreParsedWithoutTheirQueries(t *testing.T) {
+	body := `{"csp-report": {
+		"document-uri": "https://www.seacitysoftware.com/contact?email=bob@someemail.com",
+		"violated-directive": "script-src-elem",
+		"effective-directive": "script-src-elem",
+		"blocked-uri": "https://evil.example.com/steal.js?email=bob@someemail.com",
+		"disposition": "enforce"
+	}}`
+
+	reports, err := ParseBrowserReports("application/csp-report", []byte(body))
+	assert.NoError(t, err)
+	assert.Equal(t, []BrowserReport{testCSPViolation}, reports)
+}
+
+func TestTheDirectiveIsTakenFromTheViolatedDirectiveOfOlderBrowsers(t *testing.T) {
+	body := `{"csp-report": {"document-uri": "https://www.seacitysoftware.com/", "violated-directive": "style-src 'self'", "blocked-uri": "inline"}}`
+
+	reports, err := ParseBrowserReports("application/json; charset=utf-8", []byte(body))
+	assert.NoError(t, err)
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, "style-src", reports[0].Category)
+		assert.Equal(t, "inline", reports[0].Detail)
+		assert.Equal(t, "/", reports[0].DocumentPath)
+	}
+}
+
+func TestReportingAPIBatchesAreParsed(t *testing.T) {
+	body := `[
+		{"type": "csp-violation", "url": "https://www.seacitysoftware.com/contact", "body": {
+			"documentURL": "https://www.seacitysoftware.com/contact?name=Bob",
+			"effectiveDirective": "script-src-elem",
+			"blockedURL": "https://evil.example.com/steal.js",
+			"disposition": "enforce"
+		}},
+		{"type": "network-error", "url": "https://www.seacitysoftware.com/services?ref=ad", "body": {
+			"type": "tcp.timed_out", "phase": "connection", "server_ip": "203.0.113.1"
+		}},
+		{"type": "deprecation", "url": "https://www.seacitysoftware.com/", "body": {}}
+	]`
+
+	reports, err := ParseBrowserReports("application/reports+json", []byte(body))
+	assert.NoError(t, err)
+	assert.Equal(t, []BrowserReport{
+		testCSPViolation,
+		{Type: BrowserReportTypeNEL, Category: "tcp", Detail: "tcp.timed_out", DocumentPath: "/services"},
+	}, reports)
+}
+
+func TestUnknownDirectivesAndErrorsAreCategorisedAsOther(t *testing.T) {
+	body := `[
+		{"type": "csp-violation", "body": {"documentURL": "https://www.seacitysoftware.com/", "effectiveDirective": "made-up-src"}},
+		{"type": "network-error", "url": "https://www.seacitysoftware.com/", "body": {"type": "<script>alert(1)</script>"}}
+	]`
+
+	reports, err := ParseBrowserReports("application/reports+json", []byte(body))
+	assert.NoError(t, err)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, BrowserReportCategoryOther, reports[0].Category)
+		assert.Equal(t, BrowserReportCategoryOther, reports[1].Category)
+		assert.Equal(t, BrowserReportCategoryOther, reports[1].Detail)
+	}
+}
+
+func TestReportsInOtherFormatsAreRejected(t *testing.T) {
+	_, err := ParseBrowserReports("text/plain", []byte("hello"))
+	assert.EqualError(t, err, UnsupportedBrowserReportError)
+
+	_, err = ParseBrowserReports("application/csp-report", []byte(`{"not-a-report": {}}`))
+	assert.EqualError(t, err, InvalidBrowserReportError)
+
+	_, err = ParseBrowserReports("application/reports+json", []byte(`{`))
+	assert.EqualError(t, err, InvalidBrowserReportError)
+}
+
+func TestTheSameReportFromTheSameClientIsOnlyCountedOnceAWindow(t *testing.T) {
+	store, now := newTestBrowserReportStore(testReportingConfig)
+	ctx := context.Background()
+
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, BrowserReportDuplicate, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.2", testCSPViolation))
+
+	*now = now.Add(testReportingConfig.DedupeWindow)
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+
+	counts := store.Counts()
+	if assert.Len(t, counts, 1) {
+		assert.Equal(t, testCSPViolation, counts[0].BrowserReport)
+		assert.Equal(t, 3, counts[0].Count)
+		assert.Equal(t, *now, counts[0].LastSeen)
+		assert.Equal(t, now.Add(-testReportingConfig.DedupeWindow), counts[0].FirstSeen)
+	}
+}
+
+func TestEachClientIsRateLimited(t *testing.T) {
+	store, now := newTestBrowserReportStore(&domain.ReportingConfig{RateLimit: 2, MaxReports: 100})
+	ctx := context.Background()
+
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, BrowserReportRateLimited, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.2", testCSPViolation))
+
+	*now = now.Add(browserReportRateLimitWindow)
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, 4, store.Counts()[0].Count)
+}
+
+func TestTheReportSeenLeastRecentlyIsForgottenOnceTheMostDistinctReportsAreCounted(t *testing.T) {
+	store, now := newTestBrowserReportStore(&domain.ReportingConfig{RateLimit: 10, MaxReports: 2})
+	ctx := context.Background()
+
+	anotherViolation := testCSPViolation
+	anotherViolation.DocumentPath = "/services"
+	yetAnotherViolation := testCSPViolation
+	yetAnotherViolation.DocumentPath = "/about"
+
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	*now = now.Add(time.Second)
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", anotherViolation))
+	*now = now.Add(time.Second)
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.2", testCSPViolation))
+	*now = now.Add(time.Second)
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.2", yetAnotherViolation))
+
+	counts := store.Counts()
+	if assert.Len(t, counts, 2) {
+		assert.Equal(t, testCSPViolation, counts[0].BrowserReport)
+		assert.Equal(t, 2, counts[0].Count)
+		assert.Equal(t, yetAnotherViolation, counts[1].BrowserReport)
+	}
+}
+
+func TestTheClientRateLimitedLongestAgoIsForgottenOnceTheMostClientsAreTracked(t *testing.T) {
+	store, _ := newTestBrowserReportStore(&domain.ReportingConfig{RateLimit: 1, MaxReports: 100})
+	ctx := context.Background()
+
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+	assert.Equal(t, BrowserReportRateLimited, store.Record(ctx, "198.51.100.1", testCSPViolation))
+
+	// A flood of clients doesn't lock out new ones, it only forgets the oldest
+	for i := 0; i < maxTrackedReportClients; i++ {
+		assert.Equal(t, BrowserReportCounted, store.Record(ctx, fmt.Sprintf("client-%d", i), testCSPViolation))
+	}
+	assert.Len(t, store.windows, maxTrackedReportClients)
+	assert.Equal(t, BrowserReportCounted, store.Record(ctx, "198.51.100.1", testCSPViolation))
+}
+
+func TestReportsAreListedMostCountedFirst(t *testing.T) {
+	store, _ := newTestBrowserReportStore(testReportingConfig)
+	ctx := context.Background()
+
+	networkError := BrowserReport{Type: BrowserReportTypeNEL, Category: "dns", Detail: "dns.unreachable", DocumentPath: "/"}
+	store.Record(ctx, "198.51.100.1", networkError)
+	store.Record(ctx, "198.51.100.1", testCSPViolation)
+	store.Record(ctx, "198.51.100.2", testCSPViolation)
+
+	counts := store.Counts()
+	if assert.Len(t, counts, 2) {
+		assert.Equal(t, testCSPViolation, counts[0].BrowserReport)
+		assert.Equal(t, networkError, counts[1].BrowserReport)
+	}
+}
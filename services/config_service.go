@@ -1,23 +1,63 @@
 package services
 
 import (
+	"flag"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"github.com/adbourne/website-seacitysoftware/domain"
-	golog "log"
+	"github.com/labstack/gommon/bytes"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ConfigService interface {
 	// Loads the app config
 	LoadConfig() *domain.AppConfig
+
+	// TryLoadConfig loads and validates the app config, returning an error rather than panicking if it can't
+	TryLoadConfig() (*domain.AppConfig, error)
 }
 
 const (
+	// envVarConfigFile is the environment variable containing the path to an optional YAML or TOML config file
+	envVarConfigFile = "CONFIG_FILE"
+
+	// flagConfigFile is the command line flag containing the path to an optional YAML or TOML config file
+	flagConfigFile = "config"
+
 	// envVarHttpPort is the environment variable containing reference to the HTTP port to serve on
 	envVarHttpPort = "HTTP_PORT"
 
+	// envVarShutdownTimeout is the environment variable containing how long to wait for in flight requests when
+	// shutting down, e.g. "20s"
+	envVarShutdownTimeout = "HTTP_SHUTDOWN_TIMEOUT"
+
+	// envVarShutdownDrainDelay is the environment variable containing how long to keep serving after reporting not
+	// ready when shutting down
+	envVarShutdownDrainDelay = "HTTP_SHUTDOWN_DRAIN_DELAY"
+
+	envVarReadTimeout       = "HTTP_READ_TIMEOUT"
+	envVarReadHeaderTimeout = "HTTP_READ_HEADER_TIMEOUT"
+	envVarWriteTimeout      = "HTTP_WRITE_TIMEOUT"
+	envVarIdleTimeout       = "HTTP_IDLE_TIMEOUT"
+
+	// envVarBodyLimit is the environment variable containing the largest request body accepted by most routes
+	envVarBodyLimit = "HTTP_BODY_LIMIT"
+
+	// envVarUploadBodyLimit is the environment variable containing the largest request body accepted by routes taking
+	// form submissions and uploads
+	envVarUploadBodyLimit = "HTTP_UPLOAD_BODY_LIMIT"
+
+	// envVarTrustForwardedFor is the environment variable set to identify clients by their X-Forwarded-For header
+	// rather than the address they connected from
+	envVarTrustForwardedFor = "HTTP_TRUST_FORWARDED_FOR"
+
 	envVarFrontendDir = "FRONTEND_DIR"
 
 	// envVarEmailSender environment variable containing reference to the email address to use in the "from" field
@@ -34,69 +74,865 @@ const (
 
 	enVarAwsSesSecretKey = "AWS_SES_SECRET_KEY"
 
+	// envVarAwsSesEndpoint overrides the AWS SES endpoint, e.g. to point at a local SES stand-in
+	envVarAwsSesEndpoint = "AWS_SES_ENDPOINT"
+
 	enVarRecaptchaSecret = "RECAPTCHA_SECRET"
+
+	// envVarConfigWatch is the environment variable which enables reloading the config when the config file changes
+	envVarConfigWatch = "CONFIG_WATCH"
+
+	envVarTlsHttpsPort             = "TLS_HTTPS_PORT"
+	envVarTlsCertFile              = "TLS_CERT_FILE"
+	envVarTlsKeyFile               = "TLS_KEY_FILE"
+	envVarTlsAcmeDomains           = "TLS_ACME_DOMAINS"
+	envVarTlsAcmeEmail             = "TLS_ACME_EMAIL"
+	envVarTlsAcmeCacheDir          = "TLS_ACME_CACHE_DIR"
+	envVarTlsAcmeDirectoryURL      = "TLS_ACME_DIRECTORY_URL"
+	envVarTlsHstsMaxAge            = "TLS_HSTS_MAX_AGE"
+	envVarTlsHstsIncludeSubdomains = "TLS_HSTS_INCLUDE_SUBDOMAINS"
+	envVarTlsHstsPreload           = "TLS_HSTS_PRELOAD"
+
+	envVarMetricsEnabled = "METRICS_ENABLED"
+
+	// envVarMetricsPort is the environment variable containing the port to serve metrics on, 0 serves them alongside
+	// the site
+	envVarMetricsPort = "METRICS_PORT"
+
+	envVarTracingEnabled = "TRACING_ENABLED"
+
+	// envVarTracingEndpoint is the environment variable containing the URL of the OTLP/HTTP collector to export
+	// traces to
+	envVarTracingEndpoint = "TRACING_OTLP_ENDPOINT"
+
+	// envVarTracingTrustIncoming is the environment variable set to continue the trace of a request's traceparent
+	// header rather than starting a new one
+	envVarTracingTrustIncoming = "TRACING_TRUST_INCOMING"
+
+	// envVarTracingNoPropagateHosts is the environment variable containing the comma separated hosts outbound requests
+	// don't send the trace context to
+	envVarTracingNoPropagateHosts = "TRACING_NO_PROPAGATE_HOSTS"
+
+	envVarLogLevel          = "LOG_LEVEL"
+	envVarLogFormat         = "LOG_FORMAT"
+	envVarLogFile           = "LOG_FILE"
+	envVarLogFileMaxSize    = "LOG_FILE_MAX_MEGABYTES"
+	envVarLogFileMaxBackups = "LOG_FILE_MAX_BACKUPS"
+	envVarLogSyslogAddress  = "LOG_SYSLOG_ADDRESS"
+
+	// envVarLogOutputs is a comma separated list of the outputs to log to
+	envVarLogOutputs = "LOG_OUTPUTS"
+
+	// envVarLogRedactFields is a comma separated list of the fields masked on every line
+	envVarLogRedactFields = "LOG_REDACT_FIELDS"
+
+	// envVarLogFieldLevels is a comma separated list of field:level pairs
+	envVarLogFieldLevels = "LOG_FIELD_LEVELS"
+
+	envVarLogDetectPersonalData = "LOG_DETECT_PERSONAL_DATA"
+
+	envVarCspEnabled    = "CSP_ENABLED"
+	envVarCspReportOnly = "CSP_REPORT_ONLY"
+	envVarCspReportURI  = "CSP_REPORT_URI"
+
+	// The sources allowed by each CSP fetch directive are comma separated lists
+	envVarCspDefaultSrc = "CSP_DEFAULT_SRC"
+	envVarCspScriptSrc  = "CSP_SCRIPT_SRC"
+	envVarCspStyleSrc   = "CSP_STYLE_SRC"
+	envVarCspImgSrc     = "CSP_IMG_SRC"
+	envVarCspFontSrc    = "CSP_FONT_SRC"
+	envVarCspConnectSrc = "CSP_CONNECT_SRC"
+	envVarCspFrameSrc   = "CSP_FRAME_SRC"
+
+	envVarSecurityHeadersFrameOptions              = "SECURITY_HEADERS_FRAME_OPTIONS"
+	envVarSecurityHeadersContentTypeOptions        = "SECURITY_HEADERS_CONTENT_TYPE_OPTIONS"
+	envVarSecurityHeadersXSSProtection             = "SECURITY_HEADERS_XSS_PROTECTION"
+	envVarSecurityHeadersReferrerPolicy            = "SECURITY_HEADERS_REFERRER_POLICY"
+	envVarSecurityHeadersPermissionsPolicy         = "SECURITY_HEADERS_PERMISSIONS_POLICY"
+	envVarSecurityHeadersCrossOriginOpenerPolicy   = "SECURITY_HEADERS_CROSS_ORIGIN_OPENER_POLICY"
+	envVarSecurityHeadersCrossOriginResourcePolicy = "SECURITY_HEADERS_CROSS_ORIGIN_RESOURCE_POLICY"
+
+	// envVarSecurityHeadersOverrides is a | separated list of per path overrides, e.g. /admin Referrer-Policy=no-referrer
+	envVarSecurityHeadersOverrides = "SECURITY_HEADERS_OVERRIDES"
+
+	envVarErrorReportingDsn         = "ERROR_REPORTING_DSN"
+	envVarErrorReportingEnvironment = "ERROR_REPORTING_ENVIRONMENT"
+	envVarErrorReportingRelease     = "ERROR_REPORTING_RELEASE"
+	envVarErrorReportingSampleRate  = "ERROR_REPORTING_SAMPLE_RATE"
+	envVarErrorReportingRateLimit   = "ERROR_REPORTING_RATE_LIMIT"
+
+	envVarReportingRateLimit    = "REPORTING_RATE_LIMIT"
+	envVarReportingDedupeWindow = "REPORTING_DEDUPE_WINDOW"
+	envVarReportingMaxReports   = "REPORTING_MAX_REPORTS"
+	envVarReportingNelMaxAge    = "REPORTING_NEL_MAX_AGE"
+
+	envVarAdminUsername = "ADMIN_USERNAME"
+	envVarAdminPassword = "ADMIN_PASSWORD"
+
+	envVarCompressionEnabled     = "COMPRESSION_ENABLED"
+	envVarCompressionMinSize     = "COMPRESSION_MIN_SIZE"
+	envVarCompressionPrecompress = "COMPRESSION_PRECOMPRESS"
+
+	envVarSiteName            = "SITE_NAME"
+	envVarSiteBaseURL         = "SITE_BASE_URL"
+	envVarSiteCompanyNumber   = "SITE_COMPANY_NUMBER"
+	envVarSiteCopyrightHolder = "SITE_COPYRIGHT_HOLDER"
+	envVarSiteContactEmail    = "SITE_CONTACT_EMAIL"
+
+	// envVarSiteAnalyticsIDs is a comma separated list of Google Analytics IDs
+	envVarSiteAnalyticsIDs = "SITE_ANALYTICS_IDS"
+
+	envVarRecaptchaSiteKey = "RECAPTCHA_SITE_KEY"
+)
+
+// Config keys match the fields named in validation reports
+const (
+	configKeyHttpPort                                 = domain.FieldHttpPort
+	configKeyShutdownTimeout                          = domain.FieldShutdownTimeout
+	configKeyShutdownDrainDelay                       = domain.FieldShutdownDrainDelay
+	configKeyReadTimeout                              = domain.FieldReadTimeout
+	configKeyReadHeaderTimeout                        = domain.FieldReadHeaderTimeout
+	configKeyWriteTimeout                             = domain.FieldWriteTimeout
+	configKeyIdleTimeout                              = domain.FieldIdleTimeout
+	configKeyBodyLimit                                = domain.FieldBodyLimit
+	configKeyUploadBodyLimit                          = domain.FieldUploadBodyLimit
+	configKeyTrustForwardedFor                        = domain.FieldTrustForwardedFor
+	configKeyMetricsEnabled                           = domain.FieldMetricsEnabled
+	configKeyMetricsPort                              = domain.FieldMetricsPort
+	configKeyTracingEnabled                           = domain.FieldTracingEnabled
+	configKeyTracingEndpoint                          = domain.FieldTracingEndpoint
+	configKeyTracingTrustIncoming                     = domain.FieldTracingTrustIncoming
+	configKeyTracingNoPropagateHosts                  = domain.FieldTracingNoPropagateHosts
+	configKeyFrontendDir                              = domain.FieldFrontendDir
+	configKeyEmailSender                              = domain.FieldEmailSender
+	configKeyEmailRecipient                           = domain.FieldEmailRecipient
+	configKeyEmailSubject                             = domain.FieldEmailSubject
+	configKeyAwsSesRegion                             = domain.FieldAwsSesRegion
+	configKeyAwsSesAccessKey                          = domain.FieldAwsSesAccessKey
+	configKeyAwsSesSecretKey                          = domain.FieldAwsSesSecretKey
+	configKeyAwsSesEndpoint                           = domain.FieldAwsSesEndpoint
+	configKeyRecaptchaSecret                          = domain.FieldRecaptchaSecret
+	configKeyConfigWatch                              = "config_watch"
+	configKeySiteName                                 = domain.FieldSiteName
+	configKeySiteBaseURL                              = domain.FieldSiteBaseURL
+	configKeySiteCompanyNumber                        = domain.FieldSiteCompanyNumber
+	configKeySiteCopyrightHolder                      = domain.FieldSiteCopyrightHolder
+	configKeySiteContactEmail                         = domain.FieldSiteContactEmail
+	configKeySiteAnalyticsIDs                         = "site.analytics_ids"
+	configKeyRecaptchaSiteKey                         = domain.FieldRecaptchaSiteKey
+	configKeyTlsHttpsPort                             = domain.FieldTlsHttpsPort
+	configKeyTlsCertFile                              = domain.FieldTlsCertFile
+	configKeyTlsKeyFile                               = domain.FieldTlsKeyFile
+	configKeyTlsAcmeDomains                           = domain.FieldTlsAcmeDomains
+	configKeyTlsAcmeEmail                             = domain.FieldTlsAcmeEmail
+	configKeyTlsAcmeCacheDir                          = domain.FieldTlsAcmeCacheDir
+	configKeyTlsAcmeDirectoryURL                      = domain.FieldTlsAcmeDirectoryURL
+	configKeyTlsHstsMaxAge                            = domain.FieldTlsHstsMaxAge
+	configKeyTlsHstsIncludeSubdomains                 = domain.FieldTlsHstsIncludeSubdomains
+	configKeyTlsHstsPreload                           = domain.FieldTlsHstsPreload
+	configKeyLogLevel                                 = domain.FieldLogLevel
+	configKeyLogFormat                                = domain.FieldLogFormat
+	configKeyLogOutputs                               = domain.FieldLogOutputs
+	configKeyLogFile                                  = domain.FieldLogFile
+	configKeyLogFileMaxSize                           = domain.FieldLogFileMaxSize
+	configKeyLogFileMaxBackups                        = domain.FieldLogFileMaxBackups
+	configKeyLogSyslogAddress                         = domain.FieldLogSyslogAddress
+	configKeyLogRedactFields                          = domain.FieldLogRedactFields
+	configKeyLogFieldLevels                           = domain.FieldLogFieldLevels
+	configKeyLogDetectPersonalData                    = domain.FieldLogDetectPersonalData
+	configKeyCspEnabled                               = domain.FieldCspEnabled
+	configKeyCspReportOnly                            = domain.FieldCspReportOnly
+	configKeyCspReportURI                             = domain.FieldCspReportURI
+	configKeyCspDefaultSrc                            = domain.FieldCspDefaultSrc
+	configKeyCspScriptSrc                             = domain.FieldCspScriptSrc
+	configKeyCspStyleSrc                              = domain.FieldCspStyleSrc
+	configKeyCspImgSrc                                = domain.FieldCspImgSrc
+	configKeyCspFontSrc                               = domain.FieldCspFontSrc
+	configKeyCspConnectSrc                            = domain.FieldCspConnectSrc
+	configKeyCspFrameSrc                              = domain.FieldCspFrameSrc
+	configKeySecurityHeadersFrameOptions              = domain.FieldSecurityHeadersFrameOptions
+	configKeySecurityHeadersContentTypeOptions        = domain.FieldSecurityHeadersContentTypeOptions
+	configKeySecurityHeadersXSSProtection             = domain.FieldSecurityHeadersXSSProtection
+	configKeySecurityHeadersReferrerPolicy            = domain.FieldSecurityHeadersReferrerPolicy
+	configKeySecurityHeadersPermissionsPolicy         = domain.FieldSecurityHeadersPermissionsPolicy
+	configKeySecurityHeadersCrossOriginOpenerPolicy   = domain.FieldSecurityHeadersCrossOriginOpenerPolicy
+	configKeySecurityHeadersCrossOriginResourcePolicy = domain.FieldSecurityHeadersCrossOriginResourcePolicy
+	configKeySecurityHeadersOverrides                 = domain.FieldSecurityHeadersOverrides
+	configKeyErrorReportingDsn                        = domain.FieldErrorReportingDsn
+	configKeyErrorReportingEnvironment                = domain.FieldErrorReportingEnvironment
+	configKeyErrorReportingRelease                    = domain.FieldErrorReportingRelease
+	configKeyErrorReportingSampleRate                 = domain.FieldErrorReportingSampleRate
+	configKeyErrorReportingRateLimit                  = domain.FieldErrorReportingRateLimit
+	configKeyReportingRateLimit                       = domain.FieldReportingRateLimit
+	configKeyReportingDedupeWindow                    = domain.FieldReportingDedupeWindow
+	configKeyReportingMaxReports                      = domain.FieldReportingMaxReports
+	configKeyReportingNelMaxAge                       = domain.FieldReportingNelMaxAge
+	configKeyAdminUsername                            = domain.FieldAdminUsername
+	configKeyAdminPassword                            = domain.FieldAdminPassword
+	configKeyCompressionEnabled                       = domain.FieldCompressionEnabled
+	configKeyCompressionMinSize                       = domain.FieldCompressionMinSize
+	configKeyCompressionPrecompress                   = domain.FieldCompressionPrecompress
+)
+
+// configKeyConfigFile is the field problems with the config file itself are reported against
+const configKeyConfigFile = "config"
+
+// ConfigSource is where a configuration value was resolved from
+type ConfigSource string
+
+const (
+	ConfigSourceDefault ConfigSource = "default"
+	ConfigSourceFile    ConfigSource = "file"
+	ConfigSourceEnv     ConfigSource = "env"
+	ConfigSourceFlag    ConfigSource = "flag"
+)
+
+// maskedConfigValue is shown in place of secret configuration values
+const maskedConfigValue = "********"
+
+// The default sources of the Content-Security-Policy, allowing Google Analytics, Google Fonts and reCAPTCHA. Inline
+// styles are allowed as the reCAPTCHA widget adds them
+const (
+	defaultCspDefaultSrc = "'self'"
+	defaultCspScriptSrc  = "'self',https://www.googletagmanager.com,https://www.google.com/recaptcha/,https://www.gstatic.com/recaptcha/"
+	defaultCspStyleSrc   = "'self','unsafe-inline',https://fonts.googleapis.com"
+	defaultCspImgSrc     = "'self',data:,https://www.google-analytics.com,https://www.googletagmanager.com"
+	defaultCspFontSrc    = "'self',https://fonts.gstatic.com"
+	defaultCspConnectSrc = "'self',https://www.google-analytics.com,https://*.google-analytics.com,https://*.analytics.google.com,https://www.googletagmanager.com"
+	defaultCspFrameSrc   = "https://www.google.com/recaptcha/,https://recaptcha.google.com/recaptcha/"
+)
+
+// defaultTracingNoPropagateHosts are the hosts outbound requests don't send the trace context to by default, reCAPTCHA
+// is verified with Google, a third party
+const defaultTracingNoPropagateHosts = "www.google.com"
+
+// The default Referrer-Policy and Permissions-Policy. Other sites are only told which site visitors came from, and the
+// site never uses the camera, microphone, location or payments
+const (
+	defaultReferrerPolicy    = "strict-origin-when-cross-origin"
+	defaultPermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=()"
 )
 
-type EnvVarConfigService struct {
+// defaultLogRedactFields are the fields masked on every log line by default, the personal details of the contact form,
+// message bodies and anything which could hold a credential
+const defaultLogRedactFields = "name,email,company,number,phone,message,body,password,secret,token,authorization,cookie"
+
+// secretFileSuffix is appended to the environment variable of a secret setting to give the variable containing the
+// path to a file holding the secret, e.g. a mounted Docker or Kubernetes secret
+const secretFileSuffix = "_FILE"
+
+// configSetting describes a single configuration value and where it can be set
+type configSetting struct {
+	// Key is the dotted path of the setting in the config file
+	Key string
+
+	// EnvVar is the environment variable the setting can be set with
+	EnvVar string
+
+	// Default is used when the setting is not set anywhere, settings without a default are required
+	Default string
+
+	// Secret settings are never shown in logs
+	Secret bool
+
+	// Optional settings without a default are left empty when they aren't set
+	Optional bool
+
+	// Usage describes the setting
+	Usage string
+}
+
+// FlagName is the command line flag the setting can be set with
+func (setting configSetting) FlagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(setting.Key)
+}
+
+// SecretFileEnvVar is the environment variable containing the path to a file holding the value of a secret setting
+func (setting configSetting) SecretFileEnvVar() string {
+	return setting.EnvVar + secretFileSuffix
+}
+
+// SecretFileFlagName is the command line flag containing the path to a file holding the value of a secret setting
+func (setting configSetting) SecretFileFlagName() string {
+	return setting.FlagName() + "-file"
+}
+
+// SecretFileKey is the config file key containing the path to a file holding the value of a secret setting
+func (setting configSetting) SecretFileKey() string {
+	return setting.Key + strings.ToLower(secretFileSuffix)
+}
+
+// IsRequired is true when the setting has no default and isn't optional
+func (setting configSetting) IsRequired() bool {
+	return !setting.Optional && len(setting.Default) <= 0
+}
+
+// configSettings are all the settings known to the application
+var configSettings = []configSetting{
+	{Key: configKeyHttpPort, EnvVar: envVarHttpPort, Default: "8080", Usage: "the HTTP port to serve on"},
+	{Key: configKeyShutdownTimeout, EnvVar: envVarShutdownTimeout, Default: "20s", Usage: "how long in flight requests are given to finish when shutting down"},
+	{Key: configKeyShutdownDrainDelay, EnvVar: envVarShutdownDrainDelay, Default: "0s", Usage: "how long to keep serving after reporting not ready when shutting down"},
+	{Key: configKeyReadTimeout, EnvVar: envVarReadTimeout, Default: "10s", Usage: "how long a client has to send a whole request"},
+	{Key: configKeyReadHeaderTimeout, EnvVar: envVarReadHeaderTimeout, Default: "5s", Usage: "how long a client has to send the request headers"},
+	{Key: configKeyWriteTimeout, EnvVar: envVarWriteTimeout, Default: "45s", Usage: "how long a request has to be handled and its response written"},
+	{Key: configKeyIdleTimeout, EnvVar: envVarIdleTimeout, Default: "120s", Usage: "how long an idle keep-alive connection is kept open"},
+	{Key: configKeyBodyLimit, EnvVar: envVarBodyLimit, Default: "4KB", Usage: "the largest request body accepted by most routes"},
+	{Key: configKeyUploadBodyLimit, EnvVar: envVarUploadBodyLimit, Default: "1MB", Usage: "the largest request body accepted by form submission and upload routes"},
+	{Key: configKeyTrustForwardedFor, EnvVar: envVarTrustForwardedFor, Default: "false", Usage: "identify clients by their X-Forwarded-For header rather than the address they connected from"},
+	{Key: configKeyFrontendDir, EnvVar: envVarFrontendDir, Optional: true, Usage: "a directory whose views/ and public/ files override those built in"},
+	{Key: configKeyEmailSender, EnvVar: envVarEmailSender, Usage: "the email address to use in the \"from\" field"},
+	{Key: configKeyEmailRecipient, EnvVar: envVarEmailRecipient, Usage: "the email address to send contact forms to"},
+	{Key: configKeyEmailSubject, EnvVar: envVarEmailSubject, Default: "Website contact form", Usage: "the subject of contact form emails"},
+	{Key: configKeyAwsSesRegion, EnvVar: envVarAwsSesRegion, Usage: "the AWS SES region to send emails with"},
+	{Key: configKeyAwsSesAccessKey, EnvVar: enVarAwsSesAccessKey, Optional: true, Usage: "the AWS SES IAM user access key, omit to use the default AWS credential chain"},
+	{Key: configKeyAwsSesSecretKey, EnvVar: enVarAwsSesSecretKey, Secret: true, Optional: true, Usage: "the AWS SES IAM user secret key, omit to use the default AWS credential chain"},
+	{Key: configKeyAwsSesEndpoint, EnvVar: envVarAwsSesEndpoint, Optional: true, Usage: "overrides the AWS SES endpoint, e.g. to use a local SES stand-in"},
+	{Key: configKeyRecaptchaSecret, EnvVar: enVarRecaptchaSecret, Secret: true, Usage: "the recaptcha secret"},
+	{Key: configKeyConfigWatch, EnvVar: envVarConfigWatch, Default: "false", Usage: "reload the config when the config file changes"},
+	{Key: configKeySiteName, EnvVar: envVarSiteName, Usage: "the name of the site"},
+	{Key: configKeySiteBaseURL, EnvVar: envVarSiteBaseURL, Usage: "the absolute URL the site is served from"},
+	{Key: configKeySiteCompanyNumber, EnvVar: envVarSiteCompanyNumber, Usage: "the registered company number"},
+	{Key: configKeySiteCopyrightHolder, EnvVar: envVarSiteCopyrightHolder, Usage: "the copyright holder"},
+	{Key: configKeySiteContactEmail, EnvVar: envVarSiteContactEmail, Usage: "the email address visitors can get in touch with"},
+	{Key: configKeySiteAnalyticsIDs, EnvVar: envVarSiteAnalyticsIDs, Optional: true, Usage: "comma separated Google Analytics IDs, empty to disable analytics"},
+	{Key: configKeyRecaptchaSiteKey, EnvVar: envVarRecaptchaSiteKey, Usage: "the public recaptcha site key"},
+	{Key: configKeyMetricsEnabled, EnvVar: envVarMetricsEnabled, Default: "true", Usage: "serve metrics for Prometheus on /metrics"},
+	{Key: configKeyMetricsPort, EnvVar: envVarMetricsPort, Default: "9090", Usage: "the port to serve metrics on, never route it publicly; 0 serves them alongside the site"},
+	{Key: configKeyTracingEnabled, EnvVar: envVarTracingEnabled, Default: "false", Usage: "export traces to the OTLP collector"},
+	{Key: configKeyTracingEndpoint, EnvVar: envVarTracingEndpoint, Default: "http://localhost:4318", Usage: "the URL of the OTLP/HTTP collector to export traces to"},
+	{Key: configKeyTracingTrustIncoming, EnvVar: envVarTracingTrustIncoming, Default: "false", Usage: "continue the trace of a request's traceparent header rather than starting a new one"},
+	{Key: configKeyTracingNoPropagateHosts, EnvVar: envVarTracingNoPropagateHosts, Default: defaultTracingNoPropagateHosts, Usage: "comma separated hosts outbound requests don't send the trace context to, e.g. third parties"},
+	{Key: configKeyTlsHttpsPort, EnvVar: envVarTlsHttpsPort, Default: "8443", Usage: "the HTTPS port to serve on when TLS is enabled"},
+	{Key: configKeyTlsCertFile, EnvVar: envVarTlsCertFile, Optional: true, Usage: "the PEM certificate file to serve HTTPS with"},
+	{Key: configKeyTlsKeyFile, EnvVar: envVarTlsKeyFile, Optional: true, Usage: "the PEM private key file for the certificate"},
+	{Key: configKeyTlsAcmeDomains, EnvVar: envVarTlsAcmeDomains, Optional: true, Usage: "comma separated domains to get certificates for from Let's Encrypt"},
+	{Key: configKeyTlsAcmeEmail, EnvVar: envVarTlsAcmeEmail, Optional: true, Usage: "the contact email address given to Let's Encrypt"},
+	{Key: configKeyTlsAcmeCacheDir, EnvVar: envVarTlsAcmeCacheDir, Default: "/var/lib/website/certs", Usage: "the directory certificates from Let's Encrypt are kept in"},
+	{Key: configKeyTlsAcmeDirectoryURL, EnvVar: envVarTlsAcmeDirectoryURL, Optional: true, Usage: "overrides the ACME directory, e.g. to use the Let's Encrypt staging environment"},
+	{Key: configKeyTlsHstsMaxAge, EnvVar: envVarTlsHstsMaxAge, Default: "0s", Usage: "the max-age of the Strict-Transport-Security header, 0s to not send it"},
+	{Key: configKeyTlsHstsIncludeSubdomains, EnvVar: envVarTlsHstsIncludeSubdomains, Default: "false", Usage: "extend HSTS to every subdomain"},
+	{Key: configKeyTlsHstsPreload, EnvVar: envVarTlsHstsPreload, Default: "false", Usage: "ask for the domain to be preloaded by browsers, needs a max age of a year and subdomains"},
+	{Key: configKeyLogLevel, EnvVar: envVarLogLevel, Default: domain.LogLevelInfo, Usage: "the least severe level logged: debug, info, warn or error"},
+	{Key: configKeyLogFormat, EnvVar: envVarLogFormat, Default: domain.LogFormatJSON, Usage: "the log format: json, text or logfmt"},
+	{Key: configKeyLogOutputs, EnvVar: envVarLogOutputs, Default: domain.LogOutputStdout, Usage: "comma separated outputs to log to: stdout, file or syslog"},
+	{Key: configKeyLogFile, EnvVar: envVarLogFile, Optional: true, Usage: "the file the file output writes to"},
+	{Key: configKeyLogFileMaxSize, EnvVar: envVarLogFileMaxSize, Default: "100", Usage: "how many megabytes the log file can grow to before it is rotated"},
+	{Key: configKeyLogFileMaxBackups, EnvVar: envVarLogFileMaxBackups, Default: "5", Usage: "how many rotated log files are kept, 0 keeps them all"},
+	{Key: configKeyLogSyslogAddress, EnvVar: envVarLogSyslogAddress, Optional: true, Usage: "the syslog server to log to, e.g. udp://logs.example.com:514, empty for the local syslog"},
+	{Key: configKeyLogRedactFields, EnvVar: envVarLogRedactFields, Default: defaultLogRedactFields, Usage: "comma separated fields whose values are masked on every log line"},
+	{Key: configKeyLogFieldLevels, EnvVar: envVarLogFieldLevels, Optional: true, Usage: "comma separated field:level pairs, e.g. ip:info, masking the field on more severe log lines"},
+	{Key: configKeyLogDetectPersonalData, EnvVar: envVarLogDetectPersonalData, Default: "true", Usage: "mask email addresses and phone numbers found anywhere in log lines"},
+	{Key: configKeyCspEnabled, EnvVar: envVarCspEnabled, Default: "true", Usage: "send a Content-Security-Policy with every response"},
+	{Key: configKeyCspReportOnly, EnvVar: envVarCspReportOnly, Default: "false", Usage: "only report violations of the Content-Security-Policy rather than blocking them"},
+	{Key: configKeyCspReportURI, EnvVar: envVarCspReportURI, Default: "/reports", Usage: "where browsers report Content-Security-Policy violations, empty to not report them"},
+	{Key: configKeyCspDefaultSrc, EnvVar: envVarCspDefaultSrc, Default: defaultCspDefaultSrc, Usage: "comma separated sources allowed by the CSP default-src directive"},
+	{Key: configKeyCspScriptSrc, EnvVar: envVarCspScriptSrc, Default: defaultCspScriptSrc, Usage: "comma separated sources allowed by the CSP script-src directive, inline scripts are allowed by nonce"},
+	{Key: configKeyCspStyleSrc, EnvVar: envVarCspStyleSrc, Default: defaultCspStyleSrc, Usage: "comma separated sources allowed by the CSP style-src directive"},
+	{Key: configKeyCspImgSrc, EnvVar: envVarCspImgSrc, Default: defaultCspImgSrc, Usage: "comma separated sources allowed by the CSP img-src directive"},
+	{Key: configKeyCspFontSrc, EnvVar: envVarCspFontSrc, Default: defaultCspFontSrc, Usage: "comma separated sources allowed by the CSP font-src directive"},
+	{Key: configKeyCspConnectSrc, EnvVar: envVarCspConnectSrc, Default: defaultCspConnectSrc, Usage: "comma separated sources allowed by the CSP connect-src directive"},
+	{Key: configKeyCspFrameSrc, EnvVar: envVarCspFrameSrc, Default: defaultCspFrameSrc, Usage: "comma separated sources allowed by the CSP frame-src directive"},
+	{Key: configKeySecurityHeadersFrameOptions, EnvVar: envVarSecurityHeadersFrameOptions, Default: "DENY", Usage: "the X-Frame-Options header, empty to not send it"},
+	{Key: configKeySecurityHeadersContentTypeOptions, EnvVar: envVarSecurityHeadersContentTypeOptions, Default: "nosniff", Usage: "the X-Content-Type-Options header, empty to not send it"},
+	{Key: configKeySecurityHeadersXSSProtection, EnvVar: envVarSecurityHeadersXSSProtection, Default: "1; mode=block", Usage: "the X-XSS-Protection header, empty to not send it"},
+	{Key: configKeySecurityHeadersReferrerPolicy, EnvVar: envVarSecurityHeadersReferrerPolicy, Default: defaultReferrerPolicy, Usage: "the Referrer-Policy header, empty to not send it"},
+	{Key: configKeySecurityHeadersPermissionsPolicy, EnvVar: envVarSecurityHeadersPermissionsPolicy, Default: defaultPermissionsPolicy, Usage: "the Permissions-Policy header, empty to not send it"},
+	{Key: configKeySecurityHeadersCrossOriginOpenerPolicy, EnvVar: envVarSecurityHeadersCrossOriginOpenerPolicy, Default: "same-origin", Usage: "the Cross-Origin-Opener-Policy header, empty to not send it"},
+	{Key: configKeySecurityHeadersCrossOriginResourcePolicy, EnvVar: envVarSecurityHeadersCrossOriginResourcePolicy, Default: "same-origin", Usage: "the Cross-Origin-Resource-Policy header, empty to not send it"},
+	{Key: configKeySecurityHeadersOverrides, EnvVar: envVarSecurityHeadersOverrides, Optional: true, Usage: "| separated overrides of the security headers for paths starting with a prefix, e.g. /admin Referrer-Policy=no-referrer"},
+	{Key: configKeyErrorReportingDsn, EnvVar: envVarErrorReportingDsn, Secret: true, Optional: true, Usage: "the Sentry DSN errors are reported to, empty to not report them"},
+	{Key: configKeyErrorReportingEnvironment, EnvVar: envVarErrorReportingEnvironment, Default: "production", Usage: "the environment errors are reported from"},
+	{Key: configKeyErrorReportingRelease, EnvVar: envVarErrorReportingRelease, Optional: true, Usage: "the release errors are reported from, empty for the version the application was built as"},
+	{Key: configKeyErrorReportingSampleRate, EnvVar: envVarErrorReportingSampleRate, Default: "1", Usage: "the fraction of errors reported, from 0 to 1"},
+	{Key: configKeyErrorReportingRateLimit, EnvVar: envVarErrorReportingRateLimit, Default: "60", Usage: "the most errors reported a minute"},
+	{Key: configKeyReportingRateLimit, EnvVar: envVarReportingRateLimit, Default: "30", Usage: "the most browser reports accepted from a client a minute"},
+	{Key: configKeyReportingDedupeWindow, EnvVar: envVarReportingDedupeWindow, Default: "10m", Usage: "how long the same browser report from the same client is ignored after it is counted"},
+	{Key: configKeyReportingMaxReports, EnvVar: envVarReportingMaxReports, Default: "1000", Usage: "the most distinct browser reports counted"},
+	{Key: configKeyReportingNelMaxAge, EnvVar: envVarReportingNelMaxAge, Default: "0s", Usage: "how long browsers are asked to report network errors for, 0s to not ask them"},
+	{Key: configKeyAdminUsername, EnvVar: envVarAdminUsername, Default: "admin", Usage: "the username of the admin area"},
+	{Key: configKeyAdminPassword, EnvVar: envVarAdminPassword, Secret: true, Optional: true, Usage: "the password of the admin area, empty to not serve it"},
+	{Key: configKeyCompressionEnabled, EnvVar: envVarCompressionEnabled, Default: "true", Usage: "compress HTML, text and other text-based responses with brotli or gzip"},
+	{Key: configKeyCompressionMinSize, EnvVar: envVarCompressionMinSize, Default: "1KB", Usage: "the smallest response compressed"},
+	{Key: configKeyCompressionPrecompress, EnvVar: envVarCompressionPrecompress, Default: "true", Usage: "compress the public files on start up rather than for every request"},
+}
+
+// ConfigValue is a resolved configuration value
+type ConfigValue struct {
+	// Key is the dotted path of the setting in the config file
+	Key string
+
+	// Value is the resolved value
+	Value string
+
+	// Source is where the value was resolved from
+	Source ConfigSource
+
+	// Origin is the flag, environment variable or file the value came from
+	Origin string
+
+	// Secret values are masked when printed
+	Secret bool
+}
+
+// String prints the value with its source, masking it if it is a secret
+func (value ConfigValue) String() string {
+	shown := value.Value
+	if value.Secret && len(shown) > 0 {
+		shown = maskedConfigValue
+	}
+
+	if len(value.Origin) > 0 {
+		return fmt.Sprintf("%s=%s (%s: %s)", value.Key, shown, value.Source, value.Origin)
+	}
+	return fmt.Sprintf("%s=%s (%s)", value.Key, shown, value.Source)
+}
+
+// LayeredConfigService loads the configuration from, in order of increasing precedence:
+//  1. the defaults of optional settings
+//  2. a YAML or TOML config file, given by the -config flag or CONFIG_FILE environment variable
+//  3. environment variables
+//  4. command line flags
+type LayeredConfigService struct {
 	logger Logger
+
+	// args are the command line arguments, excluding the program name
+	args []string
+
+	// lookupEnv looks up environment variables
+	lookupEnv func(key string) (string, bool)
+
+	// values are the values resolved by the last load, keyed by setting key
+	values map[string]ConfigValue
+
+	// configFile is the config file used by the last load, if any
+	configFile string
 }
 
-func NewEnvVarConfigService(logger Logger) *EnvVarConfigService {
-	return &EnvVarConfigService{
-		logger: logger,
+func NewLayeredConfigService(logger Logger, args []string) *LayeredConfigService {
+	return &LayeredConfigService{
+		logger:    logger,
+		args:      args,
+		lookupEnv: os.LookupEnv,
+		values:    make(map[string]ConfigValue),
+	}
+}
+
+func (configService *LayeredConfigService) LoadConfig() *domain.AppConfig {
+	appConfig, err := configService.TryLoadConfig()
+	if err != nil {
+		panic(err.Error())
 	}
+
+	return appConfig
 }
 
-func (configService *EnvVarConfigService) LoadConfig() *domain.AppConfig {
+// TryLoadConfig loads and validates the config. If the config can't be loaded or isn't valid the error is a
+// *domain.ValidationReport listing every problem found
+func (configService *LayeredConfigService) TryLoadConfig() (*domain.AppConfig, error) {
+	report := &domain.ValidationReport{}
+	err := configService.resolve(report)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range configService.Values() {
+		configService.logger.Debug("Resolved config value", Fields{
+			"key":    value.Key,
+			"value":  value.String(),
+			"source": string(value.Source),
+		})
+	}
+
 	appConfig := &domain.AppConfig{
-		HttpPort: configService.loadEnvVarAsIntOrPanic(envVarHttpPort),
-		FrontendDir: configService.loadEnvVarAsStringOrPanic(envVarFrontendDir),
+		HttpPort:           configService.intValue(configKeyHttpPort, report),
+		ShutdownTimeout:    configService.durationValue(configKeyShutdownTimeout, report),
+		ShutdownDrainDelay: configService.durationValue(configKeyShutdownDrainDelay, report),
+		ReadTimeout:        configService.durationValue(configKeyReadTimeout, report),
+		ReadHeaderTimeout:  configService.durationValue(configKeyReadHeaderTimeout, report),
+		WriteTimeout:       configService.durationValue(configKeyWriteTimeout, report),
+		IdleTimeout:        configService.durationValue(configKeyIdleTimeout, report),
+		BodyLimit:          configService.byteSizeValue(configKeyBodyLimit, report),
+		UploadBodyLimit:    configService.byteSizeValue(configKeyUploadBodyLimit, report),
+		TrustForwardedFor:  configService.boolValue(configKeyTrustForwardedFor, report),
+		FrontendDir:        configService.value(configKeyFrontendDir),
 		EmailConfig: &domain.EmailConfig{
-			Sender:          configService.loadEnvVarAsStringOrPanic(envVarEmailSender),
-			Recipient:       configService.loadEnvVarAsStringOrPanic(envVarEmailRecipient),
-			Subject:         configService.loadEnvVarAsStringOrPanic(envVarEmailSubject),
-			AwsSesRegion:    configService.loadEnvVarAsStringOrPanic(envVarAwsSesRegion),
-			AwsSesAccessKey: configService.loadEnvVarAsStringOrPanic(enVarAwsSesAccessKey),
-			AwsSesSecretKey: configService.loadEnvVarAsStringOrPanic(enVarAwsSesSecretKey),
+			Sender:          configService.value(configKeyEmailSender),
+			Recipient:       configService.value(configKeyEmailRecipient),
+			Subject:         configService.value(configKeyEmailSubject),
+			AwsSesRegion:    configService.value(configKeyAwsSesRegion),
+			AwsSesAccessKey: configService.value(configKeyAwsSesAccessKey),
+			AwsSesSecretKey: domain.Secret(configService.value(configKeyAwsSesSecretKey)),
+			AwsSesEndpoint:  configService.value(configKeyAwsSesEndpoint),
+		},
+		RecaptchaSecret: domain.Secret(configService.value(configKeyRecaptchaSecret)),
+		Site: &domain.SiteConfig{
+			Name:             configService.value(configKeySiteName),
+			BaseURL:          strings.TrimSuffix(configService.value(configKeySiteBaseURL), "/"),
+			CompanyNumber:    configService.value(configKeySiteCompanyNumber),
+			CopyrightHolder:  configService.value(configKeySiteCopyrightHolder),
+			ContactEmail:     configService.value(configKeySiteContactEmail),
+			AnalyticsIDs:     splitConfigList(configService.value(configKeySiteAnalyticsIDs)),
+			RecaptchaSiteKey: configService.value(configKeyRecaptchaSiteKey),
+		},
+		MetricsEnabled:          configService.boolValue(configKeyMetricsEnabled, report),
+		MetricsPort:             configService.intValue(configKeyMetricsPort, report),
+		TracingEnabled:          configService.boolValue(configKeyTracingEnabled, report),
+		TracingEndpoint:         configService.value(configKeyTracingEndpoint),
+		TracingTrustIncoming:    configService.boolValue(configKeyTracingTrustIncoming, report),
+		TracingNoPropagateHosts: splitConfigList(configService.value(configKeyTracingNoPropagateHosts)),
+		TLS: &domain.TLSConfig{
+			HttpsPort:             configService.intValue(configKeyTlsHttpsPort, report),
+			CertFile:              configService.value(configKeyTlsCertFile),
+			KeyFile:               configService.value(configKeyTlsKeyFile),
+			AcmeDomains:           splitConfigList(configService.value(configKeyTlsAcmeDomains)),
+			AcmeEmail:             configService.value(configKeyTlsAcmeEmail),
+			AcmeCacheDir:          configService.value(configKeyTlsAcmeCacheDir),
+			AcmeDirectoryURL:      configService.value(configKeyTlsAcmeDirectoryURL),
+			HstsMaxAge:            configService.durationValue(configKeyTlsHstsMaxAge, report),
+			HstsIncludeSubdomains: configService.boolValue(configKeyTlsHstsIncludeSubdomains, report),
+			HstsPreload:           configService.boolValue(configKeyTlsHstsPreload, report),
+		},
+		Log: &domain.LogConfig{
+			Level:              configService.value(configKeyLogLevel),
+			Format:             configService.value(configKeyLogFormat),
+			Outputs:            splitConfigList(configService.value(configKeyLogOutputs)),
+			File:               configService.value(configKeyLogFile),
+			FileMaxMegabytes:   configService.intValue(configKeyLogFileMaxSize, report),
+			FileMaxBackups:     configService.intValue(configKeyLogFileMaxBackups, report),
+			SyslogAddress:      configService.value(configKeyLogSyslogAddress),
+			RedactFields:       splitConfigList(configService.value(configKeyLogRedactFields)),
+			FieldLevels:        configService.fieldLevelsValue(configKeyLogFieldLevels, report),
+			DetectPersonalData: configService.boolValue(configKeyLogDetectPersonalData, report),
+		},
+		CSP: &domain.CSPConfig{
+			Enabled:    configService.boolValue(configKeyCspEnabled, report),
+			ReportOnly: configService.boolValue(configKeyCspReportOnly, report),
+			ReportURI:  configService.value(configKeyCspReportURI),
+			Sources: map[string][]string{
+				domain.CspDefaultSrc: splitConfigList(configService.value(configKeyCspDefaultSrc)),
+				domain.CspScriptSrc:  splitConfigList(configService.value(configKeyCspScriptSrc)),
+				domain.CspStyleSrc:   splitConfigList(configService.value(configKeyCspStyleSrc)),
+				domain.CspImgSrc:     splitConfigList(configService.value(configKeyCspImgSrc)),
+				domain.CspFontSrc:    splitConfigList(configService.value(configKeyCspFontSrc)),
+				domain.CspConnectSrc: splitConfigList(configService.value(configKeyCspConnectSrc)),
+				domain.CspFrameSrc:   splitConfigList(configService.value(configKeyCspFrameSrc)),
+			},
 		},
-		RecaptchaSecret: configService.loadEnvVarAsStringOrPanic(enVarRecaptchaSecret),
+		SecurityHeaders: &domain.SecurityHeadersConfig{
+			FrameOptions:              configService.value(configKeySecurityHeadersFrameOptions),
+			ContentTypeOptions:        configService.value(configKeySecurityHeadersContentTypeOptions),
+			XSSProtection:             configService.value(configKeySecurityHeadersXSSProtection),
+			ReferrerPolicy:            configService.value(configKeySecurityHeadersReferrerPolicy),
+			PermissionsPolicy:         configService.value(configKeySecurityHeadersPermissionsPolicy),
+			CrossOriginOpenerPolicy:   configService.value(configKeySecurityHeadersCrossOriginOpenerPolicy),
+			CrossOriginResourcePolicy: configService.value(configKeySecurityHeadersCrossOriginResourcePolicy),
+			Overrides:                 configService.securityHeadersOverridesValue(configKeySecurityHeadersOverrides, report),
+		},
+		ErrorReporting: &domain.ErrorReportingConfig{
+			Dsn:         domain.Secret(configService.value(configKeyErrorReportingDsn)),
+			Environment: configService.value(configKeyErrorReportingEnvironment),
+			Release:     configService.value(configKeyErrorReportingRelease),
+			SampleRate:  configService.floatValue(configKeyErrorReportingSampleRate, report),
+			RateLimit:   configService.intValue(configKeyErrorReportingRateLimit, report),
+		},
+		Reporting: &domain.ReportingConfig{
+			RateLimit:    configService.intValue(configKeyReportingRateLimit, report),
+			DedupeWindow: configService.durationValue(configKeyReportingDedupeWindow, report),
+			MaxReports:   configService.intValue(configKeyReportingMaxReports, report),
+			NelMaxAge:    configService.durationValue(configKeyReportingNelMaxAge, report),
+		},
+		Admin: &domain.AdminConfig{
+			Username: configService.value(configKeyAdminUsername),
+			Password: domain.Secret(configService.value(configKeyAdminPassword)),
+		},
+		Compression: &domain.CompressionConfig{
+			Enabled:     configService.boolValue(configKeyCompressionEnabled, report),
+			MinSize:     configService.byteSizeValue(configKeyCompressionMinSize, report),
+			Precompress: configService.boolValue(configKeyCompressionPrecompress, report),
+		},
+		ConfigFile:      configService.configFile,
+		WatchConfigFile: configService.boolValue(configKeyConfigWatch, report),
 	}
 
-	err := appConfig.Validate()
+	appConfig.ValidateInto(report)
+	if report.HasProblems() {
+		return nil, report
+	}
+
+	return appConfig, nil
+}
+
+// Values returns the values resolved by the last load, in the order the settings are declared
+func (configService *LayeredConfigService) Values() []ConfigValue {
+	values := make([]ConfigValue, 0, len(configSettings))
+	for _, setting := range configSettings {
+		if value, isFound := configService.values[setting.Key]; isFound {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Dump prints every resolved value with its source, one per line, with secrets masked
+func (configService *LayeredConfigService) Dump() string {
+	lines := make([]string, 0, len(configService.values))
+	for _, value := range configService.Values() {
+		lines = append(lines, value.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// value returns the resolved value of the setting
+func (configService *LayeredConfigService) value(key string) string {
+	return configService.values[key].Value
+}
+
+// intValue gets the resolved value of a number setting, adding a problem to the report if it isn't one
+func (configService *LayeredConfigService) intValue(key string, report *domain.ValidationReport) int {
+	number, err := strconv.Atoi(configService.value(key))
 	if err != nil {
-		panic(err.Error())
+		report.Add(key, fmt.Sprintf("'%s' is not a number", configService.value(key)))
 	}
+	return number
+}
 
-	return appConfig
+// boolValue gets the resolved value of a true or false setting, adding a problem to the report if it isn't one
+func (configService *LayeredConfigService) boolValue(key string, report *domain.ValidationReport) bool {
+	value, err := strconv.ParseBool(configService.value(key))
+	if err != nil {
+		report.Add(key, fmt.Sprintf("'%s' is not true or false", configService.value(key)))
+	}
+	return value
+}
+
+// floatValue gets the resolved value of a decimal setting, adding a problem to the report if it isn't one
+func (configService *LayeredConfigService) floatValue(key string, report *domain.ValidationReport) float64 {
+	value, err := strconv.ParseFloat(configService.value(key), 64)
+	if err != nil {
+		report.Add(key, fmt.Sprintf("'%s' is not a number", configService.value(key)))
+	}
+	return value
+}
+
+// securityHeadersOverridesValue gets the resolved value of a list of security header overrides, adding a problem to the
+// report if it isn't one
+func (configService *LayeredConfigService) securityHeadersOverridesValue(key string, report *domain.ValidationReport) []domain.SecurityHeadersOverride {
+	overrides, err := domain.ParseSecurityHeadersOverrides(configService.value(key))
+	if err != nil {
+		report.Add(key, err.Error())
+	}
+	return overrides
+}
+
+// fieldLevelsValue gets the resolved value of a list of field:level pairs, adding a problem to the report if it isn't one
+func (configService *LayeredConfigService) fieldLevelsValue(key string, report *domain.ValidationReport) map[string]string {
+	fieldLevels, err := domain.ParseLogFieldLevels(splitConfigList(configService.value(key)))
+	if err != nil {
+		report.Add(key, err.Error())
+	}
+	return fieldLevels
+}
+
+// durationValue gets the resolved value of a duration setting, e.g. "20s", adding a problem to the report if it isn't one
+func (configService *LayeredConfigService) durationValue(key string, report *domain.ValidationReport) time.Duration {
+	duration, err := time.ParseDuration(configService.value(key))
+	if err != nil {
+		report.Add(key, fmt.Sprintf("'%s' is not a duration, e.g. 20s", configService.value(key)))
+	}
+	return duration
+}
+
+// byteSizeValue gets the resolved value of a size setting in bytes, e.g. "64KB", adding a problem to the report if it
+// isn't one
+func (configService *LayeredConfigService) byteSizeValue(key string, report *domain.ValidationReport) int64 {
+	size, err := bytes.Parse(configService.value(key))
+	if err != nil {
+		report.Add(key, fmt.Sprintf("'%s' is not a size, e.g. 64KB", configService.value(key)))
+	}
+	return size
 }
 
-// LoadEnvVarAsIntOrPanic loadEnvVarAsInt is a utility function for loading an environment variable as an int and panics if it is not there
-func (configService *EnvVarConfigService) loadEnvVarAsIntOrPanic(envVarName string) int {
-	ev, isFound := os.LookupEnv(envVarName)
-	if !isFound {
-		panic(fmt.Sprintf("Environment variable '%s' was not found, application cannot start", envVarName))
+// resolve resolves every setting from each of the layers. Settings which can't be resolved are added to the report, an
+// error is only returned if the command line can't be parsed
+func (configService *LayeredConfigService) resolve(report *domain.ValidationReport) error {
+	flagSet := flag.NewFlagSet("website-sea-city-software", flag.ContinueOnError)
+	configFile := flagSet.String(flagConfigFile, "", "path to a YAML or TOML config file")
+	flagValues := make(map[string]*string)
+	for _, setting := range configSettings {
+		flagValues[setting.FlagName()] = flagSet.String(setting.FlagName(), "", fmt.Sprintf("%s (env %s)", setting.Usage, setting.EnvVar))
+		if setting.Secret {
+			flagValues[setting.SecretFileFlagName()] = flagSet.String(setting.SecretFileFlagName(), "", fmt.Sprintf("path to a file containing %s (env %s)", setting.Usage, setting.SecretFileEnvVar()))
+		}
 	}
 
-	evi, err := strconv.Atoi(ev)
+	err := flagSet.Parse(configService.args)
 	if err != nil {
-		golog.Fatalf("Environment variable '%s' was not a number", envVarName)
-		panic(fmt.Sprintf("Environment variable '%s' was not a number, application cannot start", envVarName))
+		return err
+	}
+
+	setFlags := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	// The config file can itself come from a flag or the environment
+	configFilePath := *configFile
+	if !setFlags[flagConfigFile] {
+		configFilePath, _ = configService.lookupEnv(envVarConfigFile)
 	}
 
-	configService.logger.Debug(fmt.Sprintf("Environment variable '%s' found as '%s'", envVarName, ev), make(Fields))
-	return evi
+	fileValues := make(map[string]string)
+	if len(configFilePath) > 0 {
+		fileValues, err = loadConfigFile(configFilePath)
+		if err != nil {
+			report.Add(configKeyConfigFile, err.Error())
+			fileValues = make(map[string]string)
+		}
+		configService.warnUnknownKeys(configFilePath, fileValues)
+	}
+
+	values := make(map[string]ConfigValue)
+	for _, setting := range configSettings {
+		value := ConfigValue{Key: setting.Key, Secret: setting.Secret}
+
+		// Secrets can also be read from a file given in any of the layers, a value set directly takes precedence over
+		// a file in the same layer
+		var secretFile string
+		if setFlags[setting.FlagName()] {
+			value.Value, value.Source, value.Origin = *flagValues[setting.FlagName()], ConfigSourceFlag, "-"+setting.FlagName()
+		} else if setting.Secret && setFlags[setting.SecretFileFlagName()] {
+			secretFile, value.Source, value.Origin = *flagValues[setting.SecretFileFlagName()], ConfigSourceFlag, "-"+setting.SecretFileFlagName()
+		} else if ev, isFound := configService.lookupEnv(setting.EnvVar); isFound {
+			value.Value, value.Source, value.Origin = ev, ConfigSourceEnv, setting.EnvVar
+		} else if ev, isFound := configService.lookupEnv(setting.SecretFileEnvVar()); setting.Secret && isFound {
+			secretFile, value.Source, value.Origin = ev, ConfigSourceEnv, setting.SecretFileEnvVar()
+		} else if fv, isFound := fileValues[setting.Key]; isFound {
+			value.Value, value.Source, value.Origin = fv, ConfigSourceFile, configFilePath
+		} else if fv, isFound := fileValues[setting.SecretFileKey()]; setting.Secret && isFound {
+			secretFile, value.Source, value.Origin = fv, ConfigSourceFile, configFilePath
+		} else if !setting.IsRequired() {
+			value.Value, value.Source = setting.Default, ConfigSourceDefault
+		} else {
+			report.Add(setting.Key, fmt.Sprintf("required but not set, set it in the config file, with %s or with -%s", setting.EnvVar, setting.FlagName()))
+			continue
+		}
+
+		if len(secretFile) > 0 {
+			value.Value, err = readSecretFile(secretFile)
+			if err != nil {
+				report.Add(setting.Key, fmt.Sprintf("unable to read the file given by %s: %s", value.Origin, err.Error()))
+				continue
+			}
+			value.Origin = fmt.Sprintf("%s=%s", value.Origin, secretFile)
+		}
+
+		values[setting.Key] = value
+	}
+
+	configService.values = values
+	configService.configFile = configFilePath
+	return nil
 }
 
-// LoadEnvVarAsStringOrPanic loads an environment variable as a string and panics if it is not there
-func (configService *EnvVarConfigService) loadEnvVarAsStringOrPanic(envVarName string) string {
-	ev, isFound := os.LookupEnv(envVarName)
-	if !isFound {
-		msg := fmt.Sprintf("Environment variable '%s' not found", envVarName)
-		panic(msg)
+// warnUnknownKeys warns about keys in the config file which don't match a setting, they're most likely typos
+func (configService *LayeredConfigService) warnUnknownKeys(configFilePath string, fileValues map[string]string) {
+	known := make(map[string]bool)
+	for _, setting := range configSettings {
+		known[setting.Key] = true
+		if setting.Secret {
+			known[setting.SecretFileKey()] = true
+		}
+	}
+
+	unknown := make([]string, 0)
+	for key := range fileValues {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
 	}
+	sort.Strings(unknown)
 
-	configService.logger.Debug(fmt.Sprintf("Environment variable '%s' found as '%s'", envVarName, ev), make(Fields))
-	return ev
+	if len(unknown) > 0 {
+		configService.logger.Warn("Config file contains unknown keys", Fields{"path": configFilePath, "keys": unknown})
+	}
+}
+
+// readSecretFile reads a secret from a file, ignoring any trailing newline as most tools that write secrets add one
+func readSecretFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+// loadConfigFile loads a YAML or TOML config file, chosen by its extension, as a flat map of dotted keys to values
+func loadConfigFile(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file '%s': %s", path, err.Error())
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(contents), &raw)
+	default:
+		return nil, fmt.Errorf("config file '%s' must be .yaml, .yml or .toml", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file '%s': %s", path, err.Error())
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", raw, values)
+	return values, nil
+}
+
+// flattenConfig flattens nested config file tables into dotted keys
+func flattenConfig(prefix string, raw interface{}, values map[string]string) {
+	switch typed := raw.(type) {
+	case map[string]interface{}:
+		for key, value := range typed {
+			flattenConfig(joinConfigKey(prefix, key), value, values)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range typed {
+			flattenConfig(joinConfigKey(prefix, fmt.Sprint(key)), value, values)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(typed))
+		for _, item := range typed {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[prefix] = strings.Join(items, ",")
+	case nil:
+		values[prefix] = ""
+	default:
+		values[prefix] = fmt.Sprint(typed)
+	}
+}
+
+func joinConfigKey(prefix string, key string) string {
+	if len(prefix) <= 0 {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// splitConfigList splits a comma delimited config value into its non-empty, trimmed items
+func splitConfigList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // splitBrokerList splits the comma delimited broker string into a slice of brokers
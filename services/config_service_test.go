@@ -0,0 +1,491 @@
+package services
+
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// requiredConfigEnv sets every required setting so the config can be loaded
+var requiredConfigEnv = map[string]string{
+	envVarEmailSender:    "sender@seacitysoftware.com",
+	envVarEmailRecipient: "recipient@seacitysoftware.com",
+	envVarAwsSesRegion:   "eu-west-1",
+	enVarAwsSesAccessKey: "access-key",
+	enVarAwsSesSecretKey: "secret-key",
+	enVarRecaptchaSecret: "recaptcha-secret",
+
+	envVarRecaptchaSiteKey: "recaptcha-site-key",
+
+	envVarSiteName:            "Sea City Software",
+	envVarSiteBaseURL:         "https://www.seacitysoftware.com",
+	envVarSiteCompanyNumber:   "11117271",
+	envVarSiteCopyrightHolder: "Sea City Software Ltd",
+	envVarSiteContactEmail:    "info@seacitysoftware.com",
+}
+
+func newTestConfigService(args []string, env map[string]string) *LayeredConfigService {
+	configService := NewLayeredConfigService(&noopLogger{}, args)
+	configService.lookupEnv = func(key string) (string, bool) {
+		value, isFound := env[key]
+		return value, isFound
+	}
+	return configService
+}
+
+func writeTestConfigFile(t *testing.T, name string, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err, "unable to create temp dir")
+
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(contents), 0600)
+	assert.NoError(t, err, "unable to write config file")
+	return path
+}
+
+func copyEnv(env map[string]string) map[string]string {
+	copied := make(map[string]string)
+	for key, value := range env {
+		copied[key] = value
+	}
+	return copied
+}
+
+func TestConfigDefaultsAreUsedForOptionalValues(t *testing.T) {
+	configService := newTestConfigService(nil, requiredConfigEnv)
+
+	appConfig := configService.LoadConfig()
+
+	assert.Equal(t, 8080, appConfig.HttpPort)
+	assert.Equal(t, "Website contact form", appConfig.EmailConfig.Subject)
+	assert.Equal(t, ConfigSourceDefault, configService.values[configKeyHttpPort].Source)
+}
+
+func TestConfigPrecedenceIsFlagThenEnvThenFile(t *testing.T) {
+	path := writeTestConfigFile(t, "config.yaml", `
+http:
+  port: 1000
+email:
+  subject: From file
+  recipient: file@seacitysoftware.com
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	env := copyEnv(requiredConfigEnv)
+	delete(env, envVarEmailRecipient)
+	env[envVarHttpPort] = "2000"
+	env[envVarEmailSubject] = "From env"
+
+	configService := newTestConfigService([]string{"-config", path, "-http-port", "3000"}, env)
+
+	appConfig := configService.LoadConfig()
+
+	assert.Equal(t, 3000, appConfig.HttpPort)
+	assert.Equal(t, ConfigSourceFlag, configService.values[configKeyHttpPort].Source)
+	assert.Equal(t, "From env", appConfig.EmailConfig.Subject)
+	assert.Equal(t, ConfigSourceEnv, configService.values[configKeyEmailSubject].Source)
+	assert.Equal(t, "file@seacitysoftware.com", appConfig.EmailConfig.Recipient)
+	assert.Equal(t, ConfigSourceFile, configService.values[configKeyEmailRecipient].Source)
+}
+
+func TestConfigCanBeLoadedFromToml(t *testing.T) {
+	path := writeTestConfigFile(t, "config.toml", `
+[http]
+port = 4000
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	env := copyEnv(requiredConfigEnv)
+	env[envVarConfigFile] = path
+
+	appConfig := newTestConfigService(nil, env).LoadConfig()
+
+	assert.Equal(t, 4000, appConfig.HttpPort)
+}
+
+func TestConfigMissingRequiredValuesPanics(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	delete(env, envVarEmailSender)
+
+	assert.Panics(t, func() {
+		newTestConfigService(nil, env).LoadConfig()
+	})
+}
+
+func TestConfigDumpMasksSecrets(t *testing.T) {
+	configService := newTestConfigService(nil, requiredConfigEnv)
+	configService.LoadConfig()
+
+	dump := configService.Dump()
+
+	assert.Contains(t, dump, "email.aws_ses_secret_key="+maskedConfigValue+" (env: AWS_SES_SECRET_KEY)")
+	assert.Contains(t, dump, "http.port=8080 (default)")
+	assert.NotContains(t, dump, "secret-key")
+	assert.NotContains(t, dump, "recaptcha-secret")
+}
+
+// noopLogger discards everything logged
+type noopLogger struct{}
+
+func (*noopLogger) Debug(message string, fields Fields) {}
+func (*noopLogger) Info(message string, fields Fields)  {}
+func (*noopLogger) Warn(message string, fields Fields)  {}
+func (*noopLogger) Error(message string, fields Fields) {}
+
+func (logger *noopLogger) With(fields Fields) Logger              { return logger }
+func (logger *noopLogger) WithContext(ctx context.Context) Logger { return logger }
+
+func TestConfigSecretsCanBeReadFromFiles(t *testing.T) {
+	path := writeTestConfigFile(t, "recaptcha-secret", "secret-from-file\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	env := copyEnv(requiredConfigEnv)
+	delete(env, enVarRecaptchaSecret)
+	env[enVarRecaptchaSecret+secretFileSuffix] = path
+
+	configService := newTestConfigService(nil, env)
+	appConfig := configService.LoadConfig()
+
+	assert.Equal(t, "secret-from-file", appConfig.RecaptchaSecret.Reveal())
+	assert.Equal(t, ConfigSourceEnv, configService.values[configKeyRecaptchaSecret].Source)
+	assert.NotContains(t, configService.Dump(), "secret-from-file")
+}
+
+func TestConfigSecretFileFlagTakesPrecedenceOverEnv(t *testing.T) {
+	path := writeTestConfigFile(t, "aws-secret", "secret-from-file")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	configService := newTestConfigService([]string{"-email-aws-ses-secret-key-file", path}, requiredConfigEnv)
+	appConfig := configService.LoadConfig()
+
+	assert.Equal(t, "secret-from-file", appConfig.EmailConfig.AwsSesSecretKey.Reveal())
+	assert.Equal(t, ConfigSourceFlag, configService.values[configKeyAwsSesSecretKey].Source)
+}
+
+func TestConfigMissingSecretFilePanics(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	delete(env, enVarRecaptchaSecret)
+	env[enVarRecaptchaSecret+secretFileSuffix] = "/does/not/exist"
+
+	assert.Panics(t, func() {
+		newTestConfigService(nil, env).LoadConfig()
+	})
+}
+
+func TestConfigReportsEveryProblem(t *testing.T) {
+	env := map[string]string{
+		envVarHttpPort:       "70000",
+		envVarFrontendDir:    "/does/not/exist",
+		envVarEmailSender:    "not an email",
+		envVarAwsSesRegion:   "moon-north-1",
+		enVarAwsSesAccessKey: "access-key",
+		enVarAwsSesSecretKey: "secret-key",
+	}
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+
+	fields := make([]string, 0)
+	for _, problem := range report.Problems {
+		fields = append(fields, problem.Field)
+	}
+	assert.ElementsMatch(t, []string{
+		configKeyHttpPort,
+		configKeyFrontendDir,
+		configKeyEmailSender,
+		configKeyEmailRecipient,
+		configKeyAwsSesRegion,
+		configKeyRecaptchaSecret,
+		configKeySiteName,
+		configKeySiteBaseURL,
+		configKeySiteCompanyNumber,
+		configKeySiteCopyrightHolder,
+		configKeySiteContactEmail,
+		configKeyRecaptchaSiteKey,
+	}, fields)
+}
+
+func TestConfigDoesNotDefaultToTheProductionAnalyticsOrRecaptchaSiteKey(t *testing.T) {
+	appConfig, err := newTestConfigService(nil, requiredConfigEnv).TryLoadConfig()
+	assert.NoError(t, err)
+	assert.Empty(t, appConfig.Site.AnalyticsIDs)
+
+	env := copyEnv(requiredConfigEnv)
+	delete(env, envVarRecaptchaSiteKey)
+
+	_, err = newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.Len(t, report.Problems, 1)
+	assert.True(t, report.HasProblem(configKeyRecaptchaSiteKey))
+}
+
+func TestConfigReportsAnEmptySiteIdentity(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	for _, envVar := range []string{envVarSiteName, envVarSiteBaseURL, envVarSiteCompanyNumber, envVarSiteCopyrightHolder, envVarSiteContactEmail} {
+		env[envVar] = ""
+	}
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.Len(t, report.Problems, 5)
+	for _, key := range []string{configKeySiteName, configKeySiteBaseURL, configKeySiteCompanyNumber, configKeySiteCopyrightHolder, configKeySiteContactEmail} {
+		assert.True(t, report.HasProblem(key), key)
+	}
+}
+
+func TestConfigReportsAPortThatIsNotANumber(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarHttpPort] = "eighty"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "http.port: 'eighty' is not a number")
+}
+
+func TestConfigAwsSesCredentialsCanBeOmitted(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	delete(env, enVarAwsSesAccessKey)
+	delete(env, enVarAwsSesSecretKey)
+	env[envVarAwsSesEndpoint] = "http://localhost:4579"
+
+	appConfig, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	assert.NoError(t, err)
+	assert.False(t, appConfig.EmailConfig.HasStaticCredentials())
+	assert.Equal(t, "http://localhost:4579", appConfig.EmailConfig.AwsSesEndpoint)
+}
+
+func TestConfigReportsPartialAwsSesCredentialsAndABadEndpoint(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	delete(env, enVarAwsSesSecretKey)
+	env[envVarAwsSesEndpoint] = "localhost:4579"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyAwsSesSecretKey))
+	assert.True(t, report.HasProblem(configKeyAwsSesEndpoint))
+	assert.False(t, report.HasProblem(configKeyAwsSesAccessKey))
+}
+
+func TestConfigReportsTimeoutsAndSizesThatCantBeParsed(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarReadTimeout] = "ten seconds"
+	env[envVarUploadBodyLimit] = "lots"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "http.read_timeout: 'ten seconds' is not a duration")
+	assert.Contains(t, err.Error(), "http.upload_body_limit: 'lots' is not a size")
+}
+
+func TestConfigParsesTimeoutsAndSizes(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarWriteTimeout] = "1m"
+	env[envVarBodyLimit] = "8KB"
+
+	appConfig, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, appConfig.WriteTimeout)
+	assert.Equal(t, 5*time.Second, appConfig.ReadHeaderTimeout)
+	assert.Equal(t, int64(8*1024), appConfig.BodyLimit)
+	assert.Equal(t, int64(1024*1024), appConfig.UploadBodyLimit)
+}
+
+func TestConfigReportsIncompleteTLSSettings(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarTlsCertFile] = "/does/not/exist.pem"
+	env[envVarTlsAcmeDomains] = "www.seacitysoftware.com"
+	env[envVarTlsHttpsPort] = "8080"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyTlsCertFile))
+	assert.True(t, report.HasProblem(configKeyTlsKeyFile))
+	assert.True(t, report.HasProblem(configKeyTlsAcmeDomains))
+	assert.True(t, report.HasProblem(configKeyTlsHttpsPort))
+}
+
+func TestConfigReportsAMetricsPortInUse(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarMetricsPort] = "8080"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyMetricsPort))
+}
+
+func TestConfigReportsInvalidLogSettings(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarLogLevel] = "loud"
+	env[envVarLogFormat] = "xml"
+	env[envVarLogOutputs] = "stdout,file,papertrail"
+	env[envVarLogFieldLevels] = "ip:loud"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyLogLevel))
+	assert.True(t, report.HasProblem(configKeyLogFormat))
+	assert.True(t, report.HasProblem(configKeyLogOutputs))
+	assert.True(t, report.HasProblem(configKeyLogFile))
+	assert.True(t, report.HasProblem(configKeyLogFieldLevels))
+}
+
+func TestConfigDebugLogDoesNotContainSecretsOrPersonalData(t *testing.T) {
+	logger, buffer := newBufferedLogrusLogger()
+	assert.NoError(t, logger.SetLevel(domain.LogLevelDebug))
+
+	configService := NewLayeredConfigService(logger, nil)
+	configService.lookupEnv = func(key string) (string, bool) {
+		value, isFound := requiredConfigEnv[key]
+		return value, isFound
+	}
+	configService.LoadConfig()
+
+	assert.Contains(t, buffer.String(), "Resolved config value")
+	assert.NotContains(t, buffer.String(), "secret-key")
+	assert.NotContains(t, buffer.String(), "recaptcha-secret")
+	assert.NotContains(t, buffer.String(), "recipient@seacitysoftware.com")
+}
+
+func TestConfigReportsInvalidErrorReportingSettingsWithoutTheDsn(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarErrorReportingDsn] = "sentry.example.com/super-secret-key"
+	env[envVarErrorReportingSampleRate] = "2"
+	env[envVarErrorReportingRateLimit] = "0"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyErrorReportingDsn))
+	assert.True(t, report.HasProblem(configKeyErrorReportingSampleRate))
+	assert.True(t, report.HasProblem(configKeyErrorReportingRateLimit))
+	assert.NotContains(t, report.Error(), "super-secret-key")
+}
+
+func TestConfigReportsInvalidCspSettings(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarCspScriptSrc] = "'self',https://evil.example.com; script-src *"
+	env[envVarCspReportURI] = "csp-reports"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyCspScriptSrc))
+	assert.True(t, report.HasProblem(configKeyCspReportURI))
+}
+
+func TestConfigReadsSecurityHeaderOverrides(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarSecurityHeadersOverrides] = "/admin referrer-policy=no-referrer | /contact Permissions-Policy=camera=(), payment=(self) | /.well-known/ strict-transport-security="
+
+	appConfig := newTestConfigService(nil, env).LoadConfig()
+
+	assert.Equal(t, []domain.SecurityHeadersOverride{
+		{PathPrefix: "/admin", Header: domain.ReferrerPolicyHeader, Value: "no-referrer"},
+		{PathPrefix: "/contact", Header: domain.PermissionsPolicyHeader, Value: "camera=(), payment=(self)"},
+		{PathPrefix: "/.well-known/", Header: domain.StrictTransportSecurityHeader, Value: ""},
+	}, appConfig.SecurityHeaders.Overrides)
+	assert.Equal(t, "DENY", appConfig.SecurityHeaders.FrameOptions)
+	assert.Equal(t, "same-origin", appConfig.SecurityHeaders.CrossOriginResourcePolicy)
+}
+
+func TestConfigReportsInvalidSecurityHeaderSettings(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarSecurityHeadersReferrerPolicy] = "everything"
+	env[envVarSecurityHeadersCrossOriginOpenerPolicy] = "same-site"
+	env[envVarSecurityHeadersCrossOriginResourcePolicy] = "anywhere"
+	env[envVarSecurityHeadersOverrides] = "/admin Set-Cookie=session=1"
+	env[envVarTlsHstsMaxAge] = "24h"
+	env[envVarTlsHstsPreload] = "true"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeySecurityHeadersReferrerPolicy))
+	assert.True(t, report.HasProblem(configKeySecurityHeadersCrossOriginOpenerPolicy))
+	assert.True(t, report.HasProblem(configKeySecurityHeadersCrossOriginResourcePolicy))
+	assert.True(t, report.HasProblem(configKeySecurityHeadersOverrides))
+	assert.True(t, report.HasProblem(configKeyTlsHstsPreload))
+
+	for _, overrides := range []string{"admin Referrer-Policy", "/admin Strict-Transport-Security=includeSubDomains"} {
+		env = copyEnv(requiredConfigEnv)
+		env[envVarSecurityHeadersOverrides] = overrides
+		_, err = newTestConfigService(nil, env).TryLoadConfig()
+		report, ok = err.(*domain.ValidationReport)
+		assert.True(t, ok, "expected a validation report for '%s'", overrides)
+		assert.True(t, report.HasProblem(configKeySecurityHeadersOverrides), overrides)
+	}
+}
+
+func TestConfigReportsInvalidReportingAndAdminSettings(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarReportingRateLimit] = "0"
+	env[envVarReportingDedupeWindow] = "-1m"
+	env[envVarReportingMaxReports] = "lots"
+	env[envVarAdminUsername] = ""
+	env[envVarAdminPassword] = "admin-password"
+
+	_, err := newTestConfigService(nil, env).TryLoadConfig()
+
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyReportingRateLimit))
+	assert.True(t, report.HasProblem(configKeyReportingDedupeWindow))
+	assert.True(t, report.HasProblem(configKeyReportingMaxReports))
+	assert.True(t, report.HasProblem(configKeyAdminUsername))
+	assert.NotContains(t, err.Error(), "admin-password")
+}
+
+func TestConfigReadsTheCompressionSettings(t *testing.T) {
+	env := copyEnv(requiredConfigEnv)
+	env[envVarCompressionMinSize] = "2KB"
+	env[envVarCompressionPrecompress] = "false"
+
+	appConfig, err := newTestConfigService(nil, env).TryLoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.CompressionConfig{Enabled: true, MinSize: 2048, Precompress: false}, appConfig.Compression)
+
+	env[envVarCompressionMinSize] = "small"
+	_, err = newTestConfigService(nil, env).TryLoadConfig()
+	report, ok := err.(*domain.ValidationReport)
+	assert.True(t, ok, "expected a validation report")
+	assert.True(t, report.HasProblem(configKeyCompressionMinSize))
+}
+
+func TestValidatingAConfigWithoutTheRequiredSectionsReportsThemWithoutAddingThem(t *testing.T) {
+	appConfig := &domain.AppConfig{}
+
+	report := &domain.ValidationReport{}
+	appConfig.ValidateInto(report)
+
+	assert.True(t, report.HasProblem(configKeyEmailSender))
+	assert.True(t, report.HasProblem(configKeySiteName))
+	assert.Nil(t, appConfig.EmailConfig)
+	assert.Nil(t, appConfig.Site)
+	assert.Nil(t, appConfig.TLS)
+}
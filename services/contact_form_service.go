@@ -1,22 +1,26 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"github.com/adbourne/website-seacitysoftware/domain"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
 )
 
 // ContactFormService is a service concerned with contact forms
 type ContactFormService interface {
-	// Process proceses the submitted contact form
-	Process(contactForm *domain.ContactForm) error
+	// Process proceses the submitted contact form, it gives up when the context is done
+	Process(ctx context.Context, contactForm *domain.ContactForm) error
 }
 
 // emailTemplate is the printf template for emails
 const emailTemplate = `
+Reference: %s
 Name: %s
 Email: %s
 Company: %s
@@ -37,9 +41,16 @@ type ContactFormEmailService struct {
 
 	// SesClient is the AWS Simple Email Service (SES) client
 	SesClient *ses.SES
+
+	Metrics Metrics
 }
 
-func (service *ContactFormEmailService) Process(contactForm *domain.ContactForm) (err error) {
+func (service *ContactFormEmailService) Process(ctx context.Context, contactForm *domain.ContactForm) (err error) {
+	ctx, span := startSpan(ctx, "ContactFormService.Process", attribute.String("submission.id", contactForm.ID))
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
 
 	sesClient := service.SesClient
 	sender := service.EmailConfig.Sender
@@ -76,61 +87,90 @@ func (service *ContactFormEmailService) Process(contactForm *domain.ContactForm)
 	}
 
 	// Attempt to send the email.
-	result, err := sesClient.SendEmail(sendEmailInput)
+	start := time.Now()
+	result, err := sesClient.SendEmailWithContext(ctx, sendEmailInput)
+	service.Metrics.ObserveDependencyCall(DependencySes, sesErrorCode(err), time.Since(start))
 
 	// Display error messages if they occur.
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case ses.ErrCodeMessageRejected:
-				service.logSendEmailError(textBody, ses.ErrCodeMessageRejected, aerr.Error())
+				service.logSendEmailError(ctx, contactForm.ID, ses.ErrCodeMessageRejected, aerr.Error())
 				err = errors.New(AwsSesRejectedMessageError)
 				return
 
 			case ses.ErrCodeMailFromDomainNotVerifiedException:
-				service.logSendEmailError(textBody, ses.ErrCodeMailFromDomainNotVerifiedException, aerr.Error())
+				service.logSendEmailError(ctx, contactForm.ID, ses.ErrCodeMailFromDomainNotVerifiedException, aerr.Error())
 				err = errors.New(AwsSesMailFromDomainNotVerifiedError)
 				return
 
 			case ses.ErrCodeConfigurationSetDoesNotExistException:
-				service.logSendEmailError(textBody, ses.ErrCodeConfigurationSetDoesNotExistException, aerr.Error())
+				service.logSendEmailError(ctx, contactForm.ID, ses.ErrCodeConfigurationSetDoesNotExistException, aerr.Error())
 				err = errors.New(AwsSesConfigurationSetDoesNotExistError)
 				return
 
 			default:
-				service.logSendEmailError(textBody, "UNKNOWN", aerr.Error())
+				service.logSendEmailError(ctx, contactForm.ID, "UNKNOWN", aerr.Error())
 				err = errors.New(AwsSesUnknownError)
 				return
 
 			}
 		} else {
-			service.logSendEmailError(textBody, "UNKNOWN", err.Error())
+			service.logSendEmailError(ctx, contactForm.ID, "UNKNOWN", err.Error())
 			err = errors.New(AwsSesUnknownError)
 			return
 		}
-
-		return
 	}
 
 	if nil != result.MessageId {
-		service.Logger.Info(fmt.Sprintf("Contact form email sent with id '%s'", *result.MessageId), Fields{})
+		service.Logger.WithContext(ctx).Info(fmt.Sprintf("Contact form email sent with id '%s'", *result.MessageId), Fields{"submissionId": contactForm.ID})
 	} else {
-		service.Logger.Info("Contact form email sent with but no message ID was provided", Fields{})
+		service.Logger.WithContext(ctx).Info("Contact form email sent with but no message ID was provided", Fields{"submissionId": contactForm.ID})
 	}
 
 	return
 }
 
-func (service *ContactFormEmailService) logSendEmailError(textBody string, reason string, error string) {
-	service.Logger.Error("unable to send email", Fields{
-		"email":  textBody,
-		"reason": reason,
-		"error":  error,
+// sesErrorCode is the code recorded in the metrics for the result of a call to SES
+func sesErrorCode(err error) string {
+	if err == nil {
+		return DependencyCallOK
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "UNKNOWN"
+}
+
+// CheckHealth checks AWS SES can be reached with the configured credentials by asking for the account's sending quota
+func (service *ContactFormEmailService) CheckHealth(ctx context.Context) error {
+	_, err := service.SesClient.GetSendQuotaWithContext(ctx, &ses.GetSendQuotaInput{})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return errors.Errorf("%s: %s", aerr.Code(), aerr.Message())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// logSendEmailError logs the submission's ID, the SES error code, or UNKNOWN, and SES's error message when its email
+// couldn't be sent. The email isn't logged as it is full of the submitter's personal details; the ID is the reference
+// the submitter was given, so it ties the failure to them if they get in touch
+func (service *ContactFormEmailService) logSendEmailError(ctx context.Context, submissionID string, reason string, error string) {
+	service.Logger.WithContext(ctx).Error("unable to send email", Fields{
+		"submissionId": submissionID,
+		"reason":       reason,
+		"error":        error,
 	})
 }
 
 func contactFormToEmailBody(contactForm *domain.ContactForm) string {
 	return fmt.Sprintf(emailTemplate,
+		contactForm.ID,
 		contactForm.Name,
 		contactForm.Email,
 		contactForm.Company,
@@ -140,10 +180,11 @@ func contactFormToEmailBody(contactForm *domain.ContactForm) string {
 }
 
 // NewContactFormEmailService creates a new ContactFormEmailService
-func NewContactFormEmailService(logger Logger, emailConfig *domain.EmailConfig, sesClient *ses.SES) ContactFormService {
+func NewContactFormEmailService(logger Logger, emailConfig *domain.EmailConfig, sesClient *ses.SES, metrics Metrics) ContactFormService {
 	return &ContactFormEmailService{
 		Logger:      logger,
 		EmailConfig: emailConfig,
 		SesClient:   sesClient,
+		Metrics:     metrics,
 	}
 }
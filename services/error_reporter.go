@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"net/http"
+)
+
+// ErrorEvent is an error reported to the error tracker
+type ErrorEvent struct {
+	// IncidentID is the reference for the error, shown to visitors so they can quote it
+	IncidentID string
+
+	// Err is the error, or the value of the panic when one was recovered
+	Err error
+
+	// Stack is the stack trace of the goroutine the error happened on
+	Stack []byte
+
+	// Panic is true when the error was recovered from a panic
+	Panic bool
+
+	// Request is the request the error happened handling, if any. Only the parts of it which can't hold personal
+	// details are reported
+	Request *http.Request
+
+	// Tags describe where the error happened, e.g. the background worker
+	Tags map[string]string
+}
+
+// ErrorReporter reports errors to an error tracker, so they're seen by someone rather than sitting in the logs
+type ErrorReporter interface {
+	// Report reports the error, the request ID and trace in the context identify the request it happened handling
+	Report(ctx context.Context, event *ErrorEvent)
+}
+
+// NoopErrorReporter is an ErrorReporter discarding every error, used when no error tracker is configured
+type NoopErrorReporter struct{}
+
+func (*NoopErrorReporter) Report(ctx context.Context, event *ErrorEvent) {}
+
+func NewNoopErrorReporter() *NoopErrorReporter {
+	return &NoopErrorReporter{}
+}
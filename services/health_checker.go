@@ -0,0 +1,9 @@
+package services
+
+import "context"
+
+// HealthChecker is implemented by services which can check the dependencies they need are configured and reachable
+type HealthChecker interface {
+	// CheckHealth returns an error if the service couldn't do its job right now, it gives up when the context is done
+	CheckHealth(ctx context.Context) error
+}
@@ -1,6 +1,22 @@
 package services
 
-import "github.com/sirupsen/logrus"
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	logrussyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// syslogTag is the tag lines written to syslog are given
+const syslogTag = "website-seacitysoftware"
 
 type Fields map[string]interface{}
 
@@ -9,30 +25,293 @@ type Logger interface {
 	Info(message string, fields Fields)
 	Warn(message string, fields Fields)
 	Error(message string, fields Fields)
+
+	// With creates a child logger adding the fields to every line it writes
+	With(fields Fields) Logger
+
+	// WithContext creates a child logger adding the request ID and trace in the context to every line it writes, so
+	// the lines written while handling a request can be found together
+	WithContext(ctx context.Context) Logger
 }
 
+// LogrusLogger is an implementation of Logger writing through its own logrus logger, which its children share
 type LogrusLogger struct {
 	Logger *logrus.Logger
+
+	// fields are added to every line
+	fields Fields
+
+	// outputs are the outputs opened by Configure and the redaction applied to every line, shared with the children
+	outputs *logrusOutputs
+}
+
+// logrusOutputs are the outputs a LogrusLogger has opened, so they can be flushed and closed, and the redactor masking
+// personal data before it reaches them
+type logrusOutputs struct {
+	mutex sync.Mutex
+
+	closers []io.Closer
+
+	// syncers sync the outputs written to files to disk
+	syncers []func() error
+
+	// redactor holds the *Redactor, it is read without the mutex for every line so Configure swaps it atomically
+	redactor atomic.Value
+}
+
+// currentRedactor returns the redactor masking personal data, nil when nothing is masked
+func (outputs *logrusOutputs) currentRedactor() *Redactor {
+	redactor, _ := outputs.redactor.Load().(*Redactor)
+	return redactor
+}
+
+// log writes a line with the logger's fields and the fields of the line, once the personal data in them is masked
+func (logger *LogrusLogger) log(level logrus.Level, message string, fields Fields) {
+	// The level is read atomically as SetLevel can change it while lines are being logged
+	if logrus.Level(atomic.LoadUint32((*uint32)(&logger.Logger.Level))) < level {
+		return
+	}
+
+	lineFields := make(Fields, len(logger.fields)+len(fields))
+	for key, value := range logger.fields {
+		lineFields[key] = value
+	}
+	for key, value := range fields {
+		lineFields[key] = value
+	}
+
+	if redactor := logger.outputs.currentRedactor(); redactor != nil {
+		message, lineFields = redactor.Redact(level, message, lineFields)
+	}
+
+	entry := logger.Logger.WithFields(logrus.Fields(lineFields))
+	switch level {
+	case logrus.DebugLevel:
+		entry.Debug(message)
+	case logrus.InfoLevel:
+		entry.Info(message)
+	case logrus.WarnLevel:
+		entry.Warn(message)
+	default:
+		entry.Error(message)
+	}
+}
+
+func (logger *LogrusLogger) Debug(message string, fields Fields) {
+	logger.log(logrus.DebugLevel, message, fields)
+}
+
+func (logger *LogrusLogger) Info(message string, fields Fields) {
+	logger.log(logrus.InfoLevel, message, fields)
+}
+
+func (logger *LogrusLogger) Warn(message string, fields Fields) {
+	logger.log(logrus.WarnLevel, message, fields)
+}
+
+func (logger *LogrusLogger) Error(message string, fields Fields) {
+	logger.log(logrus.ErrorLevel, message, fields)
+}
+
+func (logger *LogrusLogger) With(fields Fields) Logger {
+	if len(fields) <= 0 {
+		return logger
+	}
+
+	childFields := Fields{}
+	for key, value := range logger.fields {
+		childFields[key] = value
+	}
+	for key, value := range fields {
+		childFields[key] = value
+	}
+
+	return &LogrusLogger{
+		Logger:  logger.Logger,
+		fields:  childFields,
+		outputs: logger.outputs,
+	}
+}
+
+func (logger *LogrusLogger) WithContext(ctx context.Context) Logger {
+	return logger.With(requestFields(ctx, nil))
+}
+
+// Configure sets the level, format, outputs and redaction of the logger from the config. It should be called before the
+// logger is shared between goroutines, only the level can be changed safely afterwards
+func (logger *LogrusLogger) Configure(logConfig *domain.LogConfig) error {
+	err := logger.SetLevel(logConfig.Level)
+	if err != nil {
+		return err
+	}
+
+	redactor, err := NewRedactor(logConfig)
+	if err != nil {
+		return err
+	}
+
+	logger.Logger.Formatter = newLogrusFormatter(logConfig.Format)
+
+	writers := []io.Writer{}
+	closers := []io.Closer{}
+	syncers := []func() error{}
+	hooks := logrus.LevelHooks{}
+
+	if logConfig.HasOutput(domain.LogOutputStdout) {
+		writers = append(writers, os.Stdout)
+		syncers = append(syncers, func() error { return syncFile(os.Stdout) })
+	}
+
+	if logConfig.HasOutput(domain.LogOutputFile) {
+		file := &lumberjack.Logger{
+			Filename:   logConfig.File,
+			MaxSize:    logConfig.FileMaxMegabytes,
+			MaxBackups: logConfig.FileMaxBackups,
+		}
+		writers = append(writers, file)
+		closers = append(closers, file)
+
+		// lumberjack doesn't expose the file it is writing, so the file is synced through a handle of its own
+		fileName := logConfig.File
+		syncers = append(syncers, func() error { return syncFileNamed(fileName) })
+	}
+
+	if logConfig.HasOutput(domain.LogOutputSyslog) {
+		network, address, err := logConfig.SyslogNetworkAddress()
+		if err != nil {
+			return err
+		}
+
+		hook, err := logrussyslog.NewSyslogHook(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, syslogTag)
+		if err != nil {
+			return errors.Wrap(err, "unable to connect to syslog")
+		}
+		// Each line is sent to syslog as it is written, so there is nothing held back to flush
+		hooks.Add(hook)
+		closers = append(closers, hook.Writer)
+	}
+
+	switch len(writers) {
+	case 0:
+		logger.Logger.Out = ioutil.Discard
+	case 1:
+		logger.Logger.Out = writers[0]
+	default:
+		logger.Logger.Out = io.MultiWriter(writers...)
+	}
+	logger.Logger.Hooks = hooks
+
+	logger.Close()
+	logger.outputs.mutex.Lock()
+	defer logger.outputs.mutex.Unlock()
+	logger.outputs.closers = closers
+	logger.outputs.syncers = syncers
+	logger.outputs.redactor.Store(redactor)
+	return nil
+}
+
+// SetLevel sets the least severe level logged, it is safe to call while the logger is in use
+func (logger *LogrusLogger) SetLevel(level string) error {
+	logrusLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	logger.Logger.SetLevel(logrusLevel)
+	return nil
+}
+
+// newLogrusFormatter creates the formatter for the log format
+func newLogrusFormatter(format string) logrus.Formatter {
+	switch format {
+	case domain.LogFormatText:
+		return &logrus.TextFormatter{FullTimestamp: true}
+	case domain.LogFormatLogfmt:
+		return &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	default:
+		return &logrus.JSONFormatter{}
+	}
+}
+
+// Flush syncs every output Configure opened which writes to a file to disk, including the log file and stdout when it
+// is redirected to a file
+func (logger *LogrusLogger) Flush() error {
+	logger.outputs.mutex.Lock()
+	defer logger.outputs.mutex.Unlock()
+
+	var flushErr error
+	for _, sync := range logger.outputs.syncers {
+		err := sync()
+		if err != nil && flushErr == nil {
+			flushErr = err
+		}
+	}
+	return flushErr
 }
 
-func (*LogrusLogger) Debug(message string, fields Fields) {
-	logrus.WithFields(logrus.Fields(fields)).Debug(message)
+// syncFile syncs the file to disk when it is a regular file, terminals and pipes can't be synced
+func syncFile(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return file.Sync()
+}
+
+// syncFileNamed syncs the file with the name to disk, if it has been created
+func syncFileNamed(name string) error {
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
 }
 
-func (*LogrusLogger) Info(message string, fields Fields) {
-	logrus.WithFields(logrus.Fields(fields)).Info(message)
+// Close closes the outputs opened by Configure
+func (logger *LogrusLogger) Close() error {
+	logger.outputs.mutex.Lock()
+	defer logger.outputs.mutex.Unlock()
+
+	var closeErr error
+	for _, closer := range logger.outputs.closers {
+		err := closer.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	logger.outputs.closers = nil
+	return closeErr
 }
 
-func (*LogrusLogger) Warn(message string, fields Fields) {
-	logrus.WithFields(logrus.Fields(fields)).Warn(message)
+// Flusher is implemented by loggers which can flush their output
+type Flusher interface {
+	Flush() error
+}
+
+// FlushLogger flushes the logger's output, if it supports it, so nothing is lost when the application exits
+func FlushLogger(logger Logger) error {
+	if flusher, ok := logger.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
 }
 
-func (*LogrusLogger) Error(message string, fields Fields) {
-	logrus.WithFields(logrus.Fields(fields)).Error(message)
+// LevelSetter is implemented by loggers whose level can be changed while they're in use
+type LevelSetter interface {
+	SetLevel(level string) error
 }
 
+// NewLogrusLogger creates a LogrusLogger masking the default redacted fields and any personal data detected, until it
+// is configured
 func NewLogrusLogger(logger *logrus.Logger) *LogrusLogger {
+	outputs := &logrusOutputs{}
+	outputs.redactor.Store(NewDefaultRedactor())
 	return &LogrusLogger{
-		Logger: logger,
+		Logger:  logger,
+		outputs: outputs,
 	}
 }
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBufferedLogrusLogger creates a logger writing JSON to the returned buffer
+func newBufferedLogrusLogger() (*LogrusLogger, *bytes.Buffer) {
+	buffer := &bytes.Buffer{}
+	logrusLogger := logrus.New()
+	logrusLogger.Out = buffer
+	logrusLogger.Formatter = &logrus.JSONFormatter{}
+	return NewLogrusLogger(logrusLogger), buffer
+}
+
+func TestChildLoggersAddTheirFieldsToEveryLine(t *testing.T) {
+	logger, buffer := newBufferedLogrusLogger()
+
+	ctx := ContextWithRequestID(context.Background(), "request-1")
+	logger.With(Fields{"component": "test"}).WithContext(ctx).Info("hello", Fields{"count": 2})
+	logger.Info("no fields", Fields{})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	line := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "hello", line["msg"])
+	assert.Equal(t, "test", line["component"])
+	assert.Equal(t, "request-1", line["requestId"])
+	assert.Equal(t, float64(2), line["count"])
+
+	// The parent is unchanged
+	line = map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+	assert.NotContains(t, line, "component")
+	assert.NotContains(t, line, "requestId")
+}
+
+func TestTheLevelAndFormatAreConfigured(t *testing.T) {
+	logger, _ := newBufferedLogrusLogger()
+	dir, err := ioutil.TempDir("", "logs")
+	assert.NoError(t, err, "unable to create temp dir")
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "website.log")
+	err = logger.Configure(&domain.LogConfig{
+		Level:            domain.LogLevelWarn,
+		Format:           domain.LogFormatLogfmt,
+		Outputs:          []string{domain.LogOutputFile},
+		File:             logFile,
+		FileMaxMegabytes: 1,
+	})
+	assert.NoError(t, err)
+
+	logger.Info("not logged", Fields{})
+	logger.Warn("logged", Fields{"submissionId": "1234"})
+	assert.NoError(t, logger.Flush())
+	assert.NoError(t, logger.Close())
+
+	contents, err := ioutil.ReadFile(logFile)
+	assert.NoError(t, err, "unable to read the log file")
+	assert.NotContains(t, string(contents), "not logged")
+	assert.Contains(t, string(contents), `level=warning msg=logged submissionId=1234`)
+
+	// The level can be changed while the logger is in use
+	assert.NoError(t, logger.SetLevel(domain.LogLevelDebug))
+	assert.Equal(t, logrus.DebugLevel, logger.Logger.Level)
+	assert.Error(t, logger.SetLevel("loud"))
+}
+
+func TestLinesCanBeSentToSyslog(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "unable to listen for syslog messages")
+	defer server.Close()
+
+	logger, _ := newBufferedLogrusLogger()
+	err = logger.Configure(&domain.LogConfig{
+		Level:         domain.LogLevelInfo,
+		Format:        domain.LogFormatJSON,
+		Outputs:       []string{domain.LogOutputSyslog},
+		SyslogAddress: "udp://" + server.LocalAddr().String(),
+	})
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	logger.Error("sent to syslog", Fields{})
+	assert.NoError(t, logger.Flush())
+
+	message := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(message)
+	assert.NoError(t, err, "no syslog message was received")
+	assert.Contains(t, string(message[:n]), syslogTag)
+	assert.Contains(t, string(message[:n]), `"msg":"sent to syslog"`)
+}
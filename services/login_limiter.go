@@ -0,0 +1,116 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxTrackedLoginClients is the most clients whose failed logins are counted at once, the window started longest ago
+// is forgotten to make room for a new client
+const maxTrackedLoginClients = 10000
+
+// failedLoginWindow is how many times a client has failed to log in during the current window
+type failedLoginWindow struct {
+	client string
+
+	start time.Time
+
+	failures int
+}
+
+// FailedLoginLimiter locks a client out once it has failed to log in too many times, so passwords can't be guessed by
+// trying one after another. The lock out lasts until the window started by the client's first failure ends. Windows
+// are kept in a list, started longest ago first, so ended windows are expired without looking through the rest
+type FailedLoginLimiter struct {
+	maxFailures int
+
+	window time.Duration
+
+	// now is the current time, replaced in tests
+	now func() time.Time
+
+	mutex sync.Mutex
+
+	// windows are each client's window, the list holds the *failedLoginWindow started longest ago first
+	windows map[string]*list.Element
+
+	windowsByStart *list.List
+}
+
+// NewFailedLoginLimiter creates a FailedLoginLimiter locking clients out once they fail to log in maxFailures times
+// within the window
+func NewFailedLoginLimiter(maxFailures int, window time.Duration) *FailedLoginLimiter {
+	return &FailedLoginLimiter{
+		maxFailures:    maxFailures,
+		window:         window,
+		now:            time.Now,
+		windows:        make(map[string]*list.Element),
+		windowsByStart: list.New(),
+	}
+}
+
+// LockedOut returns how long the client is locked out for, it is zero when the client may try to log in
+func (limiter *FailedLoginLimiter) LockedOut(client string) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.expire(now)
+
+	element, isFound := limiter.windows[client]
+	if !isFound {
+		return 0
+	}
+	window := element.Value.(*failedLoginWindow)
+	if window.failures < limiter.maxFailures {
+		return 0
+	}
+	return window.start.Add(limiter.window).Sub(now)
+}
+
+// Failed counts a failed login by the client, it is true when the client is now locked out
+func (limiter *FailedLoginLimiter) Failed(client string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.expire(now)
+
+	element, isFound := limiter.windows[client]
+	if !isFound {
+		if len(limiter.windows) >= maxTrackedLoginClients {
+			oldest := limiter.windowsByStart.Remove(limiter.windowsByStart.Front()).(*failedLoginWindow)
+			delete(limiter.windows, oldest.client)
+		}
+		element = limiter.windowsByStart.PushBack(&failedLoginWindow{client: client, start: now})
+		limiter.windows[client] = element
+	}
+	window := element.Value.(*failedLoginWindow)
+	window.failures++
+	return window.failures >= limiter.maxFailures
+}
+
+// Succeeded forgets the client's failed logins
+func (limiter *FailedLoginLimiter) Succeeded(client string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if element, isFound := limiter.windows[client]; isFound {
+		limiter.windowsByStart.Remove(element)
+		delete(limiter.windows, client)
+	}
+}
+
+// expire removes the windows which have ended, oldest first, stopping at the first which hasn't. The mutex must be
+// held
+func (limiter *FailedLoginLimiter) expire(now time.Time) {
+	for element := limiter.windowsByStart.Front(); element != nil; element = limiter.windowsByStart.Front() {
+		window := element.Value.(*failedLoginWindow)
+		if now.Sub(window.start) < limiter.window {
+			break
+		}
+		limiter.windowsByStart.Remove(element)
+		delete(limiter.windows, window.client)
+	}
+}
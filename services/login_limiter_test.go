@@ -0,0 +1,41 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newTestFailedLoginLimiter creates a limiter whose clock is only moved by the test
+func newTestFailedLoginLimiter(maxFailures int, window time.Duration) (*FailedLoginLimiter, *time.Time) {
+	limiter := NewFailedLoginLimiter(maxFailures, window)
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestAClientIsLockedOutUntilItsWindowEnds(t *testing.T) {
+	limiter, now := newTestFailedLoginLimiter(3, 15*time.Minute)
+
+	assert.False(t, limiter.Failed("198.51.100.1"))
+	*now = now.Add(5 * time.Minute)
+	assert.False(t, limiter.Failed("198.51.100.1"))
+	assert.Zero(t, limiter.LockedOut("198.51.100.1"))
+	assert.True(t, limiter.Failed("198.51.100.1"))
+
+	assert.Equal(t, 10*time.Minute, limiter.LockedOut("198.51.100.1"))
+	assert.Zero(t, limiter.LockedOut("198.51.100.2"), "other clients aren't locked out")
+
+	*now = now.Add(10 * time.Minute)
+	assert.Zero(t, limiter.LockedOut("198.51.100.1"))
+}
+
+func TestLoggingInForgetsAClientsFailures(t *testing.T) {
+	limiter, _ := newTestFailedLoginLimiter(2, 15*time.Minute)
+
+	limiter.Failed("198.51.100.1")
+	limiter.Succeeded("198.51.100.1")
+
+	assert.False(t, limiter.Failed("198.51.100.1"))
+	assert.Zero(t, limiter.LockedOut("198.51.100.1"))
+}
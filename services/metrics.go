@@ -0,0 +1,143 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Contact form submission outcomes
+const (
+	SubmissionAccepted       = "accepted"
+	SubmissionInvalid        = "invalid"
+	SubmissionCaptchaFailed  = "captcha_failed"
+	SubmissionSpam           = "spam"
+	SubmissionDeliveryFailed = "delivery_failed"
+)
+
+// Browser report outcomes
+const (
+	BrowserReportCounted     = "counted"
+	BrowserReportDuplicate   = "duplicate"
+	BrowserReportRateLimited = "rate_limited"
+)
+
+// Dependencies the application calls out to
+const (
+	DependencyRecaptcha    = "recaptcha"
+	DependencySes          = "ses"
+	DependencyErrorTracker = "error_tracker"
+)
+
+// DependencyCallOK is the code recorded for calls to a dependency which succeeded
+const DependencyCallOK = "ok"
+
+// metricsNamespace prefixes the name of every metric
+const metricsNamespace = "website"
+
+// Metrics records measurements of what the application is doing
+type Metrics interface {
+	// ObserveRequest records a handled HTTP request, route is the matched route rather than the path so it has
+	// a bounded number of values
+	ObserveRequest(method string, route string, status int, duration time.Duration)
+
+	// ObserveSubmission records the outcome of a contact form submission
+	ObserveSubmission(outcome string)
+
+	// ObserveDependencyCall records a call to a dependency, code is DependencyCallOK or the error code of the failure
+	ObserveDependencyCall(dependency string, code string, duration time.Duration)
+
+	// ObserveBrowserReport records a report sent by a browser, category is the violated CSP directive or the class of
+	// network error, from a bounded set of values
+	ObserveBrowserReport(reportType string, category string, outcome string)
+
+	// Handler serves the metrics
+	Handler() http.Handler
+}
+
+// PrometheusMetrics is an implementation of Metrics exposing them to Prometheus. Every instance has its own registry,
+// which also includes the Go runtime and process metrics
+type PrometheusMetrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+
+	requestDuration *prometheus.HistogramVec
+
+	submissions *prometheus.CounterVec
+
+	dependencyCallDuration *prometheus.HistogramVec
+
+	browserReports *prometheus.CounterVec
+}
+
+func (metrics *PrometheusMetrics) ObserveRequest(method string, route string, status int, duration time.Duration) {
+	statusLabel := strconv.Itoa(status)
+	metrics.requests.WithLabelValues(method, route, statusLabel).Inc()
+	metrics.requestDuration.WithLabelValues(method, route, statusLabel).Observe(duration.Seconds())
+}
+
+func (metrics *PrometheusMetrics) ObserveSubmission(outcome string) {
+	metrics.submissions.WithLabelValues(outcome).Inc()
+}
+
+func (metrics *PrometheusMetrics) ObserveDependencyCall(dependency string, code string, duration time.Duration) {
+	metrics.dependencyCallDuration.WithLabelValues(dependency, code).Observe(duration.Seconds())
+}
+
+func (metrics *PrometheusMetrics) ObserveBrowserReport(reportType string, category string, outcome string) {
+	metrics.browserReports.WithLabelValues(reportType, category, outcome).Inc()
+}
+
+func (metrics *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{})
+}
+
+// NewPrometheusMetrics creates a new PrometheusMetrics
+func NewPrometheusMetrics() *PrometheusMetrics {
+	metrics := &PrometheusMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "How long HTTP requests took to handle, by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		submissions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "contact_submissions_total",
+			Help:      "Contact form submissions, by outcome.",
+		}, []string{"outcome"}),
+		dependencyCallDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "dependency_call_duration_seconds",
+			Help:      "How long calls to reCAPTCHA and SES took, by dependency and result code.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15},
+		}, []string{"dependency", "code"}),
+		browserReports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "browser_reports_total",
+			Help:      "CSP violation and network error reports sent by browsers, by type, category and outcome.",
+		}, []string{"type", "category", "outcome"}),
+	}
+
+	metrics.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metrics.requests,
+		metrics.requestDuration,
+		metrics.submissions,
+		metrics.dependencyCallDuration,
+		metrics.browserReports,
+	)
+
+	return metrics
+}
@@ -1,72 +1,138 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"github.com/adbourne/website-seacitysoftware/domain"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
 const (
 	recaptchaServiceURL             = "https://www.google.com/recaptcha/api/siteverify"
 	CannotCommunicateRecaptchaError = "unable to communicate with the recaptcha service"
+	RecaptchaNotConfiguredError     = "recaptcha secret is not configured"
 	NotVerifiedError                = "user not verified by recaptcha"
 )
 
+// Codes recorded in the metrics for failed calls to recaptcha, other than the error codes recaptcha itself returns
+const (
+	recaptchaUnreachableCode     = "unreachable"
+	recaptchaInvalidResponseCode = "invalid_response"
+	recaptchaNotVerifiedCode     = "not_verified"
+)
+
 type RecaptchaService interface {
-	Verify(response string) error
+	// Verify checks the response the user's browser got from recaptcha, it gives up when the context is done
+	Verify(ctx context.Context, response string) error
 }
 
 type DefaultRecaptchaService struct {
 	Logger Logger
 	// Secret is the recaptcha secret
-	Secret string
+	Secret domain.Secret
 
 	HttpClient *http.Client
+
+	Metrics Metrics
 }
 
-func (rs *DefaultRecaptchaService) Verify(response string) error {
-	escapedSecret := url.QueryEscape(rs.Secret)
-	escapedResponse := url.QueryEscape(response)
+func (rs *DefaultRecaptchaService) Verify(ctx context.Context, response string) (err error) {
+	ctx, span := startSpan(ctx, "RecaptchaService.Verify")
+	defer func() {
+		recordSpanError(span, err)
+		span.End()
+	}()
+
+	// An empty response can never be verified, so don't bother asking
+	if len(response) <= 0 {
+		return errors.New(NotVerifiedError)
+	}
+
+	start := time.Now()
+	code, err := rs.verify(ctx, response)
+	rs.Metrics.ObserveDependencyCall(DependencyRecaptcha, code, time.Since(start))
+	span.SetAttributes(attribute.String("recaptcha.code", code))
+	return err
+}
 
-	requestURL := fmt.Sprintf("%s?secret=%s&response=%s", recaptchaServiceURL, escapedSecret, escapedResponse)
+// verify asks recaptcha to verify the response, returning the code recorded in the metrics along with any error
+func (rs *DefaultRecaptchaService) verify(ctx context.Context, response string) (string, error) {
+	// The secret is sent in the body rather than the URL so it can't end up in any error messages
+	form := url.Values{}
+	form.Set("secret", rs.Secret.Reveal())
+	form.Set("response", response)
 
-	request, err := http.NewRequest("POST", requestURL, nil)
+	request, err := http.NewRequest("POST", recaptchaServiceURL, strings.NewReader(form.Encode()))
 	if err != nil {
-		return err
+		return recaptchaUnreachableCode, err
 	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	var rawResponse *http.Response
 	rawResponse, err = rs.HttpClient.Do(request)
 	if err != nil {
-		rs.Logger.Debug("Unable to communicate with Recaptcha verify service", Fields{"url": recaptchaServiceURL, "error": err.Error()})
-		err = errors.New(CannotCommunicateRecaptchaError)
-		return err
+		rs.Logger.WithContext(ctx).Debug("Unable to communicate with Recaptcha verify service", Fields{"url": recaptchaServiceURL, "error": err.Error()})
+		return recaptchaUnreachableCode, errors.New(CannotCommunicateRecaptchaError)
 	}
+	defer rawResponse.Body.Close()
 
 	recaptchaResponse := NewBlankRecaptchaResponse()
 	decoder := json.NewDecoder(rawResponse.Body)
 	err = decoder.Decode(recaptchaResponse)
 	if err != nil {
-		rs.Logger.Debug("Recaptcha service responded with invalid JSON", Fields{"url": recaptchaServiceURL, "error": err.Error()})
-		err = errors.New(CannotCommunicateRecaptchaError)
-		return err
+		rs.Logger.WithContext(ctx).Debug("Recaptcha service responded with invalid JSON", Fields{"url": recaptchaServiceURL, "error": err.Error()})
+		return recaptchaInvalidResponseCode, errors.New(CannotCommunicateRecaptchaError)
 	}
 
 	if !recaptchaResponse.Success {
-		return errors.New(NotVerifiedError)
+		code := recaptchaNotVerifiedCode
+		if len(recaptchaResponse.ErrorCodes) > 0 {
+			code = recaptchaResponse.ErrorCodes[0]
+		}
+		return code, errors.New(NotVerifiedError)
+	}
+
+	return DependencyCallOK, nil
+}
+
+// CheckHealth checks the secret is configured and the recaptcha service can be reached. The secret isn't sent, an
+// unauthenticated request gets an answer all the same
+func (rs *DefaultRecaptchaService) CheckHealth(ctx context.Context) error {
+	if !rs.Secret.IsSet() {
+		return errors.New(RecaptchaNotConfiguredError)
+	}
+
+	request, err := http.NewRequest("POST", recaptchaServiceURL, nil)
+	if err != nil {
+		return err
+	}
+
+	rawResponse, err := rs.HttpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, CannotCommunicateRecaptchaError)
+	}
+	defer rawResponse.Body.Close()
+
+	err = json.NewDecoder(rawResponse.Body).Decode(NewBlankRecaptchaResponse())
+	if err != nil {
+		return errors.Wrap(err, CannotCommunicateRecaptchaError)
 	}
 
 	return nil
 }
 
-func NewDefaultRecaptchaService(secret string, logger Logger, httpClient *http.Client) *DefaultRecaptchaService {
+func NewDefaultRecaptchaService(secret domain.Secret, logger Logger, httpClient *http.Client, metrics Metrics) *DefaultRecaptchaService {
 	return &DefaultRecaptchaService{
 		Logger:     logger,
 		Secret:     secret,
 		HttpClient: httpClient,
+		Metrics:    metrics,
 	}
 }
 
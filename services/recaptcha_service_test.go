@@ -1,8 +1,12 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -58,3 +62,50 @@ func TestErrorRecaptchaResponseCanBeUnMarshalled(t *testing.T) {
 	assert.Equal(t, "missing-input-secret", actualResponse.ErrorCodes[0])
 	assert.Equal(t, "missing-input-response", actualResponse.ErrorCodes[1])
 }
+
+// newTestRecaptchaHttpClient creates a client sending every request to the test server instead
+func newTestRecaptchaHttpClient(server *httptest.Server) *http.Client {
+	serverURL, _ := url.Parse(server.URL)
+	return &http.Client{
+		Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			request.URL.Scheme = serverURL.Scheme
+			request.URL.Host = serverURL.Host
+			return http.DefaultTransport.RoundTrip(request)
+		}),
+	}
+}
+
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestRecaptchaHealthCheckDoesNotSendTheSecret(t *testing.T) {
+	var receivedSecret string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		receivedSecret = r.PostForm.Get("secret")
+		w.Write([]byte(`{"success": false, "error-codes": ["missing-input-secret"]}`))
+	}))
+	defer server.Close()
+
+	service := NewDefaultRecaptchaService("hunter2", &noopLogger{}, newTestRecaptchaHttpClient(server), NewPrometheusMetrics())
+
+	assert.NoError(t, service.CheckHealth(context.Background()))
+	assert.Empty(t, receivedSecret)
+}
+
+func TestRecaptchaHealthCheckFailsWithoutASecretOrWhenUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>Bad Gateway</html>"))
+	}))
+	defer server.Close()
+
+	service := NewDefaultRecaptchaService("", &noopLogger{}, newTestRecaptchaHttpClient(server), NewPrometheusMetrics())
+	assert.EqualError(t, service.CheckHealth(context.Background()), RecaptchaNotConfiguredError)
+
+	service = NewDefaultRecaptchaService("hunter2", &noopLogger{}, newTestRecaptchaHttpClient(server), NewPrometheusMetrics())
+	assert.Error(t, service.CheckHealth(context.Background()))
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RedactedValue is logged in place of the value of a redacted field
+	RedactedValue = "[REDACTED]"
+
+	// RedactedEmail is logged in place of an email address found in a line
+	RedactedEmail = "[EMAIL]"
+
+	// RedactedPhone is logged in place of a phone number found in a line
+	RedactedPhone = "[PHONE]"
+)
+
+// Phone numbers have between minPhoneDigits and maxPhoneDigits digits, so IP addresses and other short numbers aren't
+// mistaken for them
+const (
+	minPhoneDigits = 9
+	maxPhoneDigits = 15
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+
+	// phonePattern matches digits separated by spaces, dashes and brackets, with an optional leading +
+	phonePattern = regexp.MustCompile(`\+?\(?\d[\d ()\-]{7,}\d`)
+
+	// dateTimePattern matches ISO 8601 dates and times, e.g. 2018-08-08 12:30:00 or 2018-08-08T12:30:00.123Z, which
+	// have as many digits as a phone number but are never searched for one
+	dateTimePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+\-]\d{2}:?\d{2})?)?`)
+)
+
+// Redactor masks personal data in log lines before they are written
+type Redactor struct {
+	// fields are the lower case names of the fields masked on every line
+	fields map[string]bool
+
+	// fieldLevels are the most severe level each field is logged at, keyed by the lower case name of the field
+	fieldLevels map[string]logrus.Level
+
+	// detectPersonalData masks the email addresses and phone numbers found in messages and values
+	detectPersonalData bool
+}
+
+// Redact masks the personal data in a line logged at the level, returning the message and a copy of the fields which
+// can be written
+func (redactor *Redactor) Redact(level logrus.Level, message string, fields Fields) (string, Fields) {
+	redacted := make(Fields, len(fields))
+	for key, value := range fields {
+		redacted[key] = redactor.redactField(level, key, value)
+	}
+
+	if redactor.detectPersonalData {
+		message = redactPersonalData(message)
+	}
+	return message, redacted
+}
+
+// redactField masks the value of the field if it can't be logged at the level, or the personal data in it otherwise
+func (redactor *Redactor) redactField(level logrus.Level, key string, value interface{}) interface{} {
+	name := strings.ToLower(key)
+	if redactor.fields[name] {
+		return RedactedValue
+	}
+
+	// logrus levels get less severe as they increase
+	if maxLevel, ok := redactor.fieldLevels[name]; ok && level < maxLevel {
+		return RedactedValue
+	}
+
+	if !redactor.detectPersonalData {
+		return value
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		return redactPersonalData(typedValue)
+	case []string:
+		values := make([]string, len(typedValue))
+		for i, item := range typedValue {
+			values[i] = redactPersonalData(item)
+		}
+		return values
+	case error:
+		return redactPersonalData(typedValue.Error())
+	default:
+		return value
+	}
+}
+
+// redactPersonalData masks the email addresses and phone numbers in the text. Phone numbers are only looked for between
+// dates and times, so a date followed by a time isn't mistaken for one
+func redactPersonalData(text string) string {
+	text = emailPattern.ReplaceAllString(text, RedactedEmail)
+
+	dateTimes := dateTimePattern.FindAllStringIndex(text, -1)
+	if len(dateTimes) <= 0 {
+		return redactPhoneNumbers(text)
+	}
+
+	var builder strings.Builder
+	last := 0
+	for _, dateTime := range dateTimes {
+		builder.WriteString(redactPhoneNumbers(text[last:dateTime[0]]))
+		builder.WriteString(text[dateTime[0]:dateTime[1]])
+		last = dateTime[1]
+	}
+	builder.WriteString(redactPhoneNumbers(text[last:]))
+	return builder.String()
+}
+
+// redactPhoneNumbers masks the phone numbers in the text
+func redactPhoneNumbers(text string) string {
+	matches := phonePattern.FindAllStringIndex(text, -1)
+	if len(matches) <= 0 {
+		return text
+	}
+
+	var builder strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if !isPhoneNumber(text, start, end) {
+			continue
+		}
+		builder.WriteString(text[last:start])
+		builder.WriteString(RedactedPhone)
+		last = end
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// isPhoneNumber is true when the match has as many digits as a phone number and stands on its own, rather than being
+// part of an ID or hash
+func isPhoneNumber(text string, start int, end int) bool {
+	if start > 0 && isIdentifierByte(text[start-1]) {
+		return false
+	}
+	if end < len(text) && isIdentifierByte(text[end]) {
+		return false
+	}
+
+	digits := 0
+	for _, b := range []byte(text[start:end]) {
+		if b >= '0' && b <= '9' {
+			digits++
+		}
+	}
+	return digits >= minPhoneDigits && digits <= maxPhoneDigits
+}
+
+// isIdentifierByte is true for the bytes IDs are made of
+func isIdentifierByte(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '-' || b == '_'
+}
+
+// NewRedactor creates a Redactor from the redaction settings of the log config
+func NewRedactor(logConfig *domain.LogConfig) (*Redactor, error) {
+	redactor := &Redactor{
+		fields:             make(map[string]bool),
+		fieldLevels:        make(map[string]logrus.Level),
+		detectPersonalData: logConfig.DetectPersonalData,
+	}
+
+	for _, field := range logConfig.RedactFields {
+		redactor.fields[strings.ToLower(field)] = true
+	}
+
+	for field, level := range logConfig.FieldLevels {
+		logrusLevel, err := logrus.ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		redactor.fieldLevels[strings.ToLower(field)] = logrusLevel
+	}
+	return redactor, nil
+}
+
+// NewDefaultRedactor creates the Redactor used until the logger is configured, masking the default fields and any
+// personal data detected
+func NewDefaultRedactor() *Redactor {
+	redactor, _ := NewRedactor(&domain.LogConfig{
+		RedactFields:       splitConfigList(defaultLogRedactFields),
+		DetectPersonalData: true,
+	})
+	return redactor
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testContactForm is a submission full of personal details which must never be logged
+var testContactForm = &domain.ContactForm{
+	ID:      "8c3c2d7e-5a2b-4c1d-9e8f-1a2b3c4d5e6f",
+	Name:    "Bob Bobson",
+	Email:   "bob@someemail.com",
+	Company: "Bob's Burgers",
+	Number:  "+44 (0)7700 900123",
+	Message: "Please call me back on 07700 900456",
+}
+
+// assertNoPersonalData asserts none of the test contact form's personal details are in the output
+func assertNoPersonalData(t *testing.T, output string) {
+	assert.NotContains(t, output, "Bob Bobson")
+	assert.NotContains(t, output, "bob@someemail.com")
+	assert.NotContains(t, output, "Bob's Burgers")
+	assert.NotContains(t, output, "7700 900123")
+	assert.NotContains(t, output, "07700 900456")
+	assert.NotContains(t, output, "Please call me back")
+}
+
+func TestConfiguredFieldsAreRedacted(t *testing.T) {
+	redactor, err := NewRedactor(&domain.LogConfig{RedactFields: []string{"email", "Message"}})
+	assert.NoError(t, err)
+
+	_, fields := redactor.Redact(logrus.InfoLevel, "submitted", Fields{
+		"Email":        "bob@someemail.com",
+		"message":      "hello",
+		"submissionId": "1234",
+	})
+
+	assert.Equal(t, RedactedValue, fields["Email"])
+	assert.Equal(t, RedactedValue, fields["message"])
+	assert.Equal(t, "1234", fields["submissionId"])
+}
+
+func TestFieldsAreRedactedOnLinesMoreSevereThanTheirLevel(t *testing.T) {
+	redactor, err := NewRedactor(&domain.LogConfig{FieldLevels: map[string]string{"ip": domain.LogLevelInfo}})
+	assert.NoError(t, err)
+
+	_, fields := redactor.Redact(logrus.DebugLevel, "handled", Fields{"ip": "203.0.113.7"})
+	assert.Equal(t, "203.0.113.7", fields["ip"])
+
+	_, fields = redactor.Redact(logrus.InfoLevel, "handled", Fields{"ip": "203.0.113.7"})
+	assert.Equal(t, "203.0.113.7", fields["ip"])
+
+	_, fields = redactor.Redact(logrus.ErrorLevel, "handled", Fields{"ip": "203.0.113.7"})
+	assert.Equal(t, RedactedValue, fields["ip"])
+}
+
+func TestEmailAddressesAndPhoneNumbersAreDetected(t *testing.T) {
+	redactor, err := NewRedactor(&domain.LogConfig{DetectPersonalData: true})
+	assert.NoError(t, err)
+
+	message, fields := redactor.Redact(logrus.ErrorLevel, "unable to reply to bob@someemail.com", Fields{
+		"error":   errors.New("rejected: call +44 (0)7700 900123 or 07700-900456."),
+		"domains": []string{"jim.smith@mail.example.co.uk"},
+	})
+
+	assert.Equal(t, "unable to reply to "+RedactedEmail, message)
+	assert.Equal(t, "rejected: call "+RedactedPhone+" or "+RedactedPhone+".", fields["error"])
+	assert.Equal(t, []string{RedactedEmail}, fields["domains"])
+}
+
+func TestIDsAddressesAndDatesAreNotMistakenForPhoneNumbers(t *testing.T) {
+	redactor, err := NewRedactor(&domain.LogConfig{DetectPersonalData: true})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"uuid", "8c3c2d7e-1234-4567-8901-123456789012"},
+		{"trace id", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"ip and port", "192.168.100.200:8080"},
+		{"ipv6 and port", "[2001:db8::1234:5678]:8443"},
+		{"ip and port after text", "dial tcp 203.0.113.7:443: i/o timeout"},
+		{"date", "2018-08-08"},
+		{"date and time", "2026-10-18 19:33:04"},
+		{"date and time with fraction", "2026-10-18 19:33:04.123456"},
+		{"rfc3339", "2026-10-18T19:33:04Z"},
+		{"rfc3339 with offset", "2026-10-18T19:33:04+01:00"},
+		{"rfc3339 with nanoseconds", "2026-10-18T19:33:04.123456789+01:00"},
+		{"timestamp in text", "retried at 2026-10-18 19:33:04 and 2026-10-18 19:34:04"},
+		{"milliseconds", "took 1234ms"},
+		{"duration", "1h2m3.456789s"},
+		{"long duration", "timed out after 2562047h47m16.854775807s"},
+	}
+	for _, test := range tests {
+		_, fields := redactor.Redact(logrus.InfoLevel, "", Fields{"value": test.value})
+		assert.Equal(t, test.value, fields["value"], test.name)
+	}
+}
+
+func TestPhoneNumbersNextToDatesAreDetected(t *testing.T) {
+	redactor, err := NewRedactor(&domain.LogConfig{DetectPersonalData: true})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		value    string
+		redacted string
+	}{
+		{"after a date", "2026-10-18 call 07700 900123", "2026-10-18 call " + RedactedPhone},
+		{"after a timestamp", "2026-10-18 19:33:04 07700 900123", "2026-10-18 19:33:04 " + RedactedPhone},
+		{"before a timestamp", "+44 7700 900123 at 2026-10-18T19:33:04Z", RedactedPhone + " at 2026-10-18T19:33:04Z"},
+	}
+	for _, test := range tests {
+		_, fields := redactor.Redact(logrus.InfoLevel, "", Fields{"value": test.value})
+		assert.Equal(t, test.redacted, fields["value"], test.name)
+	}
+}
+
+func TestNoPersonalDataIsLoggedWhenAnEmailCantBeSent(t *testing.T) {
+	logger, buffer := newBufferedLogrusLogger()
+
+	// The worst case, a whole email and an error quoting the submitter's address logged by a child logger
+	ctx := ContextWithRequestID(context.Background(), "request-1")
+	logger.WithContext(ctx).With(Fields{"name": testContactForm.Name}).Error("unable to send email", Fields{
+		"email":   contactFormToEmailBody(testContactForm),
+		"body":    contactFormToEmailBody(testContactForm),
+		"error":   "MessageRejected: Email address is not verified: " + testContactForm.Email,
+		"company": testContactForm.Company,
+		"number":  testContactForm.Number,
+	})
+
+	assert.Contains(t, buffer.String(), "unable to send email")
+	assert.Contains(t, buffer.String(), "request-1")
+	assertNoPersonalData(t, buffer.String())
+}
+
+func TestNoPersonalDataIsLoggedOnceTheLoggerIsConfigured(t *testing.T) {
+	logger, buffer := newBufferedLogrusLogger()
+	err := logger.Configure(&domain.LogConfig{
+		Level:              domain.LogLevelDebug,
+		Format:             domain.LogFormatJSON,
+		RedactFields:       []string{"name", "email", "company", "number", "message"},
+		DetectPersonalData: true,
+	})
+	assert.NoError(t, err)
+	logger.Logger.Out = buffer
+
+	logger.Error("Unable to process contact form", Fields{
+		"name":    testContactForm.Name,
+		"message": testContactForm.Message,
+		"error":   "unable to send to " + testContactForm.Email + " or " + testContactForm.Number,
+	})
+
+	assert.Contains(t, buffer.String(), "Unable to process contact form")
+	assertNoPersonalData(t, buffer.String())
+}
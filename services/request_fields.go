@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fields added to log lines written while handling a request
+const (
+	requestIdField = "requestId"
+	traceIdField   = "traceId"
+	spanIdField    = "spanId"
+)
+
+// requestIdKey is the key of the request ID in a context
+type requestIdKey struct{}
+
+// ContextWithRequestID returns a copy of the context carrying the ID of the request being handled
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestID)
+}
+
+// RequestIDFromContext returns the ID of the request being handled, it is empty when there isn't one
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIdKey{}).(string)
+	return requestID
+}
+
+// requestFields adds the request ID and the IDs of the trace and span in the context to the fields, so every log line
+// written while handling a request can be found from its ID or its trace. The fields are returned unchanged when the
+// context has none of them
+func requestFields(ctx context.Context, fields Fields) Fields {
+	requestID := RequestIDFromContext(ctx)
+	spanContext := trace.SpanContextFromContext(ctx)
+	if len(requestID) <= 0 && !spanContext.IsValid() {
+		return fields
+	}
+
+	requestFields := Fields{}
+	if len(requestID) > 0 {
+		requestFields[requestIdField] = requestID
+	}
+	if spanContext.IsValid() {
+		requestFields[traceIdField] = spanContext.TraceID().String()
+		requestFields[spanIdField] = spanContext.SpanID().String()
+	}
+	for key, value := range fields {
+		requestFields[key] = value
+	}
+	return requestFields
+}
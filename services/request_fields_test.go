@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRequestFieldsAreOnlyAddedWhenTheContextHasThem(t *testing.T) {
+	_, stopRecording := recordSpans()
+	defer stopRecording()
+
+	fields := Fields{"submissionId": "1234"}
+	assert.Equal(t, fields, requestFields(context.Background(), fields))
+
+	ctx := ContextWithRequestID(context.Background(), "request-1")
+	assert.Equal(t, Fields{"submissionId": "1234", requestIdField: "request-1"}, requestFields(ctx, fields))
+
+	ctx, span := startSpan(ctx, "test")
+	defer span.End()
+
+	requestFields := requestFields(ctx, fields)
+	assert.Equal(t, "1234", requestFields["submissionId"])
+	assert.Equal(t, "request-1", requestFields[requestIdField])
+	assert.Equal(t, span.SpanContext().TraceID().String(), requestFields[traceIdField])
+	assert.Equal(t, span.SpanContext().SpanID().String(), requestFields[spanIdField])
+	assert.NotContains(t, fields, requestIdField, "the fields passed in should not be changed")
+}
@@ -0,0 +1,450 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sentryClient identifies the application to the error tracker
+	sentryClient = "website-seacitysoftware/1.0"
+
+	// sentryQueueSize is how many reports can be waiting to be sent, any more are dropped
+	sentryQueueSize = 100
+
+	// sentryRateLimitWindow is the window the rate limit applies to
+	sentryRateLimitWindow = time.Minute
+
+	// sentryDefaultRetryAfter is how long reports are dropped for when the error tracker asks for fewer of them
+	// without saying for how long
+	sentryDefaultRetryAfter = time.Minute
+
+	// appModule prefixes the functions of the application, rather than its dependencies, in stack traces
+	appModule = "github.com/adbourne/website-seacitysoftware"
+)
+
+// reportedHeaders are the only request headers reported, the rest can hold credentials or identify the visitor
+var reportedHeaders = []string{"User-Agent", "Referer", "Content-Type", "Content-Length"}
+
+// sentryEvent is an event in the format of Sentry's store API
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Exception   *sentryExceptions `json:"exception"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Contexts    map[string]Fields `json:"contexts,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []*sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	// Frames are ordered from the outermost call to the innermost, as Sentry expects
+	Frames []*sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// SentryReporter is an ErrorReporter sending errors to a Sentry compatible error tracker. Reports are sent in the
+// background so reporting never slows a request, a sample of them are sent and no more than the rate limit
+type SentryReporter struct {
+	// storeURL is the URL of the store API of the project
+	storeURL string
+
+	// authHeader authenticates the reports
+	authHeader string
+
+	httpClient  *http.Client
+	metrics     Metrics
+	logger      Logger
+	release     string
+	environment string
+	serverName  string
+	sampleRate  float64
+	rateLimit   int
+
+	// random returns a number from 0 up to 1 which decides whether a report is sampled
+	random func() float64
+
+	// queue holds the reports waiting to be sent, it is closed by Close
+	queue chan *sentryEvent
+
+	// done is closed once the queue has been drained
+	done chan struct{}
+
+	// mutex guards the fields below
+	mutex sync.Mutex
+
+	closed bool
+
+	// windowStart and windowCount are the start of the rate limit window and the reports accepted in it
+	windowStart time.Time
+	windowCount int
+
+	// retryAfter is when reports can be sent again after the error tracker asked for fewer of them
+	retryAfter time.Time
+}
+
+func (reporter *SentryReporter) Report(ctx context.Context, event *ErrorEvent) {
+	if reporter.random() >= reporter.sampleRate {
+		return
+	}
+
+	now := time.Now()
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	if reporter.closed || now.Before(reporter.retryAfter) {
+		return
+	}
+
+	if now.Sub(reporter.windowStart) >= sentryRateLimitWindow {
+		reporter.windowStart, reporter.windowCount = now, 0
+	}
+	if reporter.windowCount >= reporter.rateLimit {
+		return
+	}
+	reporter.windowCount++
+
+	// The event is built now as the request and context may not outlive the call
+	select {
+	case reporter.queue <- reporter.newSentryEvent(ctx, event, now):
+	default:
+		reporter.logger.Warn("Too many errors waiting to be reported, dropping the report", Fields{"incidentId": event.IncidentID})
+	}
+}
+
+// newSentryEvent creates the event sent for the error, leaving out any personal details
+func (reporter *SentryReporter) newSentryEvent(ctx context.Context, event *ErrorEvent, now time.Time) *sentryEvent {
+	exception := &sentryException{
+		Type:  "error",
+		Value: "unknown error",
+	}
+	if event.Err != nil {
+		exception.Type = fmt.Sprintf("%T", errors.Cause(event.Err))
+		exception.Value = redactPersonalData(event.Err.Error())
+	}
+	if event.Panic {
+		exception.Type = "panic"
+	}
+
+	if len(event.Stack) > 0 {
+		exception.Stacktrace = parseStack(event.Stack)
+	} else if stackTracer, ok := event.Err.(interface{ StackTrace() errors.StackTrace }); ok {
+		exception.Stacktrace = newStacktrace(stackTracer.StackTrace())
+	}
+
+	tags := map[string]string{}
+	for key, value := range event.Tags {
+		tags[key] = value
+	}
+	if len(event.IncidentID) > 0 {
+		tags["incident_id"] = event.IncidentID
+	}
+
+	if requestID := RequestIDFromContext(ctx); len(requestID) > 0 {
+		tags["request_id"] = requestID
+	}
+
+	contexts := map[string]Fields{}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		contexts["trace"] = Fields{"trace_id": spanContext.TraceID().String(), "span_id": spanContext.SpanID().String()}
+	}
+
+	return &sentryEvent{
+		EventID:     newEventID(),
+		Timestamp:   now.UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Release:     reporter.release,
+		Environment: reporter.environment,
+		ServerName:  reporter.serverName,
+		Exception:   &sentryExceptions{Values: []*sentryException{exception}},
+		Request:     newSentryRequest(event.Request),
+		Tags:        tags,
+		Contexts:    contexts,
+	}
+}
+
+// newSentryRequest describes the request without the query string, body, cookies or anything else which could hold
+// personal details or credentials
+func newSentryRequest(request *http.Request) *sentryRequest {
+	if request == nil {
+		return nil
+	}
+
+	scheme := "http"
+	if request.TLS != nil || request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	requestURL := &url.URL{Scheme: scheme, Host: request.Host, Path: redactPersonalData(request.URL.Path)}
+
+	headers := map[string]string{}
+	for _, header := range reportedHeaders {
+		value := request.Header.Get(header)
+		if len(value) <= 0 {
+			continue
+		}
+		if header == "Referer" {
+			if referrer, err := url.Parse(value); err == nil {
+				referrer.RawQuery, referrer.Fragment, referrer.User = "", "", nil
+				value = referrer.String()
+			}
+		}
+		headers[header] = redactPersonalData(value)
+	}
+
+	return &sentryRequest{
+		Method:  request.Method,
+		URL:     requestURL.String(),
+		Headers: headers,
+	}
+}
+
+// parseStack parses a stack trace in the format of runtime/debug.Stack, a line naming each function followed by an
+// indented line giving its file and line number
+func parseStack(stack []byte) *sentryStacktrace {
+	frames := []*sentryFrame{}
+	function := ""
+	for _, line := range strings.Split(string(stack), "\n") {
+		if !strings.HasPrefix(line, "\t") {
+			function = parseStackFunction(line)
+			continue
+		}
+		if len(function) <= 0 {
+			continue
+		}
+
+		location := strings.TrimSpace(line)
+		if end := strings.LastIndex(location, " +0x"); end >= 0 {
+			location = location[:end]
+		}
+		file, lineNumber := location, 0
+		if colon := strings.LastIndex(location, ":"); colon >= 0 {
+			file = location[:colon]
+			lineNumber, _ = strconv.Atoi(location[colon+1:])
+		}
+
+		frames = append(frames, newSentryFrame(function, file, lineNumber))
+		function = ""
+	}
+	return reverseFrames(frames)
+}
+
+// parseStackFunction parses the name of the function from a line of a stack trace, e.g. main.handler(0x1, 0x2) or
+// created by net/http.(*Server).Serve in goroutine 6
+func parseStackFunction(line string) string {
+	function := strings.TrimPrefix(strings.TrimSpace(line), "created by ")
+	if end := strings.Index(function, " in goroutine"); end >= 0 {
+		function = function[:end]
+	}
+	if strings.HasSuffix(function, ")") {
+		if start := strings.LastIndex(function, "("); start > 0 {
+			function = function[:start]
+		}
+	}
+	return function
+}
+
+// newStacktrace creates the stack trace of an error created with github.com/pkg/errors
+func newStacktrace(stackTrace errors.StackTrace) *sentryStacktrace {
+	pcs := make([]uintptr, len(stackTrace))
+	for i, frame := range stackTrace {
+		pcs[i] = uintptr(frame)
+	}
+
+	frames := []*sentryFrame{}
+	callersFrames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := callersFrames.Next()
+		frames = append(frames, newSentryFrame(frame.Function, frame.File, frame.Line))
+		if !more {
+			break
+		}
+	}
+	return reverseFrames(frames)
+}
+
+// newSentryFrame creates a frame, splitting the package from the function name, e.g. net/http.(*Server).Serve
+func newSentryFrame(function string, file string, line int) *sentryFrame {
+	module := ""
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		module, function = function[:slash+1+dot], function[slash+1+dot+1:]
+	}
+
+	filename := file
+	if slash := strings.LastIndex(file, "/"); slash >= 0 {
+		filename = file[slash+1:]
+	}
+
+	return &sentryFrame{
+		Function: function,
+		Module:   module,
+		Filename: filename,
+		AbsPath:  file,
+		Lineno:   line,
+		InApp:    module == "main" || strings.HasPrefix(module, appModule),
+	}
+}
+
+func reverseFrames(frames []*sentryFrame) *sentryStacktrace {
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return &sentryStacktrace{Frames: frames}
+}
+
+// newEventID creates the random ID Sentry identifies events by
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// run sends the reports in the queue until it is closed
+func (reporter *SentryReporter) run() {
+	defer close(reporter.done)
+	for event := range reporter.queue {
+		reporter.send(event)
+	}
+}
+
+// send sends a report to the error tracker. Failures are only logged, reporting them would fail the same way
+func (reporter *SentryReporter) send(event *sentryEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		reporter.logger.Warn("Unable to encode error report", Fields{"error": err.Error()})
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, reporter.storeURL, bytes.NewReader(body))
+	if err != nil {
+		reporter.logger.Warn("Unable to create error report request", Fields{"error": err.Error()})
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Sentry-Auth", reporter.authHeader)
+
+	start := time.Now()
+	response, err := reporter.httpClient.Do(request)
+	if err != nil {
+		reporter.metrics.ObserveDependencyCall(DependencyErrorTracker, "unreachable", time.Since(start))
+		reporter.logger.Warn("Unable to send error report", Fields{"error": err.Error()})
+		return
+	}
+	response.Body.Close()
+	reporter.metrics.ObserveDependencyCall(DependencyErrorTracker, errorTrackerCode(response.StatusCode), time.Since(start))
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		retryAfter := sentryDefaultRetryAfter
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		reporter.mutex.Lock()
+		reporter.retryAfter = time.Now().Add(retryAfter)
+		reporter.mutex.Unlock()
+		reporter.logger.Warn("Error tracker is rate limiting reports", Fields{"retryAfter": retryAfter.String()})
+	case response.StatusCode >= http.StatusMultipleChoices:
+		reporter.logger.Warn("Error tracker rejected the report", Fields{"status": response.StatusCode, "eventId": event.EventID})
+	}
+}
+
+// errorTrackerCode is the code a call to the error tracker is recorded with
+func errorTrackerCode(status int) string {
+	if status >= http.StatusOK && status < http.StatusMultipleChoices {
+		return DependencyCallOK
+	}
+	return strconv.Itoa(status)
+}
+
+// Close stops accepting reports and waits for the reports already accepted to be sent, until the context is done
+func (reporter *SentryReporter) Close(ctx context.Context) error {
+	reporter.mutex.Lock()
+	if !reporter.closed {
+		reporter.closed = true
+		close(reporter.queue)
+	}
+	reporter.mutex.Unlock()
+
+	select {
+	case <-reporter.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewSentryReporter creates a SentryReporter for the error reporting config and starts sending reports. The release
+// is used when the config doesn't give one
+func NewSentryReporter(errorReportingConfig *domain.ErrorReportingConfig, release string, logger Logger, httpClient *http.Client, metrics Metrics) (*SentryReporter, error) {
+	publicKey, server, projectID, err := errorReportingConfig.ParseDsn()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(errorReportingConfig.Release) > 0 {
+		release = errorReportingConfig.Release
+	}
+	serverName, _ := os.Hostname()
+
+	reporter := &SentryReporter{
+		storeURL:    fmt.Sprintf("%s/api/%s/store/", server.String(), projectID),
+		authHeader:  fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_key=%s", sentryClient, publicKey),
+		httpClient:  httpClient,
+		metrics:     metrics,
+		logger:      logger,
+		release:     release,
+		environment: errorReportingConfig.Environment,
+		serverName:  serverName,
+		sampleRate:  errorReportingConfig.SampleRate,
+		rateLimit:   errorReportingConfig.RateLimit,
+		random:      mathrand.Float64,
+		queue:       make(chan *sentryEvent, sentryQueueSize),
+		done:        make(chan struct{}),
+	}
+	go reporter.run()
+	return reporter, nil
+}
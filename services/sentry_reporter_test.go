@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentryStandIn is a local stand-in for a Sentry server, recording the reports it receives
+type sentryStandIn struct {
+	server *httptest.Server
+
+	mutex   sync.Mutex
+	paths   []string
+	auths   []string
+	reports []map[string]interface{}
+
+	// status is the status every report is answered with
+	status int
+}
+
+func newSentryStandIn() *sentryStandIn {
+	standIn := &sentryStandIn{status: http.StatusOK}
+	standIn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		report := map[string]interface{}{}
+		json.Unmarshal(body, &report)
+
+		standIn.mutex.Lock()
+		defer standIn.mutex.Unlock()
+		standIn.paths = append(standIn.paths, r.URL.Path)
+		standIn.auths = append(standIn.auths, r.Header.Get("X-Sentry-Auth"))
+		standIn.reports = append(standIn.reports, report)
+
+		if standIn.status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "3600")
+		}
+		w.WriteHeader(standIn.status)
+	}))
+	return standIn
+}
+
+func (standIn *sentryStandIn) received() []map[string]interface{} {
+	standIn.mutex.Lock()
+	defer standIn.mutex.Unlock()
+	return append([]map[string]interface{}{}, standIn.reports...)
+}
+
+// newTestSentryReporter creates a reporter sending every report to the stand-in, up to the rate limit
+func newTestSentryReporter(t *testing.T, standIn *sentryStandIn, rateLimit int) *SentryReporter {
+	errorReportingConfig := &domain.ErrorReportingConfig{
+		Dsn:         domain.Secret(strings.Replace(standIn.server.URL, "://", "://public-key@", 1) + "/sentry/42"),
+		Environment: "test",
+		SampleRate:  1,
+		RateLimit:   rateLimit,
+	}
+	reporter, err := NewSentryReporter(errorReportingConfig, "1.2.3", &noopLogger{}, standIn.server.Client(), NewPrometheusMetrics())
+	assert.NoError(t, err)
+	return reporter
+}
+
+// closeReporter waits for the reports to be sent
+func closeReporter(t *testing.T, reporter *SentryReporter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, reporter.Close(ctx))
+}
+
+func TestPanicsAreReportedWithTheirStackAndRequestWithoutPersonalData(t *testing.T) {
+	standIn := newSentryStandIn()
+	defer standIn.server.Close()
+	reporter := newTestSentryReporter(t, standIn, 10)
+
+	request := httptest.NewRequest("POST", "https://www.seacitysoftware.com/contact?email=bob@someemail.com", nil)
+	request.Header.Set("User-Agent", "test-agent")
+	request.Header.Set("Referer", "https://www.seacitysoftware.com/contact?name=Bob")
+	request.Header.Set("Cookie", "session=secret")
+	request.Header.Set("Authorization", "Bearer secret")
+
+	ctx := ContextWithRequestID(context.Background(), "request-1")
+	reporter.Report(ctx, &ErrorEvent{
+		IncidentID: "3F2A9C1B",
+		Err:        errors.New("panic: unable to reply to bob@someemail.com"),
+		Stack:      debug.Stack(),
+		Panic:      true,
+		Request:    request,
+	})
+	closeReporter(t, reporter)
+
+	reports := standIn.received()
+	if !assert.Len(t, reports, 1) {
+		return
+	}
+	assert.Equal(t, "/sentry/api/42/store/", standIn.paths[0])
+	assert.Contains(t, standIn.auths[0], "sentry_version=7")
+	assert.Contains(t, standIn.auths[0], "sentry_key=public-key")
+
+	report := reports[0]
+	assert.Len(t, report["event_id"], 32)
+	assert.Equal(t, "1.2.3", report["release"])
+	assert.Equal(t, "test", report["environment"])
+	assert.Equal(t, "3F2A9C1B", report["tags"].(map[string]interface{})["incident_id"])
+	assert.Equal(t, "request-1", report["tags"].(map[string]interface{})["request_id"])
+
+	exception := report["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "panic", exception["type"])
+	assert.Equal(t, "panic: unable to reply to "+RedactedEmail, exception["value"])
+
+	// The innermost frame, this test, is last
+	frames := exception["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	if assert.NotEmpty(t, frames) {
+		frame := frames[len(frames)-1].(map[string]interface{})
+		assert.Equal(t, "Stack", frame["function"])
+		assert.Equal(t, "runtime/debug", frame["module"])
+
+		frame = frames[len(frames)-2].(map[string]interface{})
+		assert.Equal(t, "TestPanicsAreReportedWithTheirStackAndRequestWithoutPersonalData", frame["function"])
+		assert.Equal(t, "sentry_reporter_test.go", frame["filename"])
+		assert.Equal(t, true, frame["in_app"])
+	}
+
+	reportedRequest := report["request"].(map[string]interface{})
+	assert.Equal(t, "POST", reportedRequest["method"])
+	assert.Equal(t, "https://www.seacitysoftware.com/contact", reportedRequest["url"])
+	headers := reportedRequest["headers"].(map[string]interface{})
+	assert.Equal(t, "test-agent", headers["User-Agent"])
+	assert.Equal(t, "https://www.seacitysoftware.com/contact", headers["Referer"])
+	assert.NotContains(t, headers, "Cookie")
+	assert.NotContains(t, headers, "Authorization")
+}
+
+func TestErrorsAreReportedWithTheStackTheyWereCreatedWith(t *testing.T) {
+	standIn := newSentryStandIn()
+	defer standIn.server.Close()
+	reporter := newTestSentryReporter(t, standIn, 10)
+
+	reporter.Report(context.Background(), &ErrorEvent{Err: errors.Wrap(errors.New("config is invalid"), "unable to reload")})
+	closeReporter(t, reporter)
+
+	reports := standIn.received()
+	if !assert.Len(t, reports, 1) {
+		return
+	}
+	exception := reports[0]["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "unable to reload: config is invalid", exception["value"])
+	frames := exception["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	if assert.NotEmpty(t, frames) {
+		frame := frames[len(frames)-1].(map[string]interface{})
+		assert.Equal(t, "TestErrorsAreReportedWithTheStackTheyWereCreatedWith", frame["function"])
+	}
+}
+
+func TestReportsAreSampled(t *testing.T) {
+	standIn := newSentryStandIn()
+	defer standIn.server.Close()
+	reporter := newTestSentryReporter(t, standIn, 10)
+	reporter.sampleRate = 0.5
+
+	for _, random := range []float64{0.1, 0.7, 0.4, 0.9} {
+		reporter.random = func() float64 { return random }
+		reporter.Report(context.Background(), &ErrorEvent{Err: errors.New("failed")})
+	}
+	closeReporter(t, reporter)
+
+	assert.Len(t, standIn.received(), 2)
+}
+
+func TestReportsAreRateLimited(t *testing.T) {
+	standIn := newSentryStandIn()
+	defer standIn.server.Close()
+	reporter := newTestSentryReporter(t, standIn, 3)
+
+	for i := 0; i < 10; i++ {
+		reporter.Report(context.Background(), &ErrorEvent{Err: errors.New("failed")})
+	}
+
+	// The limit applies to a window, once it has passed more reports are sent
+	reporter.mutex.Lock()
+	reporter.windowStart = reporter.windowStart.Add(-sentryRateLimitWindow)
+	reporter.mutex.Unlock()
+	reporter.Report(context.Background(), &ErrorEvent{Err: errors.New("failed")})
+	closeReporter(t, reporter)
+
+	assert.Len(t, standIn.received(), 4)
+}
+
+func TestReportsAreDroppedWhileTheErrorTrackerIsRateLimitingThem(t *testing.T) {
+	standIn := newSentryStandIn()
+	defer standIn.server.Close()
+	standIn.status = http.StatusTooManyRequests
+	reporter := newTestSentryReporter(t, standIn, 10)
+
+	reporter.Report(context.Background(), &ErrorEvent{Err: errors.New("failed")})
+	err := eventually(func() bool {
+		reporter.mutex.Lock()
+		defer reporter.mutex.Unlock()
+		return reporter.retryAfter.After(time.Now().Add(time.Hour - time.Minute))
+	})
+	assert.NoError(t, err, "the Retry-After header was not respected")
+
+	reporter.Report(context.Background(), &ErrorEvent{Err: errors.New("failed")})
+	closeReporter(t, reporter)
+
+	assert.Len(t, standIn.received(), 1)
+}
+
+func TestReportsAfterCloseAreDropped(t *testing.T) {
+	standIn := newSentryStandIn()
+	defer standIn.server.Close()
+	reporter := newTestSentryReporter(t, standIn, 10)
+	closeReporter(t, reporter)
+
+	reporter.Report(context.Background(), &ErrorEvent{Err: errors.New("failed")})
+	assert.Empty(t, standIn.received())
+}
+
+func TestAnInvalidDsnIsRejected(t *testing.T) {
+	for _, dsn := range []string{"not a url", "ftp://key@sentry.example.com/1", "https://sentry.example.com/1", "https://key@sentry.example.com/"} {
+		_, err := NewSentryReporter(&domain.ErrorReportingConfig{Dsn: domain.Secret(dsn)}, "1.2.3", &noopLogger{}, http.DefaultClient, NewPrometheusMetrics())
+		assert.EqualError(t, err, domain.ErrorReportingDsnInvalidError, "DSN '%s'", dsn)
+	}
+}
+
+// eventually polls the condition until it is true, failing after a few seconds
+func eventually(condition func() bool) error {
+	for i := 0; i < 50; i++ {
+		if condition() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.New("the condition was never true")
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"container/list"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"sync"
+	"time"
+)
+
+// submissionReceipt is a receipt and when it was issued
+type submissionReceipt struct {
+	receipt domain.ContactFormReceipt
+
+	issued time.Time
+}
+
+// SubmissionReceiptStore keeps the receipts of accepted contact form submissions in memory, so the Location given for
+// a submission can be read back. Receipts are forgotten once they're older than the max age, and the oldest receipt is
+// evicted once the most receipts are kept, so a receipt can't be read back forever. Receipts are kept in a list,
+// oldest first, so the oldest are expired or evicted without looking through the rest
+type SubmissionReceiptStore struct {
+	maxReceipts int
+
+	maxAge time.Duration
+
+	// now is the current time, replaced in tests
+	now func() time.Time
+
+	mutex sync.Mutex
+
+	// receipts are the receipts by submission ID, the list holds the *submissionReceipt issued longest ago first
+	receipts map[string]*list.Element
+
+	receiptsByIssued *list.List
+}
+
+// NewSubmissionReceiptStore creates a SubmissionReceiptStore
+func NewSubmissionReceiptStore(maxReceipts int, maxAge time.Duration) *SubmissionReceiptStore {
+	return &SubmissionReceiptStore{
+		maxReceipts:      maxReceipts,
+		maxAge:           maxAge,
+		now:              time.Now,
+		receipts:         make(map[string]*list.Element),
+		receiptsByIssued: list.New(),
+	}
+}
+
+// Add keeps a receipt so it can be read back with Get
+func (store *SubmissionReceiptStore) Add(receipt domain.ContactFormReceipt) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.now()
+	store.expire(now)
+
+	if element, isFound := store.receipts[receipt.ID]; isFound {
+		store.receiptsByIssued.Remove(element)
+	}
+
+	for store.maxReceipts > 0 && store.receiptsByIssued.Len() >= store.maxReceipts {
+		oldest := store.receiptsByIssued.Front()
+		delete(store.receipts, oldest.Value.(*submissionReceipt).receipt.ID)
+		store.receiptsByIssued.Remove(oldest)
+	}
+
+	store.receipts[receipt.ID] = store.receiptsByIssued.PushBack(&submissionReceipt{receipt: receipt, issued: now})
+}
+
+// Get returns the receipt of a submission, it is false when there is no receipt for the ID or it has been forgotten
+func (store *SubmissionReceiptStore) Get(id string) (domain.ContactFormReceipt, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.expire(store.now())
+
+	element, isFound := store.receipts[id]
+	if !isFound {
+		return domain.ContactFormReceipt{}, false
+	}
+	return element.Value.(*submissionReceipt).receipt, true
+}
+
+// expire forgets the receipts older than the max age
+func (store *SubmissionReceiptStore) expire(now time.Time) {
+	for element := store.receiptsByIssued.Front(); element != nil; element = store.receiptsByIssued.Front() {
+		receipt := element.Value.(*submissionReceipt)
+		if now.Sub(receipt.issued) < store.maxAge {
+			return
+		}
+		delete(store.receipts, receipt.receipt.ID)
+		store.receiptsByIssued.Remove(element)
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newTestSubmissionReceiptStore creates a store whose clock is only moved by the test
+func newTestSubmissionReceiptStore(maxReceipts int, maxAge time.Duration) (*SubmissionReceiptStore, *time.Time) {
+	store := NewSubmissionReceiptStore(maxReceipts, maxAge)
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestAReceiptCanBeReadBack(t *testing.T) {
+	store, _ := newTestSubmissionReceiptStore(10, time.Hour)
+	receipt := domain.ContactFormReceipt{ID: "a", Status: "accepted"}
+
+	store.Add(receipt)
+
+	found, isFound := store.Get("a")
+	assert.True(t, isFound)
+	assert.Equal(t, receipt, found)
+
+	_, isFound = store.Get("b")
+	assert.False(t, isFound)
+}
+
+func TestReceiptsAreForgottenOnceTheyExpire(t *testing.T) {
+	store, now := newTestSubmissionReceiptStore(10, time.Hour)
+
+	store.Add(domain.ContactFormReceipt{ID: "a", Status: "accepted"})
+	*now = now.Add(time.Hour)
+
+	_, isFound := store.Get("a")
+	assert.False(t, isFound)
+}
+
+func TestTheOldestReceiptIsForgottenOnceTheMostReceiptsAreKept(t *testing.T) {
+	store, now := newTestSubmissionReceiptStore(2, time.Hour)
+
+	for _, id := range []string{"a", "b", "c"} {
+		store.Add(domain.ContactFormReceipt{ID: id, Status: "accepted"})
+		*now = now.Add(time.Second)
+	}
+
+	_, isFound := store.Get("a")
+	assert.False(t, isFound)
+	for _, id := range []string{"b", "c"} {
+		_, isFound = store.Get(id)
+		assert.True(t, isFound, id)
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+// TracerName names the tracer the application's spans are created with
+const TracerName = "github.com/adbourne/website-seacitysoftware"
+
+// startSpan starts a span for a call made by one of the services, as a child of any span in the context
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// recordSpanError marks the span as failed if there is an error
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// TracingTransport is an http.RoundTripper which creates a span for every outbound request. The W3C trace context is
+// sent with every request apart from those to the hosts it is withheld from, e.g. third parties which shouldn't be told
+// about the trace
+type TracingTransport struct {
+	// Base makes the requests, http.DefaultTransport is used when it is nil
+	Base http.RoundTripper
+
+	// NoPropagateHosts are the hosts the trace context isn't sent to, e.g. reCAPTCHA
+	NoPropagateHosts map[string]bool
+}
+
+func (transport *TracingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := otel.Tracer(TracerName).Start(request.Context(), "HTTP "+request.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", request.Method),
+			attribute.String("server.address", request.URL.Hostname()),
+			attribute.String("url.path", request.URL.Path),
+		))
+	defer span.End()
+
+	if !transport.NoPropagateHosts[strings.ToLower(request.URL.Hostname())] {
+		// A RoundTripper mustn't change the request it is given
+		request = request.Clone(ctx)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+	}
+
+	response, err := base.RoundTrip(request)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+	if response.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+	}
+	return response, nil
+}
+
+// NewTracingTransport creates a new TracingTransport, sending the trace context to every host but those given
+func NewTracingTransport(base http.RoundTripper, noPropagateHosts []string) *TracingTransport {
+	hosts := make(map[string]bool, len(noPropagateHosts))
+	for _, host := range noPropagateHosts {
+		hosts[strings.ToLower(host)] = true
+	}
+
+	return &TracingTransport{
+		Base:             base,
+		NoPropagateHosts: hosts,
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordSpans records the spans created until the returned func is called
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return recorder, func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	}
+}
+
+func TestRecaptchaVerificationIsTracedWithoutSendingTheTraceContext(t *testing.T) {
+	recorder, stopRecording := recordSpans()
+	defer stopRecording()
+
+	var receivedTraceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedTraceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	httpClient := newTestRecaptchaHttpClient(server)
+	httpClient.Transport = NewTracingTransport(httpClient.Transport, splitConfigList(defaultTracingNoPropagateHosts))
+	service := NewDefaultRecaptchaService("hunter2", &noopLogger{}, httpClient, NewPrometheusMetrics())
+
+	err := service.Verify(context.Background(), "a-response")
+	assert.EqualError(t, err, NotVerifiedError)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	outbound, verify := spans[0], spans[1]
+	assert.Equal(t, "HTTP POST", outbound.Name())
+	assert.Equal(t, "RecaptchaService.Verify", verify.Name())
+	assert.Equal(t, verify.SpanContext().SpanID(), outbound.Parent().SpanID())
+	assert.Equal(t, "Error", verify.Status().Code.String())
+
+	// Recaptcha is a third party, so isn't told about the trace by default
+	assert.Empty(t, receivedTraceparent)
+}
+
+func TestTheTraceContextIsNotSentToTheHostsItIsWithheldFrom(t *testing.T) {
+	_, stopRecording := recordSpans()
+	defer stopRecording()
+
+	var receivedTraceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedTraceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	send := func(noPropagateHosts []string) string {
+		receivedTraceparent = ""
+		ctx, span := startSpan(context.Background(), "test")
+		defer span.End()
+
+		request, _ := http.NewRequest("GET", server.URL, nil)
+		client := &http.Client{Transport: NewTracingTransport(http.DefaultTransport, noPropagateHosts)}
+		response, err := client.Do(request.WithContext(ctx))
+		if assert.NoError(t, err) {
+			response.Body.Close()
+		}
+		assert.Empty(t, request.Header.Get("traceparent"), "the request given to the transport was changed")
+		return receivedTraceparent
+	}
+
+	assert.Empty(t, send([]string{"127.0.0.1"}))
+	assert.NotEmpty(t, send([]string{"www.google.com"}))
+	assert.NotEmpty(t, send(nil))
+}
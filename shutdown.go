@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/pkg/errors"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+)
+
+// shutdownSignals gracefully shut the application down, ECS sends SIGTERM when stopping a task
+var shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+
+// workersNotFinishedError is returned when background workers are still running at the shutdown deadline
+const workersNotFinishedError = "background workers did not finish before the shutdown deadline"
+
+// newShutdownContext creates a context which is cancelled when the process receives a shutdown signal. Only the first
+// signal is trapped, a second one kills the process as usual
+func newShutdownContext(logger services.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, shutdownSignals...)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		select {
+		case sig := <-signals:
+			logger.Info("Received signal, shutting down", services.Fields{"signal": sig.String()})
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// Go runs the worker in the background. RunApp waits for every worker to return before it does, so workers must return
+// once the context given to RunApp is done. A worker which panics is logged and reported rather than taking the
+// application down
+func (ctx *AppContext) Go(worker func()) {
+	ctx.workers.Add(1)
+	go func() {
+		defer ctx.workers.Done()
+		defer ctx.recoverWorker()
+		worker()
+	}()
+}
+
+// recoverWorker recovers from a panic in a background worker, logging and reporting it
+func (ctx *AppContext) recoverWorker() {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	err := errors.Errorf("panic: %v", value)
+	ctx.Logger.Error("Recovered from a panic in a background worker", services.Fields{
+		"error": err.Error(),
+		"stack": string(stack),
+	})
+	ctx.CurrentErrorReporter().Report(context.Background(), &services.ErrorEvent{
+		Err:   err,
+		Stack: stack,
+		Panic: true,
+		Tags:  map[string]string{"source": errorSourceBackground},
+	})
+}
+
+// waitForWorkers waits for the background workers to return, giving up when the context is done
+func (ctx *AppContext) waitForWorkers(deadline context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		ctx.workers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-deadline.Done():
+		return errors.New(workersNotFinishedError)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/tls"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// certificateCheckInterval is how often the certificate files are checked for changes
+const certificateCheckInterval = 30 * time.Second
+
+// certificateSource provides the certificates HTTPS is served with. Both autocert.Manager and reloadingCertificate
+// are certificate sources
+type certificateSource interface {
+	// TLSConfig creates the TLS config for the HTTPS server
+	TLSConfig() *tls.Config
+
+	// HTTPHandler wraps the handler of the plain HTTP listener, e.g. to answer ACME challenges
+	HTTPHandler(fallback http.Handler) http.Handler
+}
+
+// newCertificateSource creates the certificate source for the TLS config, either the configured certificate files or
+// certificates got automatically from an ACME certificate authority
+func newCertificateSource(tlsConfig *domain.TLSConfig, logger services.Logger) (certificateSource, error) {
+	if !tlsConfig.UsesAcme() {
+		return newReloadingCertificate(tlsConfig.CertFile, tlsConfig.KeyFile, logger)
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(tlsConfig.AcmeDomains...),
+		Cache:      autocert.DirCache(tlsConfig.AcmeCacheDir),
+		Email:      tlsConfig.AcmeEmail,
+	}
+	if len(tlsConfig.AcmeDirectoryURL) > 0 {
+		manager.Client = &acme.Client{DirectoryURL: tlsConfig.AcmeDirectoryURL}
+	}
+
+	logger.Info("Getting certificates automatically", services.Fields{
+		"domains":  tlsConfig.AcmeDomains,
+		"cacheDir": tlsConfig.AcmeCacheDir,
+	})
+	return manager, nil
+}
+
+// newServerTLSConfig creates the TLS config for the HTTPS server
+func newServerTLSConfig(certificates certificateSource) *tls.Config {
+	tlsConfig := certificates.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return tlsConfig
+}
+
+// reloadingCertificate serves a certificate from files, reloading it when the files change so renewed certificates
+// are picked up without a restart. The files are checked by Watch rather than during handshakes, and the current
+// certificate is kept if the new one can't be loaded
+type reloadingCertificate struct {
+	certFile string
+
+	keyFile string
+
+	logger services.Logger
+
+	// load loads the certificate from the files, replaced in tests
+	load func(certFile string, keyFile string) (tls.Certificate, error)
+
+	// certificate holds the *tls.Certificate being served, it is read for every handshake so is swapped atomically
+	certificate atomic.Value
+
+	// version identifies the version of the files the certificate was loaded from, it is only used by Watch
+	version string
+
+	// failedVersion is the version of the files which last failed to load, so the failure is only logged once
+	failedVersion string
+}
+
+// newReloadingCertificate loads the certificate from the files, returning an error if it can't
+func newReloadingCertificate(certFile string, keyFile string, logger services.Logger) (*reloadingCertificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Loaded TLS certificate", services.Fields{"certFile": certFile})
+	rc := &reloadingCertificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+		load:     tls.LoadX509KeyPair,
+		version:  certificateFilesVersion(certFile, keyFile),
+	}
+	rc.certificate.Store(&certificate)
+	return rc, nil
+}
+
+// GetCertificate returns the current certificate
+func (rc *reloadingCertificate) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return rc.certificate.Load().(*tls.Certificate), nil
+}
+
+// Watch checks the files for changes every interval until done is closed, reloading the certificate when they change
+func (rc *reloadingCertificate) Watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			rc.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged reloads the certificate if the files have changed since it was loaded
+func (rc *reloadingCertificate) reloadIfChanged() {
+	version := certificateFilesVersion(rc.certFile, rc.keyFile)
+	if version == rc.version {
+		return
+	}
+
+	// The files may be part way through being replaced, so the version isn't recorded and it is retried next time
+	certificate, err := rc.load(rc.certFile, rc.keyFile)
+	if err != nil {
+		if version != rc.failedVersion {
+			rc.failedVersion = version
+			rc.logger.Warn("Unable to reload TLS certificate, keeping the current certificate", services.Fields{
+				"certFile": rc.certFile,
+				"error":    err.Error(),
+			})
+		}
+		return
+	}
+
+	rc.version = version
+	rc.certificate.Store(&certificate)
+	rc.logger.Info("Reloaded TLS certificate", services.Fields{"certFile": rc.certFile})
+}
+
+// TLSConfig creates a TLS config serving the certificate
+func (rc *reloadingCertificate) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: rc.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// HTTPHandler returns the fallback, there is nothing for plain HTTP to do for certificates from files
+func (rc *reloadingCertificate) HTTPHandler(fallback http.Handler) http.Handler {
+	return fallback
+}
+
+// certificateFilesVersion identifies the version of the certificate files by their modification times and sizes
+func certificateFilesVersion(paths ...string) string {
+	version := ""
+	for _, path := range paths {
+		modified, size := configFileVersion(path)
+		version += modified.String() + "/" + strconv.FormatInt(size, 10) + ";"
+	}
+	return version
+}
+
+// httpsRedirectHosts are the hosts plain HTTP requests may be redirected to, the ACME domains and the host of the
+// site's base URL
+func httpsRedirectHosts(appConfig *domain.AppConfig) []string {
+	var hosts []string
+	if appConfig.TLS != nil {
+		hosts = append(hosts, appConfig.TLS.AcmeDomains...)
+	}
+	if appConfig.Site != nil {
+		if baseURL, err := url.Parse(appConfig.Site.BaseURL); err == nil && len(baseURL.Hostname()) > 0 {
+			hosts = append(hosts, baseURL.Hostname())
+		}
+	}
+	return hosts
+}
+
+// newHTTPSRedirectHandler creates the handler for the plain HTTP listener when serving HTTPS, it permanently redirects
+// requests for one of the hosts to the same URL over HTTPS. Requests for any other host are rejected with a 400 rather
+// than redirected, so a forged Host header can't send the client to another site. The liveness and readiness
+// endpoints are served by probes instead, so load balancer and kubelet probes of the HTTP port get the instance's
+// status rather than a redirect, whichever host they ask for
+func newHTTPSRedirectHandler(hosts []string, httpsPort int, probes http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == livenessPath || r.URL.Path == readinessPath {
+			probes.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isRedirectHost(hosts, host) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		// 308 keeps the method and body of anything other than a GET
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// isRedirectHost is true when the host is one of the hosts, ignoring case as host names do
+func isRedirectHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
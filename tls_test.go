@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self signed certificate for localhost and its key to the directory
+func writeTestCertificate(t *testing.T, dir string, serialNumber int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "unable to generate key")
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "unable to create certificate")
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "unable to marshal key")
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.NoError(t, err, "unable to write certificate")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	assert.NoError(t, err, "unable to write key")
+
+	// Make sure the change is seen even on file systems with coarse modification times
+	modified := time.Now().Add(time.Duration(serialNumber) * time.Second)
+	os.Chtimes(certFile, modified, modified)
+	os.Chtimes(keyFile, modified, modified)
+
+	return certFile, keyFile
+}
+
+func serialNumberOf(t *testing.T, rc *reloadingCertificate) int64 {
+	certificate, err := rc.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.NoError(t, err, "unable to parse certificate")
+	return leaf.SerialNumber.Int64()
+}
+
+func TestACertificateIsReloadedWhenItsFilesChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err, "unable to create temp dir")
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir, 1)
+	rc, err := newReloadingCertificate(certFile, keyFile, newLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), serialNumberOf(t, rc))
+
+	writeTestCertificate(t, dir, 2)
+	rc.reloadIfChanged()
+	assert.Equal(t, int64(2), serialNumberOf(t, rc))
+
+	// A broken certificate is ignored
+	err = ioutil.WriteFile(certFile, []byte("not a certificate"), 0600)
+	assert.NoError(t, err, "unable to write certificate")
+	rc.reloadIfChanged()
+	assert.Equal(t, int64(2), serialNumberOf(t, rc))
+}
+
+func TestACertificateWhichFailsToReloadIsRetried(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err, "unable to create temp dir")
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir, 1)
+	rc, err := newReloadingCertificate(certFile, keyFile, newLogger())
+	assert.NoError(t, err)
+
+	// The files are read while they are part way through being written, then not changed again
+	writeTestCertificate(t, dir, 2)
+	rc.load = func(string, string) (tls.Certificate, error) {
+		return tls.Certificate{}, errors.New("tls: private key does not match public key")
+	}
+	rc.reloadIfChanged()
+	assert.Equal(t, int64(1), serialNumberOf(t, rc))
+
+	rc.load = tls.LoadX509KeyPair
+	rc.reloadIfChanged()
+	assert.Equal(t, int64(2), serialNumberOf(t, rc))
+}
+
+// probesHandler stands in for the site, answering every request with a 200
+var probesHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestHTTPRequestsAreRedirectedToHTTPS(t *testing.T) {
+	handler := newHTTPSRedirectHandler([]string{"example.com"}, 8443, probesHandler)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com:8080/contact?ref=1", nil))
+	assert.Equal(t, http.StatusMovedPermanently, recorder.Code)
+	assert.Equal(t, "https://example.com:8443/contact?ref=1", recorder.Header().Get("Location"))
+
+	handler = newHTTPSRedirectHandler([]string{"example.com"}, 443, probesHandler)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "http://EXAMPLE.com/contact", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, recorder.Code)
+	assert.Equal(t, "https://EXAMPLE.com/contact", recorder.Header().Get("Location"))
+}
+
+func TestHTTPRequestsForOtherHostsAreRejected(t *testing.T) {
+	handler := newHTTPSRedirectHandler([]string{"example.com"}, 443, probesHandler)
+
+	for _, host := range []string{"evil.example.net", "example.com.evil.example.net", ""} {
+		request := httptest.NewRequest("GET", "http://example.com/contact", nil)
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, host)
+		assert.Empty(t, recorder.Header().Get("Location"), host)
+	}
+}
+
+func TestTheHTTPSRedirectHostsAreTheAcmeDomainsAndTheBaseURLsHost(t *testing.T) {
+	appConfig := &domain.AppConfig{
+		TLS:  &domain.TLSConfig{AcmeDomains: []string{"www.example.com", "example.com"}},
+		Site: &domain.SiteConfig{BaseURL: "https://www.example.com:8443/"},
+	}
+
+	assert.Equal(t, []string{"www.example.com", "example.com", "www.example.com"}, httpsRedirectHosts(appConfig))
+}
+
+func TestHealthProbesAreAnsweredOverHTTP(t *testing.T) {
+	handler := newHTTPSRedirectHandler(nil, 8443, probesHandler)
+
+	for _, path := range []string{livenessPath, readinessPath} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://example.com:8080"+path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+		assert.Empty(t, recorder.Header().Get("Location"), path)
+	}
+}
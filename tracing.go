@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"github.com/adbourne/website-seacitysoftware/domain"
+	"github.com/adbourne/website-seacitysoftware/services"
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+const (
+	// tracingServiceName is the name of the service the traces are exported as
+	tracingServiceName = "website-seacitysoftware"
+
+	// tracingShutdownTimeout is how long is spent exporting the remaining traces when the application exits
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// shutdownTracingFunc flushes any traces which haven't been exported yet and stops exporting them
+type shutdownTracingFunc func(ctx context.Context) error
+
+// setupTracing reads W3C trace context from requests and, when tracing is enabled, exports the traces to the OTLP
+// collector. When it is disabled spans aren't recorded
+func setupTracing(appConfig *domain.AppConfig, logger services.Logger) (shutdownTracingFunc, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("Unable to export traces", services.Fields{"error": err.Error()})
+	}))
+
+	if !appConfig.TracingEnabled {
+		return func(_ context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(appConfig.TracingEndpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracingServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	logger.Info("Exporting traces", services.Fields{"endpoint": appConfig.TracingEndpoint})
+	return provider.Shutdown, nil
+}
+
+// newTracingMiddleware creates the middleware tracing every request. The trace of a caller which sent a traceparent
+// header is only continued when incoming trace context is trusted, otherwise anyone could add their spans to a trace
+// of ours, so a new trace linked to the caller's is started. It comes before the metrics, access log and error
+// handling middleware so their work is part of the span and the status of the error page is the one recorded
+func newTracingMiddleware(appCtx *AppContext) echo.MiddlewareFunc {
+	tracer := otel.Tracer(services.TracerName)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+
+			options := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindServer)}
+			if caller := trace.SpanContextFromContext(ctx); caller.IsValid() && !appCtx.CurrentConfig().TracingTrustIncoming {
+				options = append(options, trace.WithNewRoot(), trace.WithLinks(trace.Link{SpanContext: caller}))
+			}
+			ctx, span := tracer.Start(ctx, request.Method, options...)
+			defer span.End()
+
+			c.SetRequest(request.WithContext(ctx))
+
+			err := next(c)
+
+			route := c.Path()
+			if len(route) <= 0 {
+				route = unmatchedRoute
+			}
+
+			status := c.Response().Status
+			span.SetName(request.Method + " " + route)
+			span.SetAttributes(
+				attribute.String("http.request.method", request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", request.URL.Path),
+				attribute.Int("http.response.status_code", status),
+			)
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+
+			return err
+		}
+	}
+}
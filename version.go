@@ -0,0 +1,5 @@
+package main
+
+// version is the version the application was built as, set at build time with
+// -ldflags "-X main.version=<version>". It is the release errors are reported from
+var version = "dev"